@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/globals"
+	"github.com/dave/gpt/gpx"
+	"github.com/dave/gpt/kml"
+	"github.com/dave/gpt/routedata"
+)
+
+// locate finds the nearest regular route and option for each mode to a lat/lon given on the command line.
+func locate(data *routedata.Data, args []string, radius float64) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: gpt locate <lat> <lon>")
+	}
+	lat, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return fmt.Errorf("parsing latitude %q: %w", args[0], err)
+	}
+	lon, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return fmt.Errorf("parsing longitude %q: %w", args[1], err)
+	}
+	pos := geo.Pos{Lat: lat, Lon: lon}
+	for _, mode := range globals.MODES {
+		for _, required := range globals.REQUIRED_TYPES {
+			var description string
+			switch required {
+			case globals.REGULAR:
+				description = "regular route"
+			case globals.OPTIONAL:
+				description = "option"
+			}
+			if mode == globals.HIKE {
+				description = "Hiking " + description
+			} else {
+				description = "Packrafting " + description
+			}
+			location := data.Locate(pos, mode, required, radius)
+			if location == nil {
+				fmt.Printf("%s: none within %.0f km\n", description, radius)
+				continue
+			}
+			fmt.Printf("%s: %s\n", description, location.Route.Debug())
+			fmt.Printf("  section:   %s\n", location.Route.Section.FolderName())
+			fmt.Printf("  segment:   %s\n", location.Segment.PlacemarkName())
+			fmt.Printf("  chainage:  %.1f km\n", location.Chainage())
+			fmt.Printf("  off-track: %.0f m\n", location.Distance*1000)
+			fmt.Printf("  nearest:   %.5f, %.5f\n", location.Pos.Lat, location.Pos.Lon)
+		}
+	}
+	return nil
+}
+
+// compare aligns recorded gpx tracks given on the command line to the routes, and writes a report and discrepancy kml
+// for each file.
+func compare(data *routedata.Data, args []string, dpath string, tolerance, newPath float64) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gpt compare <recorded.gpx> ...")
+	}
+	for _, fpath := range args {
+		root, err := gpx.Load(fpath)
+		if err != nil {
+			return fmt.Errorf("loading %q: %w", fpath, err)
+		}
+		comparison := data.Compare(filepath.Base(fpath), recordedLines(root), tolerance, newPath)
+		fmt.Print(comparison.Report())
+		if err := comparison.Save(dpath); err != nil {
+			return fmt.Errorf("saving comparison of %q: %w", fpath, err)
+		}
+	}
+	return nil
+}
+
+// recordedLines extracts the track segments and routes from a recorded gpx file.
+func recordedLines(root gpx.Root) []geo.Line {
+	var lines []geo.Line
+	for _, track := range root.Tracks {
+		for _, segment := range track.Segments {
+			lines = append(lines, segment.Line())
+		}
+	}
+	for _, route := range root.Routes {
+		line := make(geo.Line, len(route.Points))
+		for i, point := range route.Points {
+			line[i] = point.Pos()
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// verify aggregates many recorded gpx tracks per segment, and writes a review report and a patch kmz of suggested
+// changes.
+func verify(data *routedata.Data, args []string, dpath string, tolerance float64, recordings int) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gpt verify <recorded.gpx> ...")
+	}
+	var comparisons []*routedata.Comparison
+	for _, fpath := range args {
+		root, err := gpx.Load(fpath)
+		if err != nil {
+			return fmt.Errorf("loading %q: %w", fpath, err)
+		}
+		comparisons = append(comparisons, data.Compare(filepath.Base(fpath), recordedLines(root), tolerance, 0))
+	}
+	verification := data.Verify(comparisons, recordings)
+	fmt.Print(verification.Report())
+	if err := verification.Save(dpath); err != nil {
+		return fmt.Errorf("saving verification: %w", err)
+	}
+	return nil
+}
+
+// itinerary builds a continuous route for each itinerary spec given on the command line.
+func itinerary(data *routedata.Data, args []string, dpath string, simplify geo.Simplification) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gpt itinerary <trip.yaml> ...")
+	}
+	for _, fpath := range args {
+		spec, err := routedata.LoadItinerarySpec(fpath)
+		if err != nil {
+			return fmt.Errorf("loading %q: %w", fpath, err)
+		}
+		it, err := data.Itinerary(spec)
+		if err != nil {
+			return fmt.Errorf("building itinerary %q: %w", spec.Name, err)
+		}
+		fmt.Print(it.Report())
+		if err := data.SaveItinerary(dpath, it, simplify); err != nil {
+			return fmt.Errorf("saving itinerary %q: %w", spec.Name, err)
+		}
+	}
+	return nil
+}
+
+// route finds the lowest cost path along any regular or optional route between two positions given on the command
+// line.
+func route(data *routedata.Data, args []string, dpath, modeFlag, costFlag, avoidFlag string, avoidInvestigation bool, radius float64) error {
+	if len(args) != 4 {
+		return fmt.Errorf("usage: gpt route <from lat> <from lon> <to lat> <to lon>")
+	}
+	var coords []float64
+	for _, arg := range args {
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Errorf("parsing coordinate %q: %w", arg, err)
+		}
+		coords = append(coords, v)
+	}
+	var mode globals.ModeType
+	switch modeFlag {
+	case "hiking":
+		mode = globals.HIKE
+	case "packrafting":
+		mode = globals.RAFT
+	default:
+		return fmt.Errorf("unknown mode %q", modeFlag)
+	}
+	cost := routedata.RoutingCost{AvoidInvestigation: avoidInvestigation}
+	switch costFlag {
+	case "distance":
+	case "time":
+		cost.Time = true
+	default:
+		return fmt.Errorf("unknown cost %q", costFlag)
+	}
+	if avoidFlag != "" {
+		cost.Avoid = strings.Split(avoidFlag, ",")
+	}
+	from := geo.Pos{Lat: coords[0], Lon: coords[1]}
+	to := geo.Pos{Lat: coords[2], Lon: coords[3]}
+	plan, err := data.BuildGraph(mode).Route(from, to, cost, radius)
+	if err != nil {
+		return err
+	}
+	fmt.Print(plan.Report())
+	if err := plan.Save(dpath); err != nil {
+		return fmt.Errorf("saving route: %w", err)
+	}
+	return nil
+}
+
+// merge applies a patch kmz given on the command line to the master file before it is scanned.
+func merge(master *kml.Root, args []string, basePath string) (*routedata.MergeResult, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("usage: gpt merge <patch.kmz>")
+	}
+	patch, err := kml.Load(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("loading patch %q: %w", args[0], err)
+	}
+	var base *kml.Root
+	if basePath != "" {
+		root, err := kml.Load(basePath)
+		if err != nil {
+			return nil, fmt.Errorf("loading base %q: %w", basePath, err)
+		}
+		base = &root
+	}
+	result := routedata.Merge(master, patch, base)
+	fmt.Printf("merge: %d replaced, %d added, %d removed, %d conflicts\n", len(result.Replaced), len(result.Added), len(result.Removed), len(result.Conflicts))
+	return result, nil
+}
+
+// diff compares two versions of the master file given on the command line, and writes a changelog and a kmz of the
+// changed geometry.
+func diff(args []string, ele bool, dpath string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: gpt diff <old.kmz> <new.kmz>")
+	}
+	old, err := load(args[0], ele)
+	if err != nil {
+		return err
+	}
+	updated, err := load(args[1], ele)
+	if err != nil {
+		return err
+	}
+	changes := routedata.Diff(old, updated)
+	fmt.Print(changes.Markdown())
+	if err := changes.Save(dpath); err != nil {
+		return fmt.Errorf("saving changes: %w", err)
+	}
+	return nil
+}
+
+// load scans and normalises a master file.
+func load(fpath string, ele bool) (*routedata.Data, error) {
+	root, err := kml.Load(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("loading %q: %w", fpath, err)
+	}
+	data := &routedata.Data{Sections: map[globals.SectionKey]*routedata.Section{}}
+	if err := data.Scan(root, ele); err != nil {
+		return nil, fmt.Errorf("scanning %q: %w", fpath, err)
+	}
+	if err := data.Normalise(); err != nil {
+		return nil, fmt.Errorf("normalising %q: %w", fpath, err)
+	}
+	nameOptions(data)
+	return data, nil
+}
@@ -0,0 +1,249 @@
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dave/gpt/geo"
+)
+
+// Sport values from the FIT profile
+const (
+	SPORT_GENERIC  uint8 = 0
+	SPORT_HIKING   uint8 = 17
+	SPORT_PADDLING uint8 = 19
+)
+
+// Course point types from the FIT profile
+const (
+	POINT_GENERIC uint8 = 0
+	POINT_SUMMIT  uint8 = 1
+	POINT_WATER   uint8 = 3
+	POINT_FOOD    uint8 = 4
+	POINT_DANGER  uint8 = 5
+	POINT_LEFT    uint8 = 6
+	POINT_RIGHT   uint8 = 7
+)
+
+const (
+	courseNameSize = 32 // bytes including the null terminator
+	pointNameSize  = 16 // bytes including the null terminator
+)
+
+// Course is a FIT course file.
+type Course struct {
+	Name         string
+	Sport        uint8
+	Start        time.Time // timestamp of the first record
+	Speed        float64   // km/h, used to create timestamps for each record
+	Line         geo.Line
+	Distances    []float64 // cumulative distance in km for each position in Line
+	CoursePoints []CoursePoint
+}
+
+// CoursePoint is a point of interest along a course.
+type CoursePoint struct {
+	Pos      geo.Pos
+	Distance float64 // distance along the course in km
+	Name     string
+	Type     uint8
+}
+
+func (c Course) Save(fpath string) error {
+	dpath, _ := filepath.Split(fpath)
+	_ = os.MkdirAll(dpath, 0777)
+	var buf bytes.Buffer
+	if err := c.Encode(&buf); err != nil {
+		return fmt.Errorf("encoding fit: %w", err)
+	}
+	if err := ioutil.WriteFile(fpath, buf.Bytes(), 0666); err != nil {
+		return fmt.Errorf("writing fit file %q: %w", fpath, err)
+	}
+	return nil
+}
+
+// Encode writes the course as a FIT file.
+func (c Course) Encode(w io.Writer) error {
+	if len(c.Line) < 2 {
+		return fmt.Errorf("course %q has fewer than two points", c.Name)
+	}
+	if len(c.Distances) != len(c.Line) {
+		return fmt.Errorf("course %q has %d distances for %d points", c.Name, len(c.Distances), len(c.Line))
+	}
+	speed := c.Speed
+	if speed <= 0 {
+		speed = 4
+	}
+	timestamp := func(km float64) uint32 {
+		return fitTime(c.Start.Add(time.Duration((km - c.Distances[0]) / speed * float64(time.Hour))))
+	}
+	start, end := c.Line.Start(), c.Line.End()
+	var ascent, descent float64
+	for i := 1; i < len(c.Line); i++ {
+		if d := c.Line[i].Ele - c.Line[i-1].Ele; d > 0 {
+			ascent += d
+		} else {
+			descent -= d
+		}
+	}
+	total := c.Distances[len(c.Distances)-1] - c.Distances[0]
+	elapsed := uint32(total / speed * 3600 * 1000)
+
+	e := &encoder{}
+
+	e.define(0, 0, field{0, 1, ENUM}, field{1, 2, UINT16}, field{2, 2, UINT16}, field{4, 4, UINT32})
+	e.data(0, uint8(6), uint16(255), uint16(0), fitTime(c.Start)) // type: course, manufacturer: development
+
+	e.define(1, 31, field{5, courseNameSize, STRING}, field{4, 1, ENUM})
+	e.data(1, fixedString(c.Name, courseNameSize), c.Sport)
+
+	e.define(2, 19,
+		field{253, 4, UINT32}, field{2, 4, UINT32},
+		field{3, 4, SINT32}, field{4, 4, SINT32}, field{5, 4, SINT32}, field{6, 4, SINT32},
+		field{7, 4, UINT32}, field{8, 4, UINT32}, field{9, 4, UINT32},
+		field{21, 2, UINT16}, field{22, 2, UINT16},
+	)
+	e.data(2,
+		timestamp(c.Distances[0]), timestamp(c.Distances[0]),
+		semicircles(start.Lat), semicircles(start.Lon), semicircles(end.Lat), semicircles(end.Lon),
+		elapsed, elapsed, uint32(total*1000*100),
+		uint16(math.Round(ascent)), uint16(math.Round(descent)),
+	)
+
+	e.define(3, 21, field{253, 4, UINT32}, field{0, 1, ENUM}, field{1, 1, ENUM}, field{4, 1, UINT8})
+	e.data(3, timestamp(c.Distances[0]), uint8(0), uint8(0), uint8(0)) // timer start
+
+	e.define(4, 20, field{253, 4, UINT32}, field{0, 4, SINT32}, field{1, 4, SINT32}, field{2, 2, UINT16}, field{5, 4, UINT32})
+	for i, pos := range c.Line {
+		e.data(4, timestamp(c.Distances[i]), semicircles(pos.Lat), semicircles(pos.Lon), altitude(pos.Ele), uint32(math.Round(c.Distances[i]*1000*100)))
+	}
+
+	e.data(3, timestamp(c.Distances[len(c.Distances)-1]), uint8(0), uint8(4), uint8(0)) // timer stop all
+
+	if len(c.CoursePoints) > 0 {
+		e.define(5, 32, field{254, 2, UINT16}, field{1, 4, UINT32}, field{2, 4, SINT32}, field{3, 4, SINT32}, field{4, 4, UINT32}, field{5, 1, ENUM}, field{6, pointNameSize, STRING})
+		for i, p := range c.CoursePoints {
+			e.data(5, uint16(i), timestamp(p.Distance), semicircles(p.Pos.Lat), semicircles(p.Pos.Lon), uint32(math.Round(p.Distance*1000*100)), p.Type, fixedString(p.Name, pointNameSize))
+		}
+	}
+
+	body := e.buf.Bytes()
+	header := make([]byte, 14)
+	header[0] = 14
+	header[1] = 0x20 // protocol 2.0
+	binary.LittleEndian.PutUint16(header[2:], 2132)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(body)))
+	copy(header[8:], ".FIT")
+	binary.LittleEndian.PutUint16(header[12:], crc(0, header[:12]))
+
+	sum := crc(crc(0, header), body)
+	trailer := make([]byte, 2)
+	binary.LittleEndian.PutUint16(trailer, sum)
+
+	for _, b := range [][]byte{header, body, trailer} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Base types from the FIT protocol
+const (
+	ENUM   uint8 = 0x00
+	UINT8  uint8 = 0x02
+	SINT32 uint8 = 0x85
+	UINT16 uint8 = 0x84
+	UINT32 uint8 = 0x86
+	STRING uint8 = 0x07
+)
+
+type field struct {
+	number, size, base uint8
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+// define writes a definition message, which assigns a local message type to a global message with a list of fields.
+func (e *encoder) define(local uint8, global uint16, fields ...field) {
+	e.buf.WriteByte(0x40 | local)
+	e.buf.WriteByte(0) // reserved
+	e.buf.WriteByte(0) // little endian
+	_ = binary.Write(&e.buf, binary.LittleEndian, global)
+	e.buf.WriteByte(uint8(len(fields)))
+	for _, f := range fields {
+		e.buf.Write([]byte{f.number, f.size, f.base})
+	}
+}
+
+// data writes a data message. Values must be fixed size and in the same order as the definition.
+func (e *encoder) data(local uint8, values ...interface{}) {
+	e.buf.WriteByte(local)
+	for _, v := range values {
+		_ = binary.Write(&e.buf, binary.LittleEndian, v)
+	}
+}
+
+var crcTable = [16]uint16{
+	0x0000, 0xCC01, 0xD801, 0x1400, 0xF001, 0x3C00, 0x2800, 0xE401,
+	0xA001, 0x6C00, 0x7800, 0xB401, 0x5000, 0x9C01, 0x8801, 0x4400,
+}
+
+func crc(sum uint16, b []byte) uint16 {
+	for _, v := range b {
+		tmp := crcTable[sum&0xF]
+		sum = (sum >> 4) & 0x0FFF
+		sum = sum ^ tmp ^ crcTable[v&0xF]
+		tmp = crcTable[sum&0xF]
+		sum = (sum >> 4) & 0x0FFF
+		sum = sum ^ tmp ^ crcTable[(v>>4)&0xF]
+	}
+	return sum
+}
+
+// FIT timestamps are seconds since 1989-12-31 00:00 UTC
+var epoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+func fitTime(t time.Time) uint32 {
+	return uint32(t.Sub(epoch) / time.Second)
+}
+
+func semicircles(degrees float64) int32 {
+	return int32(math.Round(degrees * (1 << 31) / 180))
+}
+
+// altitude is encoded in units of 0.2m with an offset of 500m.
+func altitude(metres float64) uint16 {
+	v := math.Round((metres + 500) * 5)
+	if v < 0 {
+		v = 0
+	} else if v > math.MaxUint16-1 {
+		v = math.MaxUint16 - 1
+	}
+	return uint16(v)
+}
+
+// fixedString is a null terminated string padded to size bytes. Strings that are too long are truncated at a rune
+// boundary.
+func fixedString(s string, size int) []byte {
+	b := make([]byte, size)
+	var n int
+	for _, r := range s {
+		l := len(string(r))
+		if n+l > size-1 {
+			break
+		}
+		copy(b[n:], string(r))
+		n += l
+	}
+	return b
+}
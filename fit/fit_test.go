@@ -0,0 +1,172 @@
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/dave/gpt/geo"
+)
+
+// decoded is a FIT file split into messages.
+type decoded struct {
+	definitions map[uint16][]field  // global message number: fields
+	messages    map[uint16][][]byte // global message number: data messages
+}
+
+// decode checks the header and CRC of a FIT file and splits the body into messages.
+func decode(t *testing.T, b []byte) decoded {
+	t.Helper()
+	if len(b) < 16 {
+		t.Fatalf("file is only %d bytes", len(b))
+	}
+	header := b[:14]
+	if header[0] != 14 {
+		t.Errorf("expected header size 14, found %d", header[0])
+	}
+	if header[1] != 0x20 {
+		t.Errorf("expected protocol 2.0, found %x", header[1])
+	}
+	if string(header[8:12]) != ".FIT" {
+		t.Errorf("expected .FIT, found %q", header[8:12])
+	}
+	if sum := binary.LittleEndian.Uint16(header[12:]); sum != crc(0, header[:12]) {
+		t.Errorf("header crc %x doesn't match %x", sum, crc(0, header[:12]))
+	}
+	size := binary.LittleEndian.Uint32(header[4:])
+	if int(size) != len(b)-16 {
+		t.Fatalf("header data size %d, found %d bytes", size, len(b)-16)
+	}
+	// the crc of the whole file including the trailer is zero
+	if sum := crc(0, b); sum != 0 {
+		t.Errorf("file crc check failed: %x", sum)
+	}
+
+	d := decoded{definitions: map[uint16][]field{}, messages: map[uint16][][]byte{}}
+	locals := map[uint8]uint16{} // local message type: global message number
+	body := b[14 : len(b)-2]
+	for i := 0; i < len(body); {
+		record := body[i]
+		local := record & 0x0F
+		if record&0x40 != 0 {
+			if body[i+2] != 0 {
+				t.Fatalf("expected little endian definition at %d", i)
+			}
+			global := binary.LittleEndian.Uint16(body[i+3:])
+			count := int(body[i+5])
+			var fields []field
+			for j := 0; j < count; j++ {
+				f := body[i+6+j*3:]
+				fields = append(fields, field{number: f[0], size: f[1], base: f[2]})
+			}
+			locals[local] = global
+			d.definitions[global] = fields
+			i += 6 + count*3
+			continue
+		}
+		global, ok := locals[local]
+		if !ok {
+			t.Fatalf("data message at %d for undefined local type %d", i, local)
+		}
+		var size int
+		for _, f := range d.definitions[global] {
+			size += int(f.size)
+		}
+		d.messages[global] = append(d.messages[global], body[i+1:i+1+size])
+		i += 1 + size
+	}
+	return d
+}
+
+// value finds the bytes of a field in a data message.
+func (d decoded) value(global uint16, message []byte, number uint8) []byte {
+	var offset int
+	for _, f := range d.definitions[global] {
+		if f.number == number {
+			return message[offset : offset+int(f.size)]
+		}
+		offset += int(f.size)
+	}
+	return nil
+}
+
+func TestEncode(t *testing.T) {
+	course := Course{
+		Name:      "GPT24 Packrafting",
+		Sport:     SPORT_PADDLING,
+		Start:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Line:      geo.Line{{Lat: -45, Lon: -72, Ele: 100}, {Lat: -45.01, Lon: -72, Ele: 150}, {Lat: -45.01, Lon: -71.99, Ele: 120}},
+		Distances: []float64{10, 11.112, 11.898},
+		CoursePoints: []CoursePoint{
+			{Pos: geo.Pos{Lat: -45.01, Lon: -72}, Distance: 11.112, Name: "Left", Type: POINT_LEFT},
+		},
+	}
+	buf := &bytes.Buffer{}
+	if err := course.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	d := decode(t, buf.Bytes())
+
+	expected := map[uint16]int{0: 1, 31: 1, 19: 1, 21: 2, 20: 3, 32: 1}
+	for global, count := range expected {
+		if len(d.messages[global]) != count {
+			t.Errorf("message %d: expected %d, found %d", global, count, len(d.messages[global]))
+		}
+	}
+
+	if v := d.value(0, d.messages[0][0], 0); v[0] != 6 {
+		t.Errorf("expected file type course, found %d", v[0])
+	}
+	if v := d.value(31, d.messages[31][0], 4); v[0] != SPORT_PADDLING {
+		t.Errorf("expected sport paddling, found %d", v[0])
+	}
+	if v := d.value(31, d.messages[31][0], 5); string(bytes.TrimRight(v, "\x00")) != course.Name {
+		t.Errorf("expected name %q, found %q", course.Name, v)
+	}
+
+	for i, message := range d.messages[20] {
+		lat := int32(binary.LittleEndian.Uint32(d.value(20, message, 0)))
+		if lat != semicircles(course.Line[i].Lat) {
+			t.Errorf("record %d: expected lat %d, found %d", i, semicircles(course.Line[i].Lat), lat)
+		}
+		distance := binary.LittleEndian.Uint32(d.value(20, message, 5))
+		if expected := uint32(math.Round(course.Distances[i] * 100000)); distance != expected {
+			t.Errorf("record %d: expected distance %d, found %d", i, expected, distance)
+		}
+		ele := binary.LittleEndian.Uint16(d.value(20, message, 2))
+		if expected := uint16((course.Line[i].Ele + 500) * 5); ele != expected {
+			t.Errorf("record %d: expected altitude %d, found %d", i, expected, ele)
+		}
+	}
+
+	lap := d.messages[19][0]
+	if ascent := binary.LittleEndian.Uint16(d.value(19, lap, 21)); ascent != 50 {
+		t.Errorf("expected 50 m ascent, found %d", ascent)
+	}
+	if descent := binary.LittleEndian.Uint16(d.value(19, lap, 22)); descent != 30 {
+		t.Errorf("expected 30 m descent, found %d", descent)
+	}
+
+	point := d.messages[32][0]
+	if v := d.value(32, point, 5); v[0] != POINT_LEFT {
+		t.Errorf("expected course point type left, found %d", v[0])
+	}
+	if v := d.value(32, point, 6); string(bytes.TrimRight(v, "\x00")) != "Left" {
+		t.Errorf("expected course point name Left, found %q", v)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	for name, course := range map[string]Course{
+		"one point":          {Line: geo.Line{{}}, Distances: []float64{0}},
+		"distances mismatch": {Line: geo.Line{{}, {Lat: 1}}, Distances: []float64{0}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := course.Encode(&bytes.Buffer{}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -69,6 +69,81 @@ func (l Line) End() Pos {
 	return l[len(l)-1]
 }
 
+// Projection is the nearest position on a Line to another position.
+type Projection struct {
+	Index    int     // index in the line of the start of the nearest piece
+	Fraction float64 // fraction along the nearest piece
+	Pos      Pos     // the nearest position on the line
+	Distance float64 // distance in km from the original position to Pos
+}
+
+// Project finds the nearest position on the line to p. Unlike IsClose this considers the whole length of each piece
+// of the line, not just the vertices.
+func (l Line) Project(p Pos) Projection {
+	if len(l) == 1 {
+		return Projection{Pos: l[0], Distance: l[0].Distance(p)}
+	}
+	var best Projection
+	for i := 0; i < len(l)-1; i++ {
+		if proj := l.projectPiece(i, p); i == 0 || proj.Distance < best.Distance {
+			best = proj
+		}
+	}
+	return best
+}
+
+// Along is the distance in km along the line to the projected position.
+func (l Line) Along(proj Projection) float64 {
+	var total float64
+	for i := 1; i <= proj.Index; i++ {
+		total += l[i-1].Distance(l[i])
+	}
+	if proj.Index < len(l)-1 {
+		total += l[proj.Index].Distance(proj.Pos)
+	}
+	return total
+}
+
+// Hausdorff is the greatest distance in km from a position on either line to the nearest position on the other. Only
+// the vertices of each line are measured, against the whole length of the other line. The direction of the lines
+// makes no difference.
+func (l Line) Hausdorff(other Line) float64 {
+	var max float64
+	for _, pair := range [][2]Line{{l, other}, {other, l}} {
+		for _, p := range pair[0] {
+			if d := pair[1].Project(p).Distance; d > max {
+				max = d
+			}
+		}
+	}
+	return max
+}
+
+// projectPiece projects p onto the straight piece of line between l[i] and l[i+1]. A local flat approximation is
+// used to find the fraction along the piece, which is fine for the short distances between adjacent positions.
+func (l Line) projectPiece(i int, p Pos) Projection {
+	a, b := l[i], l[i+1]
+	cos := math.Cos(p.Lat * math.Pi / 180)
+	ax, ay := (a.Lon-p.Lon)*cos, a.Lat-p.Lat
+	bx, by := (b.Lon-p.Lon)*cos, b.Lat-p.Lat
+	dx, dy := bx-ax, by-ay
+	var f float64
+	if sq := dx*dx + dy*dy; sq > 0 {
+		f = -(ax*dx + ay*dy) / sq
+	}
+	if f < 0 {
+		f = 0
+	} else if f > 1 {
+		f = 1
+	}
+	pos := Pos{
+		Lat: a.Lat + (b.Lat-a.Lat)*f,
+		Lon: a.Lon + (b.Lon-a.Lon)*f,
+		Ele: a.Ele + (b.Ele-a.Ele)*f,
+	}
+	return Projection{Index: i, Fraction: f, Pos: pos, Distance: pos.Distance(p)}
+}
+
 func MergeLines(lines []Line) Line {
 	var totalLen int
 	for _, s := range lines {
@@ -86,32 +161,21 @@ type Pos struct {
 	Lat, Lon, Ele float64
 }
 
-// TODO: optimise this for short distances. Don't need trig?
+// IsClose is true if p2 is closer than km to p1. This uses the same distance as Index, so a position found by an
+// Index search is also close.
 func (p1 Pos) IsClose(p2 Pos, km float64) bool {
 	return p1.Distance(p2) < km
 }
 
-// distance in km to another location (only considering lat and lon)
+// Distance in km to another location (only considering lat and lon) using the haversine formula on a sphere with
+// the mean radius of the earth.
 func (p1 Pos) Distance(p2 Pos) float64 {
-	const PI float64 = 3.141592653589793
-
-	radlat1 := float64(PI * p1.Lat / 180)
-	radlat2 := float64(PI * p2.Lat / 180)
-
-	theta := float64(p1.Lon - p2.Lon)
-	radtheta := float64(PI * theta / 180)
-
-	dist := math.Sin(radlat1)*math.Sin(radlat2) + math.Cos(radlat1)*math.Cos(radlat2)*math.Cos(radtheta)
-
-	if dist > 1 {
-		dist = 1
+	lat1, lat2 := radians(p1.Lat), radians(p2.Lat)
+	dlat := lat2 - lat1
+	dlon := radians(p2.Lon - p1.Lon)
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	if a > 1 {
+		a = 1
 	}
-
-	dist = math.Acos(dist)
-	dist = dist * 180 / PI
-	dist = dist * 60 * 1.1515
-
-	dist = dist * 1.609344
-
-	return dist
+	return 2 * EarthRadius * math.Asin(math.Sqrt(a))
 }
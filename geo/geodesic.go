@@ -0,0 +1,181 @@
+package geo
+
+import (
+	"errors"
+	"math"
+)
+
+// EarthRadius is the mean radius of the earth in km.
+const EarthRadius = 6371.0088
+
+// kmPerDegree is the length of one degree of latitude on the sphere.
+const kmPerDegree = EarthRadius * math.Pi / 180
+
+// WGS84 ellipsoid
+const (
+	wgs84A = 6378.137              // semi-major axis in km
+	wgs84F = 1 / 298.257223563     // flattening
+	wgs84B = wgs84A * (1 - wgs84F) // semi-minor axis in km
+)
+
+// ErrNoConvergence is returned by Vincenty for nearly antipodal positions, along with the haversine distance.
+var ErrNoConvergence = errors.New("vincenty formula failed to converge")
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+func degrees(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
+
+// Vincenty is the distance in km to another location on the WGS84 ellipsoid, using Vincenty's inverse formula. It is
+// accurate to within a millimetre, but fails to converge for nearly antipodal positions, in which case the haversine
+// distance from Distance is returned with ErrNoConvergence.
+func (p1 Pos) Vincenty(p2 Pos) (float64, error) {
+	L := radians(p2.Lon - p1.Lon)
+	U1 := math.Atan((1 - wgs84F) * math.Tan(radians(p1.Lat)))
+	U2 := math.Atan((1 - wgs84F) * math.Tan(radians(p2.Lat)))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+
+	lambda := L
+	var sinSigma, cosSigma, sigma, cosSqAlpha, cos2SigmaM float64
+	for i := 0; ; i++ {
+		if i == 200 {
+			return p1.Distance(p2), ErrNoConvergence
+		}
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma = math.Sqrt((cosU2*sinLambda)*(cosU2*sinLambda) + (cosU1*sinU2-sinU1*cosU2*cosLambda)*(cosU1*sinU2-sinU1*cosU2*cosLambda))
+		if sinSigma == 0 {
+			return 0, nil // coincident points
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha = 1 - sinAlpha*sinAlpha
+		if cosSqAlpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		} else {
+			cos2SigmaM = 0 // equatorial line
+		}
+		C := wgs84F / 16 * cosSqAlpha * (4 + wgs84F*(4-3*cosSqAlpha))
+		prev := lambda
+		lambda = L + (1-C)*wgs84F*sinAlpha*(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prev) < 1e-12 {
+			break
+		}
+	}
+	uSq := cosSqAlpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+	return wgs84B * A * (sigma - deltaSigma), nil
+}
+
+// Bearing is the initial bearing in degrees (0 to 360, clockwise from north) of the great circle path to another
+// location.
+func (p1 Pos) Bearing(p2 Pos) float64 {
+	lat1, lat2 := radians(p1.Lat), radians(p2.Lat)
+	dlon := radians(p2.Lon - p1.Lon)
+	y := math.Sin(dlon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dlon)
+	return math.Mod(degrees(math.Atan2(y, x))+360, 360)
+}
+
+// FinalBearing is the bearing in degrees on arrival at another location along the great circle path.
+func (p1 Pos) FinalBearing(p2 Pos) float64 {
+	return math.Mod(p2.Bearing(p1)+180, 360)
+}
+
+// Destination is the location reached after travelling km along the great circle path with the initial bearing in
+// degrees. The elevation is copied from p.
+func (p Pos) Destination(bearing, km float64) Pos {
+	lat1, lon1 := radians(p.Lat), radians(p.Lon)
+	delta := km / EarthRadius
+	theta := radians(bearing)
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(delta) + math.Cos(lat1)*math.Sin(delta)*math.Cos(theta))
+	lon2 := lon1 + math.Atan2(math.Sin(theta)*math.Sin(delta)*math.Cos(lat1), math.Cos(delta)-math.Sin(lat1)*math.Sin(lat2))
+	return Pos{
+		Lat: degrees(lat2),
+		Lon: math.Mod(degrees(lon2)+540, 360) - 180,
+		Ele: p.Ele,
+	}
+}
+
+// SegmentDistance is the distance in km from p to the nearest point on the straight line between a and b.
+func (p Pos) SegmentDistance(a, b Pos) float64 {
+	return Line{a, b}.projectPiece(0, p).Distance
+}
+
+// Interpolate finds the position km along the line. Elevation is interpolated linearly. Distances before the start
+// or after the end of the line return the start or end.
+func (l Line) Interpolate(km float64) Pos {
+	if km <= 0 {
+		return l.Start()
+	}
+	var total float64
+	for i := 1; i < len(l); i++ {
+		d := l[i-1].Distance(l[i])
+		if total+d >= km && d > 0 {
+			f := (km - total) / d
+			return Pos{
+				Lat: l[i-1].Lat + (l[i].Lat-l[i-1].Lat)*f,
+				Lon: l[i-1].Lon + (l[i].Lon-l[i-1].Lon)*f,
+				Ele: l[i-1].Ele + (l[i].Ele-l[i-1].Ele)*f,
+			}
+		}
+		total += d
+	}
+	return l.End()
+}
+
+// Bounds is a lat/lon bounding box.
+type Bounds struct {
+	Min, Max Pos
+}
+
+// Bounds is the bounding box of the line.
+func (l Line) Bounds() Bounds {
+	b := Bounds{Min: l[0], Max: l[0]}
+	for _, pos := range l[1:] {
+		b = b.Extend(pos)
+	}
+	return b
+}
+
+// Extend returns the bounding box enlarged to include p.
+func (b Bounds) Extend(p Pos) Bounds {
+	b.Min.Lat = math.Min(b.Min.Lat, p.Lat)
+	b.Min.Lon = math.Min(b.Min.Lon, p.Lon)
+	b.Max.Lat = math.Max(b.Max.Lat, p.Lat)
+	b.Max.Lon = math.Max(b.Max.Lon, p.Lon)
+	return b
+}
+
+// Contains is true if p is inside the bounding box.
+func (b Bounds) Contains(p Pos) bool {
+	return p.Lat >= b.Min.Lat && p.Lat <= b.Max.Lat && p.Lon >= b.Min.Lon && p.Lon <= b.Max.Lon
+}
+
+// Centroid is the length weighted centre of the line. For a line with no length, it's the average position.
+func (l Line) Centroid() Pos {
+	var c Pos
+	var total float64
+	for i := 1; i < len(l); i++ {
+		d := l[i-1].Distance(l[i])
+		c.Lat += (l[i-1].Lat + l[i].Lat) / 2 * d
+		c.Lon += (l[i-1].Lon + l[i].Lon) / 2 * d
+		c.Ele += (l[i-1].Ele + l[i].Ele) / 2 * d
+		total += d
+	}
+	if total > 0 {
+		return Pos{Lat: c.Lat / total, Lon: c.Lon / total, Ele: c.Ele / total}
+	}
+	for _, pos := range l {
+		c.Lat += pos.Lat / float64(len(l))
+		c.Lon += pos.Lon / float64(len(l))
+		c.Ele += pos.Ele / float64(len(l))
+	}
+	return c
+}
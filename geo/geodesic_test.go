@@ -0,0 +1,217 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+// dms converts degrees, minutes and seconds to decimal degrees.
+func dms(d, m, s float64) float64 {
+	if d < 0 {
+		return d - m/60 - s/3600
+	}
+	return d + m/60 + s/3600
+}
+
+var (
+	paris   = Pos{Lat: 48.8566, Lon: 2.3522}
+	london  = Pos{Lat: 51.5074, Lon: -0.1278}
+	baghdad = Pos{Lat: 35, Lon: 45}
+	osaka   = Pos{Lat: 35, Lon: 135}
+	// Flinders Peak and Buninyong are the worked example in Vincenty's paper
+	flinders  = Pos{Lat: dms(-37, 57, 3.72030), Lon: dms(144, 25, 29.52440)}
+	buninyong = Pos{Lat: dms(-37, 39, 10.15610), Lon: dms(143, 55, 35.38390)}
+)
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name     string
+		p1, p2   Pos
+		expected float64 // km
+		within   float64 // km
+	}{
+		{"same", paris, paris, 0, 1e-9},
+		{"one degree of latitude", Pos{}, Pos{Lat: 1}, 111.19508, 1e-5},
+		{"quarter of the equator", Pos{}, Pos{Lon: 90}, 10007.557, 1e-3},
+		{"pole to pole", Pos{Lat: 90}, Pos{Lat: -90}, 20015.115, 1e-3},
+		{"across the antimeridian", Pos{Lon: 179.5}, Pos{Lon: -179.5}, 111.19508, 1e-5},
+		{"paris to london", paris, london, 343.557, 1e-3},
+		{"75 m", Pos{Lat: -45}, Pos{Lat: -45 + 0.075/111.19508}, 0.075, 1e-9},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if d := test.p1.Distance(test.p2); math.Abs(d-test.expected) > test.within {
+				t.Errorf("expected %v, found %v", test.expected, d)
+			}
+			if d := test.p2.Distance(test.p1); math.Abs(d-test.expected) > test.within {
+				t.Errorf("reversed: expected %v, found %v", test.expected, d)
+			}
+		})
+	}
+}
+
+func TestIsClose(t *testing.T) {
+	// IsClose must agree with Index, which measures with Distance
+	p := Pos{Lat: -45, Lon: -72}
+	for _, km := range []float64{0.0749999, 0.075, 0.0750001} {
+		q := p.Destination(37, km)
+		x := NewIndex(0.075)
+		x.AddPos(q, "q")
+		_, found := x.Nearest(p, 0.075, nil)
+		if close := p.IsClose(q, 0.075); close != found {
+			t.Errorf("%v km: IsClose is %v but Index found is %v", km, close, found)
+		}
+	}
+}
+
+func TestVincenty(t *testing.T) {
+	tests := []struct {
+		name     string
+		p1, p2   Pos
+		expected float64 // km
+	}{
+		{"same", flinders, flinders, 0},
+		{"flinders peak to buninyong", flinders, buninyong, 54.972271},
+		{"quarter of the equator", Pos{}, Pos{Lon: 90}, 10018.754171},
+		{"equator to pole", Pos{}, Pos{Lat: 90}, 10001.965729},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := test.p1.Vincenty(test.p2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(d-test.expected) > 1e-6 {
+				t.Errorf("expected %v, found %v", test.expected, d)
+			}
+		})
+	}
+	t.Run("nearly antipodal", func(t *testing.T) {
+		p1, p2 := Pos{}, Pos{Lat: 0.5, Lon: 179.7}
+		d, err := p1.Vincenty(p2)
+		if err != ErrNoConvergence {
+			t.Fatalf("expected ErrNoConvergence, found %v", err)
+		}
+		if d != p1.Distance(p2) {
+			t.Errorf("expected the haversine distance %v, found %v", p1.Distance(p2), d)
+		}
+	})
+}
+
+func TestBearing(t *testing.T) {
+	tests := []struct {
+		name           string
+		p1, p2         Pos
+		initial, final float64
+	}{
+		{"north", Pos{}, Pos{Lat: 1}, 0, 0},
+		{"east along the equator", Pos{}, Pos{Lon: 1}, 90, 90},
+		{"south", Pos{Lat: 1}, Pos{}, 180, 180},
+		{"west along the equator", Pos{Lon: 1}, Pos{}, 270, 270},
+		// 90 degrees of longitude apart: tan(bearing) = 1 / sin(latitude)
+		{"baghdad to osaka", baghdad, osaka, dms(60, 9, 44.76), dms(119, 50, 15.24)},
+		{"paris to london", paris, london, 330.0211, 328.1156},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if b := test.p1.Bearing(test.p2); angleDiff(b, test.initial) > 1e-3 {
+				t.Errorf("initial: expected %v, found %v", test.initial, b)
+			}
+			if b := test.p1.FinalBearing(test.p2); angleDiff(b, test.final) > 1e-3 {
+				t.Errorf("final: expected %v, found %v", test.final, b)
+			}
+		})
+	}
+}
+
+func TestDestination(t *testing.T) {
+	tests := []struct {
+		name     string
+		p        Pos
+		bearing  float64
+		km       float64
+		expected Pos
+	}{
+		{"north one degree", Pos{Ele: 100}, 0, 111.19508, Pos{Lat: 1, Ele: 100}},
+		{"east a quarter of the equator", Pos{}, 90, 10007.557, Pos{Lon: 90}},
+		{"across the antimeridian", Pos{Lon: 179.5}, 90, 111.19508, Pos{Lon: -179.5}},
+		{"baghdad to osaka", baghdad, dms(60, 9, 44.76), baghdad.Distance(osaka), osaka},
+		{"paris to london", paris, 330.0211, 343.557, london},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found := test.p.Destination(test.bearing, test.km)
+			if d := found.Distance(test.expected); d > 0.01 {
+				t.Errorf("expected %v, found %v (%v km away)", test.expected, found, d)
+			}
+			if found.Ele != test.expected.Ele {
+				t.Errorf("expected elevation %v, found %v", test.expected.Ele, found.Ele)
+			}
+		})
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	line := Line{{Ele: 0}, {Lon: 1, Ele: 100}, {Lat: 1, Lon: 1, Ele: 300}}
+	degree := 111.19508
+	tests := []struct {
+		name     string
+		km       float64
+		expected Pos
+	}{
+		{"before the start", -1, Pos{Ele: 0}},
+		{"start", 0, Pos{Ele: 0}},
+		{"half way along the first piece", degree / 2, Pos{Lon: 0.5, Ele: 50}},
+		{"vertex", degree, Pos{Lon: 1, Ele: 100}},
+		{"quarter of the way along the second piece", degree * 1.25, Pos{Lat: 0.25, Lon: 1, Ele: 150}},
+		{"end", degree * 2, Pos{Lat: 1, Lon: 1, Ele: 300}},
+		{"after the end", degree * 3, Pos{Lat: 1, Lon: 1, Ele: 300}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found := line.Interpolate(test.km)
+			if d := found.Distance(test.expected); d > 1e-3 {
+				t.Errorf("expected %v, found %v (%v km away)", test.expected, found, d)
+			}
+			if math.Abs(found.Ele-test.expected.Ele) > 0.1 {
+				t.Errorf("expected elevation %v, found %v", test.expected.Ele, found.Ele)
+			}
+		})
+	}
+}
+
+func TestSegmentDistance(t *testing.T) {
+	a, b := Pos{}, Pos{Lon: 1}
+	degree := 111.19508
+	tests := []struct {
+		name     string
+		p        Pos
+		expected float64
+	}{
+		{"on the segment", Pos{Lon: 0.5}, 0},
+		{"beside the middle", Pos{Lat: 0.01, Lon: 0.5}, degree / 100},
+		{"beside the start", Pos{Lat: -0.01}, degree / 100},
+		{"before the start", Pos{Lon: -0.01}, degree / 100},
+		{"after the end", Pos{Lon: 1.01}, degree / 100},
+		{"off the end diagonally", Pos{Lat: 0.01, Lon: 1.01}, Pos{Lat: 0.01, Lon: 1.01}.Distance(b)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if d := test.p.SegmentDistance(a, b); math.Abs(d-test.expected) > 1e-5 {
+				t.Errorf("expected %v, found %v", test.expected, d)
+			}
+			if d := test.p.SegmentDistance(b, a); math.Abs(d-test.expected) > 1e-5 {
+				t.Errorf("reversed: expected %v, found %v", test.expected, d)
+			}
+		})
+	}
+}
+
+// angleDiff is the smallest difference in degrees between two bearings.
+func angleDiff(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
@@ -0,0 +1,146 @@
+package geo
+
+import (
+	"math"
+)
+
+// Index is a grid based spatial index of positions and lines. Each line is split into its individual pieces (the
+// straight line between two adjacent positions) and each piece is added to every cell its bounding box touches.
+type Index struct {
+	size  float64 // cell size in degrees
+	cells map[cell][]*entry
+}
+
+type cell struct {
+	lat, lon int
+}
+
+type entry struct {
+	line  Line // for positions, this is a line with a single position
+	index int  // index in line of the start of the piece
+	value interface{}
+}
+
+// Hit is an item found in the index.
+type Hit struct {
+	Projection
+	Line  Line
+	Value interface{}
+}
+
+// NewIndex creates an index with cells of roughly km size. Queries are most efficient when the search distance is of
+// a similar magnitude to the cell size.
+func NewIndex(km float64) *Index {
+	return &Index{
+		size:  km / kmPerDegree,
+		cells: map[cell][]*entry{},
+	}
+}
+
+// AddPos adds a single position to the index.
+func (x *Index) AddPos(p Pos, value interface{}) {
+	e := &entry{line: Line{p}, value: value}
+	x.cells[x.cell(p.Lat, p.Lon)] = append(x.cells[x.cell(p.Lat, p.Lon)], e)
+}
+
+// AddLine adds all the pieces of a line to the index.
+func (x *Index) AddLine(l Line, value interface{}) {
+	if len(l) == 1 {
+		x.AddPos(l[0], value)
+		return
+	}
+	for i := 0; i < len(l)-1; i++ {
+		e := &entry{line: l, index: i, value: value}
+		from := x.cell(math.Min(l[i].Lat, l[i+1].Lat), math.Min(l[i].Lon, l[i+1].Lon))
+		to := x.cell(math.Max(l[i].Lat, l[i+1].Lat), math.Max(l[i].Lon, l[i+1].Lon))
+		for lat := from.lat; lat <= to.lat; lat++ {
+			for lon := from.lon; lon <= to.lon; lon++ {
+				c := cell{lat, lon}
+				x.cells[c] = append(x.cells[c], e)
+			}
+		}
+	}
+}
+
+// Within returns all items that are closer than km to p. Lines are only returned once, at the nearest point.
+func (x *Index) Within(p Pos, km float64, filter func(interface{}) bool) []Hit {
+	nearest := map[interface{}]int{}
+	var hits []Hit
+	x.search(p, km, filter, func(e *entry, proj Projection) {
+		key := lineKey(e)
+		if i, found := nearest[key]; found {
+			if proj.Distance < hits[i].Distance {
+				hits[i] = Hit{Projection: proj, Line: e.line, Value: e.value}
+			}
+			return
+		}
+		nearest[key] = len(hits)
+		hits = append(hits, Hit{Projection: proj, Line: e.line, Value: e.value})
+	})
+	return hits
+}
+
+// Nearest returns the nearest item that is closer than km to p.
+func (x *Index) Nearest(p Pos, km float64, filter func(interface{}) bool) (Hit, bool) {
+	var best Hit
+	var found bool
+	x.search(p, km, filter, func(e *entry, proj Projection) {
+		if !found || proj.Distance < best.Distance {
+			best = Hit{Projection: proj, Line: e.line, Value: e.value}
+			found = true
+		}
+	})
+	return best, found
+}
+
+func (x *Index) search(p Pos, km float64, filter func(interface{}) bool, f func(*entry, Projection)) {
+	// allow a small margin for the approximate cell size
+	dlat := km * 1.01 / kmPerDegree
+	dlon := dlat
+	if cos := math.Cos(p.Lat * math.Pi / 180); cos > 0.01 {
+		dlon = dlat / cos
+	} else {
+		dlon = 180
+	}
+	from := x.cell(p.Lat-dlat, p.Lon-dlon)
+	to := x.cell(p.Lat+dlat, p.Lon+dlon)
+	done := map[*entry]bool{}
+	for lat := from.lat; lat <= to.lat; lat++ {
+		for lon := from.lon; lon <= to.lon; lon++ {
+			for _, e := range x.cells[cell{lat, lon}] {
+				if done[e] {
+					continue
+				}
+				done[e] = true
+				if filter != nil && !filter(e.value) {
+					continue
+				}
+				var proj Projection
+				if len(e.line) == 1 {
+					proj = Projection{Pos: e.line[0], Distance: e.line[0].Distance(p)}
+				} else {
+					proj = e.line.projectPiece(e.index, p)
+				}
+				if proj.Distance >= km {
+					continue
+				}
+				f(e, proj)
+			}
+		}
+	}
+}
+
+func (x *Index) cell(lat, lon float64) cell {
+	return cell{int(math.Floor(lat / x.size)), int(math.Floor(lon / x.size))}
+}
+
+// lineKey identifies the line an entry belongs to, so several pieces of the same line can be grouped.
+func lineKey(e *entry) interface{} {
+	if len(e.line) == 1 {
+		return e
+	}
+	return struct {
+		first *Pos
+		value interface{}
+	}{&e.line[0], e.value}
+}
@@ -0,0 +1,281 @@
+package geo
+
+import (
+	"container/heap"
+	"math"
+)
+
+// Simplification settings for reducing the number of points in a line. The zero value leaves lines unchanged.
+type Simplification struct {
+	Tolerance float64 // Douglas-Peucker tolerance in km
+	MaxPoints int     // maximum number of points, enforced with Visvalingam-Whyatt after the tolerance is applied
+}
+
+// Apply simplifies a line. The original line is never modified. Retained points keep their elevation.
+func (s Simplification) Apply(l Line) Line {
+	if s.Tolerance > 0 {
+		l = l.Simplify(s.Tolerance)
+	}
+	if s.MaxPoints > 0 && len(l) > s.MaxPoints {
+		l = l.Reduce(s.MaxPoints)
+	}
+	return l
+}
+
+// ApplyAll simplifies several lines that will be output together (e.g. the segments of a single track), so
+// MaxPoints is the limit for all the lines combined. Each line keeps its start and end, and the rest of the points are
+// shared between the lines in proportion to the number remaining after the tolerance is applied. If there are too
+// many lines for each to keep its start and end, the shortest lines are first merged into neighbours they touch, so
+// fewer lines may be returned. Lines that don't touch are never joined: if there are still too many, they're split
+// into groups that are each within MaxPoints, to be output as separate tracks. There's always at least one group.
+func (s Simplification) ApplyAll(lines []Line) [][]Line {
+	out := make([]Line, len(lines))
+	var total int
+	for i, l := range lines {
+		if s.Tolerance > 0 {
+			l = l.Simplify(s.Tolerance)
+		}
+		out[i] = l
+		total += len(l)
+	}
+	if s.MaxPoints == 0 || total <= s.MaxPoints {
+		return [][]Line{out}
+	}
+	max := s.MaxPoints
+	if max < 2 {
+		max = 2
+	}
+	for len(out) > 1 && 2*len(out) > max {
+		merged, ok := mergeShortest(out)
+		if !ok {
+			break
+		}
+		out = merged
+	}
+	var groups [][]Line
+	for len(out) > 0 {
+		n := len(out)
+		if n > max/2 {
+			n = max / 2
+		}
+		groups = append(groups, share(out[:n], max))
+		out = out[n:]
+	}
+	return groups
+}
+
+// share reduces lines to at most max points combined. Each line has 2 points for the start and end, and the spare
+// points are shared in proportion to the rest. There must be no more than max/2 lines.
+func share(lines []Line, max int) []Line {
+	out := append([]Line{}, lines...)
+	extra := func(l Line) int {
+		if len(l) < 2 {
+			return 0
+		}
+		return len(l) - 2
+	}
+	spare, rest := max-2*len(out), 0
+	for _, l := range out {
+		rest += extra(l)
+	}
+	if rest <= spare {
+		return out
+	}
+	allowed := make([]int, len(out))
+	remainders := make([]int, len(out))
+	used := 0
+	for i, l := range out {
+		share := spare * extra(l)
+		allowed[i] = 2 + share/rest
+		remainders[i] = share % rest
+		used += allowed[i] - 2
+	}
+	// the points left over after rounding down go to the lines with the largest remainders
+	for ; used < spare; used++ {
+		best := -1
+		for i := range out {
+			if allowed[i] < len(out[i]) && (best == -1 || remainders[i] > remainders[best]) {
+				best = i
+			}
+		}
+		if best == -1 {
+			break
+		}
+		allowed[best]++
+		remainders[best] = -1
+	}
+	for i, l := range out {
+		if len(l) > allowed[i] {
+			out[i] = l.Reduce(allowed[i])
+		}
+	}
+	return out
+}
+
+// mergeShortest joins the pair of neighbouring lines with the fewest points combined, where the first ends at the
+// start of the second. It's false if no neighbours touch.
+func mergeShortest(lines []Line) ([]Line, bool) {
+	first := -1
+	for i := 0; i < len(lines)-1; i++ {
+		a, b := lines[i], lines[i+1]
+		if len(a) == 0 || len(b) == 0 || a.End() != b.Start() {
+			continue
+		}
+		if first == -1 || len(a)+len(b) < len(lines[first])+len(lines[first+1]) {
+			first = i
+		}
+	}
+	if first == -1 {
+		return lines, false
+	}
+	merged := append(append(Line{}, lines[first]...), lines[first+1][1:]...)
+	out := append([]Line{}, lines[:first]...)
+	out = append(out, merged)
+	return append(out, lines[first+2:]...), true
+}
+
+// Simplify removes points with the Douglas-Peucker algorithm. No point in the original line is further than
+// tolerance km from the simplified line.
+func (l Line) Simplify(tolerance float64) Line {
+	if len(l) < 3 {
+		return append(Line{}, l...)
+	}
+	keep := make([]bool, len(l))
+	keep[0], keep[len(l)-1] = true, true
+	stack := [][2]int{{0, len(l) - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+		var index int
+		var furthest float64
+		for i := first + 1; i < last; i++ {
+			if d := l[i].SegmentDistance(l[first], l[last]); d > furthest {
+				index, furthest = i, d
+			}
+		}
+		if furthest > tolerance {
+			keep[index] = true
+			stack = append(stack, [2]int{first, index}, [2]int{index, last})
+		}
+	}
+	var out Line
+	for i, pos := range l {
+		if keep[i] {
+			out = append(out, pos)
+		}
+	}
+	return out
+}
+
+// Reduce removes points with the Visvalingam-Whyatt algorithm until the line has at most max points. The point that
+// forms the smallest triangle with its neighbours is removed first. The start and end are always kept.
+func (l Line) Reduce(max int) Line {
+	if max < 2 {
+		max = 2
+	}
+	if len(l) <= max {
+		return append(Line{}, l...)
+	}
+	prev := make([]int, len(l))
+	next := make([]int, len(l))
+	items := make([]*areaItem, len(l))
+	h := &areaHeap{}
+	for i := range l {
+		prev[i], next[i] = i-1, i+1
+		if i == 0 || i == len(l)-1 {
+			continue
+		}
+		items[i] = &areaItem{index: i, area: triangleArea(l[i-1], l[i], l[i+1])}
+		heap.Push(h, items[i])
+	}
+	remaining := len(l)
+	for remaining > max {
+		item := heap.Pop(h).(*areaItem)
+		p, n := prev[item.index], next[item.index]
+		next[p], prev[n] = n, p
+		remaining--
+		// neighbours are updated, but never get a smaller area than the point just removed
+		for _, i := range []int{p, n} {
+			if items[i] == nil {
+				continue
+			}
+			items[i].area = math.Max(item.area, triangleArea(l[prev[i]], l[i], l[next[i]]))
+			heap.Fix(h, items[i].heapIndex)
+		}
+	}
+	out := make(Line, 0, max)
+	for i := 0; i < len(l); i = next[i] {
+		out = append(out, l[i])
+	}
+	return out
+}
+
+// Resample creates a line with points every interval km along the original line. The end of the line is always
+// included. Elevations are interpolated.
+func (l Line) Resample(interval float64) Line {
+	if len(l) < 2 || interval <= 0 {
+		return append(Line{}, l...)
+	}
+	out := Line{l[0]}
+	var carried float64 // distance travelled since the last output point
+	for i := 1; i < len(l); i++ {
+		a, b := l[i-1], l[i]
+		d := a.Distance(b)
+		var done float64 // distance along this piece already output
+		for carried+d-done >= interval {
+			done += interval - carried
+			carried = 0
+			f := done / d
+			out = append(out, Pos{
+				Lat: a.Lat + (b.Lat-a.Lat)*f,
+				Lon: a.Lon + (b.Lon-a.Lon)*f,
+				Ele: a.Ele + (b.Ele-a.Ele)*f,
+			})
+		}
+		carried += d - done
+	}
+	if last := out[len(out)-1]; last.Lat != l.End().Lat || last.Lon != l.End().Lon {
+		out = append(out, l.End())
+	}
+	return out
+}
+
+// triangleArea is the area in square km of the triangle formed by three positions, using a local flat approximation.
+func triangleArea(a, b, c Pos) float64 {
+	cos := math.Cos(radians(b.Lat))
+	ax, ay := (a.Lon-b.Lon)*cos*kmPerDegree, (a.Lat-b.Lat)*kmPerDegree
+	cx, cy := (c.Lon-b.Lon)*cos*kmPerDegree, (c.Lat-b.Lat)*kmPerDegree
+	return math.Abs(ax*cy-ay*cx) / 2
+}
+
+type areaItem struct {
+	index     int // index in the line
+	area      float64
+	heapIndex int
+}
+
+type areaHeap []*areaItem
+
+func (h areaHeap) Len() int { return len(h) }
+func (h areaHeap) Less(i, j int) bool {
+	if h[i].area == h[j].area {
+		return h[i].index < h[j].index
+	}
+	return h[i].area < h[j].area
+}
+func (h areaHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+func (h *areaHeap) Push(x interface{}) {
+	item := x.(*areaItem)
+	item.heapIndex = len(*h)
+	*h = append(*h, item)
+}
+func (h *areaHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,232 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+// wiggly is a line heading east from start with n points about 10 m apart, wiggling north and south by up to 20 m.
+// The elevation of each point is its index.
+func wiggly(start Pos, n int) Line {
+	l := make(Line, n)
+	for i := range l {
+		p := start.Destination(90, float64(i)*0.01)
+		p = p.Destination(0, 0.02*math.Sin(float64(i)/3))
+		p.Ele = float64(i)
+		l[i] = p
+	}
+	return l
+}
+
+// checkSubset checks that the simplified line keeps the start and end, and only contains points from the original
+// line in the original order.
+func checkSubset(t *testing.T, original, simplified Line) {
+	t.Helper()
+	if len(simplified) < 2 || simplified.Start() != original.Start() || simplified.End() != original.End() {
+		t.Fatalf("start and end not kept")
+	}
+	j := 0
+	for _, p := range simplified {
+		for j < len(original) && original[j] != p {
+			j++
+		}
+		if j == len(original) {
+			t.Fatalf("%v isn't in the original line, or is out of order", p)
+		}
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      Line
+		tolerance float64
+	}{
+		{"straight", Line{{}, {Lon: 0.001, Ele: 1}, {Lon: 0.002, Ele: 2}, {Lon: 0.003, Ele: 3}}, 0.001},
+		{"wiggly 1 m", wiggly(Pos{Lat: -45, Lon: -72}, 200), 0.001},
+		{"wiggly 10 m", wiggly(Pos{Lat: -45, Lon: -72}, 200), 0.01},
+		{"wiggly 50 m", wiggly(Pos{Lat: -45, Lon: -72}, 200), 0.05},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			simplified := test.line.Simplify(test.tolerance)
+			checkSubset(t, test.line, simplified)
+			for _, p := range test.line {
+				if d := simplified.Project(p).Distance; d > test.tolerance+1e-9 {
+					t.Errorf("%v is %v km from the simplified line", p, d)
+				}
+			}
+			if len(simplified) >= len(test.line) && len(test.line) > 2 && test.tolerance >= 0.01 {
+				t.Errorf("no points removed")
+			}
+		})
+	}
+	t.Run("straight line is reduced to the ends", func(t *testing.T) {
+		if l := tests[0].line.Simplify(0.001); len(l) != 2 {
+			t.Errorf("expected 2 points, found %d", len(l))
+		}
+	})
+}
+
+func TestReduce(t *testing.T) {
+	line := wiggly(Pos{Lat: -45, Lon: -72}, 200)
+	tests := []struct {
+		name     string
+		max      int
+		expected int
+	}{
+		{"more than the line", 500, 200},
+		{"same as the line", 200, 200},
+		{"half", 100, 100},
+		{"few", 7, 7},
+		{"ends only", 2, 2},
+		{"less than two", 1, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reduced := line.Reduce(test.max)
+			if len(reduced) != test.expected {
+				t.Errorf("expected %d points, found %d", test.expected, len(reduced))
+			}
+			checkSubset(t, line, reduced)
+		})
+	}
+}
+
+func TestResample(t *testing.T) {
+	line := Line{{Ele: 0}, {Lon: 0.01, Ele: 100}, {Lon: 0.02, Ele: 0}} // about 2.22 km
+	tests := []struct {
+		name     string
+		interval float64
+		expected int
+	}{
+		{"100 m", 0.1, 24},
+		{"500 m", 0.5, 6},
+		{"longer than the line", 5, 2},
+		{"zero", 0, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resampled := line.Resample(test.interval)
+			if len(resampled) != test.expected {
+				t.Fatalf("expected %d points, found %d", test.expected, len(resampled))
+			}
+			if resampled.Start() != line.Start() || resampled.End() != line.End() {
+				t.Errorf("start and end not kept")
+			}
+			if test.interval == 0 {
+				return
+			}
+			for i := 1; i < len(resampled)-1; i++ {
+				if d := resampled[i-1].Distance(resampled[i]); math.Abs(d-test.interval) > 1e-6 {
+					t.Errorf("point %d is %v km from the previous point", i, d)
+				}
+				// elevation is interpolated along the line
+				along := float64(i) * test.interval
+				expected := line.Interpolate(along).Ele
+				if math.Abs(resampled[i].Ele-expected) > 0.01 {
+					t.Errorf("point %d: expected elevation %v, found %v", i, expected, resampled[i].Ele)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyAll(t *testing.T) {
+	start := Pos{Lat: -45, Lon: -72}
+	// lines makes n joined lines of points each
+	lines := func(n, points int) []Line {
+		var out []Line
+		all := wiggly(start, n*(points-1)+1)
+		for i := 0; i < n; i++ {
+			out = append(out, all[i*(points-1):(i+1)*(points-1)+1])
+		}
+		return out
+	}
+	// apart makes n lines of points each, with a gap between each line and the next
+	apart := func(n, points int) []Line {
+		var out []Line
+		for _, l := range lines(n, points+1) {
+			out = append(out, l[:points])
+		}
+		return out
+	}
+	tests := []struct {
+		name     string
+		lines    []Line
+		simplify Simplification
+		groups   int
+	}{
+		{"unchanged", lines(3, 50), Simplification{}, 1},
+		{"under the limit", lines(3, 50), Simplification{MaxPoints: 500}, 1},
+		{"one line", lines(1, 1000), Simplification{MaxPoints: 500}, 1},
+		{"few lines", lines(5, 300), Simplification{MaxPoints: 500}, 1},
+		{"uneven lines", append(lines(1, 900), lines(20, 10)...), Simplification{MaxPoints: 100}, 1},
+		{"many lines", lines(400, 10), Simplification{MaxPoints: 500}, 1},
+		{"more lines than points", lines(1000, 3), Simplification{MaxPoints: 500}, 1},
+		{"tolerance", lines(5, 300), Simplification{Tolerance: 0.01}, 1},
+		{"tolerance and limit", lines(300, 20), Simplification{Tolerance: 0.005, MaxPoints: 500}, 1},
+		{"lines apart", apart(20, 50), Simplification{MaxPoints: 500}, 1},
+		{"too many lines apart", apart(600, 3), Simplification{MaxPoints: 500}, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			groups := test.simplify.ApplyAll(test.lines)
+			if len(groups) != test.groups {
+				t.Fatalf("expected %d groups, found %d", test.groups, len(groups))
+			}
+			var out []Line
+			for _, group := range groups {
+				var points int
+				for _, l := range group {
+					points += len(l)
+				}
+				if test.simplify.MaxPoints > 0 && points > test.simplify.MaxPoints {
+					t.Errorf("expected at most %d points in a group, found %d", test.simplify.MaxPoints, points)
+				}
+				out = append(out, group...)
+			}
+			var before, after int
+			for _, l := range test.lines {
+				before += len(l)
+			}
+			for _, l := range out {
+				after += len(l)
+			}
+			if test.simplify == (Simplification{}) && after != before {
+				t.Errorf("expected %d points unchanged, found %d", before, after)
+			}
+			if test.simplify.MaxPoints > 0 && before > test.simplify.MaxPoints && after < test.simplify.MaxPoints*9/10 && test.simplify.Tolerance == 0 && test.groups == 1 {
+				t.Errorf("expected close to %d points, found %d", test.simplify.MaxPoints, after)
+			}
+			if len(out) == len(test.lines) {
+				for i := range out {
+					checkSubset(t, test.lines[i], out[i])
+				}
+			}
+			// lines that don't touch are never joined
+			touching := false
+			for i := 1; i < len(test.lines); i++ {
+				if test.lines[i-1].End() == test.lines[i].Start() {
+					touching = true
+				}
+			}
+			if !touching && len(out) != len(test.lines) {
+				t.Errorf("expected %d separate lines, found %d", len(test.lines), len(out))
+			}
+			// the whole route still starts and ends in the same place
+			if out[0].Start() != test.lines[0].Start() || out[len(out)-1].End() != test.lines[len(test.lines)-1].End() {
+				t.Errorf("start and end not kept")
+			}
+			if test.simplify.Tolerance > 0 && test.simplify.MaxPoints == 0 {
+				for i := range test.lines {
+					for _, p := range test.lines[i] {
+						if d := out[i].Project(p).Distance; d > test.simplify.Tolerance+1e-9 {
+							t.Errorf("%v is %v km from the simplified line", p, d)
+						}
+					}
+				}
+			}
+		})
+	}
+}
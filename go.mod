@@ -4,9 +4,10 @@ go 1.14
 
 require (
 	github.com/PuerkitoBio/goquery v1.5.1
-	github.com/fogleman/gg v1.3.0 // indirect
+	github.com/fogleman/gg v1.3.0
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
 	github.com/tkrajina/go-elevations v0.1.0
 	golang.org/x/image v0.23.0 // indirect
 	golang.org/x/net v0.25.0
+	gopkg.in/yaml.v2 v2.4.0
 )
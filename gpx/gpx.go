@@ -34,9 +34,10 @@ func Decode(reader io.Reader) (Root, error) {
 // Paged is a helper for paginated GPX files. When saving, it will split the output over several files. Buckets of
 // items are kept together. Buckets are ordered.
 type Paged struct {
-	Version float64
-	Max     int
-	Buckets []*Bucket
+	Version   float64
+	Max       int // maximum number of items in each file
+	MaxPoints int // maximum number of points in each file (0: no limit)
+	Buckets   []*Bucket
 }
 
 func (p *Paged) Save(fpath string) error {
@@ -45,16 +46,20 @@ func (p *Paged) Save(fpath string) error {
 	var roots []*Root
 	current := &Root{Version: p.Version}
 	currentItemCount := 0
+	currentPointCount := 0
 	for _, bucket := range p.Buckets {
-		if currentItemCount > 0 && currentItemCount+bucket.Items() > p.Max {
+		full := currentItemCount+bucket.Items() > p.Max || (p.MaxPoints > 0 && currentPointCount+bucket.Points() > p.MaxPoints)
+		if currentItemCount > 0 && full {
 			roots = append(roots, current)
 			current = &Root{Version: p.Version}
 			currentItemCount = 0
+			currentPointCount = 0
 		}
 		current.Waypoints = append(current.Waypoints, bucket.Waypoints...)
 		current.Routes = append(current.Routes, bucket.Routes...)
 		current.Tracks = append(current.Tracks, bucket.Tracks...)
 		currentItemCount += bucket.Items()
+		currentPointCount += bucket.Points()
 	}
 	roots = append(roots, current)
 	for i, root := range roots {
@@ -86,8 +91,23 @@ func (b *Bucket) Items() int {
 	return total
 }
 
+// Points is the total number of waypoints, route points and track points in the bucket.
+func (b *Bucket) Points() int {
+	total := len(b.Waypoints)
+	for _, route := range b.Routes {
+		total += len(route.Points)
+	}
+	for _, track := range b.Tracks {
+		for _, segment := range track.Segments {
+			total += len(segment.Points)
+		}
+	}
+	return total
+}
+
 type Root struct {
 	Version   float64    `xml:"version,attr"`
+	Attrs     []xml.Attr `xml:",any,attr"` // e.g. namespace declarations for extensions
 	Waypoints []Waypoint `xml:"wpt"`
 	Tracks    []Track    `xml:"trk"`
 	Routes    []Route    `xml:"rte"`
@@ -113,9 +133,28 @@ func (r Root) Save(fpath string) error {
 
 type Waypoint struct {
 	Point
-	Name string `xml:"name"`
-	Sym  string `xml:"sym,omitempty"`
-	Desc string `xml:"desc,omitempty"`
+	Name       string      `xml:"name"`
+	Sym        string      `xml:"sym,omitempty"`
+	Desc       string      `xml:"desc,omitempty"`
+	Extensions *Extensions `xml:"extensions,omitempty"`
+}
+
+// Extensions holds app specific elements e.g. OsmAnd or Locus styles.
+type Extensions struct {
+	Elements []Element `xml:",any"`
+}
+
+// Element is a generic xml element. Use a prefixed local name (e.g. "osmand:color") for elements in a namespace that
+// is declared in the root attributes.
+type Element struct {
+	XMLName  xml.Name
+	Value    string    `xml:",chardata"`
+	Elements []Element `xml:",any"`
+}
+
+// NewElement creates an element with a value.
+func NewElement(name, value string) Element {
+	return Element{XMLName: xml.Name{Local: name}, Value: value}
 }
 
 type Route struct {
@@ -152,9 +191,10 @@ type TrackPoint struct {
 }
 
 type Track struct {
-	Name     string         `xml:"name"`
-	Desc     string         `xml:"desc"`
-	Segments []TrackSegment `xml:"trkseg"`
+	Name       string         `xml:"name"`
+	Desc       string         `xml:"desc"`
+	Extensions *Extensions    `xml:"extensions,omitempty"`
+	Segments   []TrackSegment `xml:"trkseg"`
 }
 
 type TrackSegment struct {
@@ -4,12 +4,15 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"time"
 
+	"github.com/dave/gpt/geo"
 	"github.com/dave/gpt/globals"
 	"github.com/dave/gpt/kml"
 	"github.com/dave/gpt/routedata"
@@ -27,7 +30,8 @@ func Main() error {
 
 	cacheDir := path.Join(os.Getenv("HOME"), fmt.Sprintf(".gpt-cache-%04d-%02d", time.Now().Year(), time.Now().Month()))
 	elevationCacheDir := path.Join(cacheDir, "elevations")
-	descriptionsCacheDir := path.Join(cacheDir, "descriptions")
+	// descriptions are revalidated when they expire, so they don't need a new cache each month
+	descriptionsCacheDir := path.Join(os.Getenv("HOME"), ".gpt-cache", "descriptions")
 	_ = os.MkdirAll(elevationCacheDir, 0777)
 	_ = os.MkdirAll(descriptionsCacheDir, 0777)
 
@@ -38,12 +42,38 @@ func Main() error {
 	single := flag.String("single", "", "only process a single section (for testing)")
 	ele := flag.Bool("ele", true, "lookup elevations")
 	scrape := flag.Bool("scrape", true, "scrape descriptions from wikiexplora")
+	source := flag.String("source", "api", "get descriptions from the wikiexplora api (falling back to the web page) or html")
+	refresh := flag.Bool("refresh", false, "revalidate all cached descriptions with wikiexplora")
 	output := flag.String("output", "./output", "output dir")
 	renames := flag.Bool("renames", false, "create rename log file and RESET legacy names in master file")
 	stamp := flag.String("stamp", fmt.Sprintf("%04d%02d%02d", time.Now().Year(), time.Now().Month(), time.Now().Day()), "date stamp for output files")
 	version := flag.Bool("version", false, "show version")
+	gaiaTolerance := flag.Float64("gaia-tolerance", 0, "simplify gaia tracks so no point is further than this many metres from the output (0: full fidelity)")
+	gaiaMaxPoints := flag.Int("gaia-max-points", 0, "maximum number of points in each gaia track (0: no limit)")
+	gpxTolerance := flag.Float64("gpx-tolerance", 0, "simplify generic gpx tracks so no point is further than this many metres from the output (0: full fidelity)")
+	gpxMaxPoints := flag.Int("gpx-max-points", 0, "maximum number of points in each generic gpx track (0: no limit)")
+	fitDistance := flag.Float64("fit-distance", 200, "section waypoints closer than this many metres to the route are added to fit courses")
+	waypointDistance := flag.Float64("waypoint-distance", 500, "section waypoints further than this many metres from any route in their section are reported as probable errors")
+	resupplyDays := flag.Float64("resupply-days", 7, "stretches between resupply locations longer than this many days are flagged")
+	radius := flag.Float64("radius", 20, "search radius in km for the locate command")
+	tolerance := flag.Float64("tolerance", 50, "recorded points further than this many metres from any route are off-track (compare command)")
+	newPath := flag.Float64("new-path", 500, "off-track stretches longer than this many metres are reported as new paths (compare command)")
+	base := flag.String("base", "", "the master file that the patch was edited from, used to detect conflicts (merge command)")
+	previous := flag.String("previous", "", "master file of the previous release, used for the changes in the release notes")
+	recordings := flag.Int("recordings", 3, "number of recordings of a segment needed to suggest changes (verify command)")
+	mode := flag.String("mode", "hiking", "hiking or packrafting (route command)")
+	cost := flag.String("cost", "distance", "minimise distance or time (route command)")
+	avoid := flag.String("avoid", "", "comma separated terrain codes to avoid e.g. FY,BB (route command)")
+	avoidInvestigation := flag.Bool("avoid-investigation", false, "avoid investigation (I) segments (route command)")
 	flag.Parse()
 
+	command := flag.Arg(0)
+	switch command {
+	case "", "locate", "compare", "verify", "merge", "diff", "itinerary", "route":
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+
 	globals.LOG = *logger
 	globals.DEBUG = *debugger
 
@@ -72,19 +102,31 @@ func Main() error {
 		}
 	}
 
+	if command == "diff" {
+		return diff(flag.Args()[1:], *ele, *output)
+	}
+
 	inputRoot, err := kml.Load(*input)
 	if err != nil {
 		return fmt.Errorf("loading tracks kmz: %w", err)
 	}
 
+	var merged *routedata.MergeResult
+	if command == "merge" {
+		merged, err = merge(&inputRoot, flag.Args()[1:], *base)
+		if err != nil {
+			return err
+		}
+	}
+
 	data := &routedata.Data{Sections: map[globals.SectionKey]*routedata.Section{}}
 
 	if err := data.Scan(inputRoot, *ele); err != nil {
 		return fmt.Errorf("scanning kml: %w", err)
 	}
 
-	if *scrape {
-		if err := data.Scrape(descriptionsCacheDir); err != nil {
+	if *scrape && command == "" {
+		if err := data.Scrape(routedata.NewFetcher(descriptionsCacheDir, *refresh), *source); err != nil {
 			return fmt.Errorf("scraping web: %w", err)
 		}
 	}
@@ -93,31 +135,28 @@ func Main() error {
 		return fmt.Errorf("normalising: %w", err)
 	}
 
-	// temporary code - find main optional route and assign name to all other variants in that option
-	for _, section := range data.Sections {
-		optionNames := map[int]string{}
-		for _, route := range section.Routes {
-			if route.Option != "" {
-				continue
-			}
-			if route.Key.Required == globals.REGULAR {
-				continue
-			}
-			if route.Key.Option == 0 {
-				continue
-			}
-			name, found := optionNames[route.Key.Option]
-			if !found {
-				for _, r := range section.Routes {
-					if r.Key.Option == route.Key.Option && r.Key.Variant == "" {
-						optionNames[route.Key.Option] = r.Name
-						name = r.Name
-						break
-					}
-				}
-			}
-			route.Option = name
+	nameOptions(data)
+	data.AttachOptionDescriptions()
+
+	switch command {
+	case "locate":
+		return locate(data, flag.Args()[1:], *radius)
+	case "compare":
+		return compare(data, flag.Args()[1:], filepath.Join(*output, "Comparisons"), *tolerance/1000, *newPath/1000)
+	case "verify":
+		return verify(data, flag.Args()[1:], filepath.Join(*output, "Verification"), *tolerance/1000, *recordings)
+	case "itinerary":
+		return itinerary(data, flag.Args()[1:], filepath.Join(*output, "Itineraries"), geo.Simplification{Tolerance: *gpxTolerance / 1000, MaxPoints: *gpxMaxPoints})
+	case "route":
+		return route(data, flag.Args()[1:], filepath.Join(*output, "Routing"), *mode, *cost, *avoid, *avoidInvestigation, *radius)
+	case "merge":
+		if err := data.SaveMaster(*output, *renames); err != nil {
+			return fmt.Errorf("saving master file: %w", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(*output, "Merge conflicts.txt"), []byte(merged.Report()), 0666); err != nil {
+			return fmt.Errorf("writing merge conflicts file: %w", err)
 		}
+		return nil
 	}
 
 	//if *tiles {
@@ -129,14 +168,65 @@ func Main() error {
 		return fmt.Errorf("saving master file: %w", err)
 	}
 
-	if err := data.SaveGaia(*output); err != nil {
+	data.CheckConnections()
+
+	data.SnapWaypoints(*waypointDistance / 1000)
+
+	if err := data.SaveGaia(*output, geo.Simplification{Tolerance: *gaiaTolerance / 1000, MaxPoints: *gaiaMaxPoints}); err != nil {
 		return fmt.Errorf("saving gaia files: %w", err)
 	}
 
-	if err := data.SaveGpx(*output, *stamp); err != nil {
+	if err := data.SaveGpx(*output, *stamp, geo.Simplification{Tolerance: *gpxTolerance / 1000, MaxPoints: *gpxMaxPoints}); err != nil {
 		return fmt.Errorf("saving generic gps files: %w", err)
 	}
 
+	var previousData *routedata.Data
+	if *previous != "" {
+		previousData, err = load(*previous, false)
+		if err != nil {
+			return fmt.Errorf("loading previous release: %w", err)
+		}
+	}
+	if err := data.SaveReleaseNotes(*output, *stamp, previousData); err != nil {
+		return fmt.Errorf("saving release notes: %w", err)
+	}
+
+	if err := data.SaveGarmin(*output); err != nil {
+		return fmt.Errorf("saving garmin files: %w", err)
+	}
+
+	if err := data.SaveFitCourses(*output, *stamp, *fitDistance/1000); err != nil {
+		return fmt.Errorf("saving fit courses: %w", err)
+	}
+
+	if err := data.SaveOsmand(*output); err != nil {
+		return fmt.Errorf("saving osmand files: %w", err)
+	}
+
+	if err := data.SaveLocus(*output); err != nil {
+		return fmt.Errorf("saving locus files: %w", err)
+	}
+
+	if err := data.SaveSummary(*output); err != nil {
+		return fmt.Errorf("saving summary: %w", err)
+	}
+
+	if err := data.SaveOptionTable(*output); err != nil {
+		return fmt.Errorf("saving option table: %w", err)
+	}
+
+	if err := data.SaveWaypointTable(*output, *waypointDistance/1000); err != nil {
+		return fmt.Errorf("saving waypoint table: %w", err)
+	}
+
+	if err := data.SaveResupplyPlans(*output, *resupplyDays); err != nil {
+		return fmt.Errorf("saving resupply plans: %w", err)
+	}
+
+	if err := data.SaveThruHikes(*output, *stamp, geo.Simplification{Tolerance: *gpxTolerance / 1000, MaxPoints: *gpxMaxPoints}); err != nil {
+		return fmt.Errorf("saving thru-hikes: %w", err)
+	}
+
 	if err := data.SaveKmlTracks(*output, *stamp); err != nil {
 		return fmt.Errorf("saving generic gps files: %w", err)
 	}
@@ -147,3 +237,32 @@ func Main() error {
 
 	return nil
 }
+
+// nameOptions is temporary code - find main optional route and assign name to all other variants in that option
+func nameOptions(data *routedata.Data) {
+	for _, section := range data.Sections {
+		optionNames := map[int]string{}
+		for _, route := range section.Routes {
+			if route.Option != "" {
+				continue
+			}
+			if route.Key.Required == globals.REGULAR {
+				continue
+			}
+			if route.Key.Option == 0 {
+				continue
+			}
+			name, found := optionNames[route.Key.Option]
+			if !found {
+				for _, r := range section.Routes {
+					if r.Key.Option == route.Key.Option && r.Key.Variant == "" {
+						optionNames[route.Key.Option] = r.Name
+						name = r.Name
+						break
+					}
+				}
+			}
+			route.Option = name
+		}
+	}
+}
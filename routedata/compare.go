@@ -0,0 +1,273 @@
+package routedata
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/globals"
+	"github.com/dave/gpt/kml"
+)
+
+// COMPARE_INTERVAL is the distance in km between the points sampled from a recorded track.
+const COMPARE_INTERVAL = 0.01
+
+// COVERAGE_BIN is the length in km of the pieces a segment is split into when measuring coverage.
+const COVERAGE_BIN = 0.05
+
+// Comparison is the result of aligning recorded tracks to the routes.
+type Comparison struct {
+	Name       string
+	Tolerance  float64            // recorded points further than this many km from any route are off-track
+	Coverage   []*SegmentCoverage // segments visited by the recording, in the order they were first visited
+	Deviations []*Deviation       // stretches of the recording that are off-track
+	Length     float64            // length of the recording in km
+	OnTrack    float64            // length of the recording that was on a route in km
+	coverage   map[*Segment]*SegmentCoverage
+}
+
+// SegmentCoverage records which parts of a segment were visited by a recording.
+type SegmentCoverage struct {
+	Mode    globals.ModeType
+	Route   *Route
+	Segment *Segment
+	Tracks  []geo.Line // contiguous pieces of the recording that follow this segment
+	bins    []bool
+}
+
+// Fraction is the fraction of the segment that was visited.
+func (c *SegmentCoverage) Fraction() float64 {
+	var covered int
+	for _, b := range c.bins {
+		if b {
+			covered++
+		}
+	}
+	return float64(covered) / float64(len(c.bins))
+}
+
+// Deviation is a stretch of a recording that is further than the tolerance from every route.
+type Deviation struct {
+	Line    geo.Line
+	Length  float64   // km
+	Max     float64   // maximum distance from any route in km
+	From    *Location // where the recording left the route (nil if it started off-track)
+	To      *Location // where the recording rejoined the route (nil if it ended off-track)
+	NewPath bool      // long enough that it probably follows a path that is not in the master file
+}
+
+// Compare aligns recorded tracks to the nearest regular or optional routes in any mode. Points further than tolerance km
+// from every route are off-track, and off-track stretches longer than newPath km are reported as new paths.
+func (d *Data) Compare(name string, lines []geo.Line, tolerance, newPath float64) *Comparison {
+	c := &Comparison{
+		Name:      name,
+		Tolerance: tolerance,
+		coverage:  map[*Segment]*SegmentCoverage{},
+	}
+	for _, line := range lines {
+		if len(line) < 2 {
+			continue
+		}
+		c.Length += line.Length()
+
+		var deviation *Deviation
+		var previous *Location
+		var track geo.Line
+		finishTrack := func() {
+			if previous != nil && len(track) > 1 {
+				cov := c.coverage[previous.Segment]
+				cov.Tracks = append(cov.Tracks, track)
+			}
+			track = nil
+		}
+
+		for _, pos := range line.Resample(COMPARE_INTERVAL) {
+			location := d.nearest(pos, tolerance)
+			if location == nil {
+				finishTrack()
+				if deviation == nil {
+					deviation = &Deviation{From: previous}
+				}
+				deviation.Line = append(deviation.Line, pos)
+				if distance := d.offTrack(pos, tolerance); distance > deviation.Max {
+					deviation.Max = distance
+				}
+				previous = nil
+				continue
+			}
+			if deviation != nil {
+				deviation.To = location
+				c.addDeviation(deviation, newPath)
+				deviation = nil
+			}
+			if previous != nil && previous.Segment != location.Segment {
+				finishTrack()
+			}
+			if previous != nil {
+				c.OnTrack += previous.Pos.Distance(location.Pos)
+			}
+			c.cover(location)
+			track = append(track, pos)
+			previous = location
+		}
+		finishTrack()
+		if deviation != nil {
+			c.addDeviation(deviation, newPath)
+		}
+	}
+	return c
+}
+
+func (c *Comparison) addDeviation(deviation *Deviation, newPath float64) {
+	deviation.Length = deviation.Line.Length()
+	deviation.NewPath = deviation.Length > newPath
+	c.Deviations = append(c.Deviations, deviation)
+}
+
+func (c *Comparison) cover(location *Location) {
+	cov, found := c.coverage[location.Segment]
+	if !found {
+		bins := int(location.Segment.Length/COVERAGE_BIN) + 1
+		cov = &SegmentCoverage{
+			Mode:    location.Mode,
+			Route:   location.Route,
+			Segment: location.Segment,
+			bins:    make([]bool, bins),
+		}
+		c.coverage[location.Segment] = cov
+		c.Coverage = append(c.Coverage, cov)
+	}
+	bin := int(location.Offset / COVERAGE_BIN)
+	if bin >= len(cov.bins) {
+		bin = len(cov.bins) - 1
+	}
+	cov.bins[bin] = true
+}
+
+// nearest finds the nearest route in any mode within max km. Regular routes are preferred when equally close.
+func (d *Data) nearest(pos geo.Pos, max float64) *Location {
+	var nearest *Location
+	for _, required := range globals.REQUIRED_TYPES {
+		for _, mode := range globals.MODES {
+			location := d.Locate(pos, mode, required, max)
+			if location == nil {
+				continue
+			}
+			if nearest == nil || location.Distance < nearest.Distance {
+				nearest = location
+			}
+		}
+	}
+	return nearest
+}
+
+// offTrack is the distance from pos to the nearest route in km. The search is limited to 100 times the tolerance.
+func (d *Data) offTrack(pos geo.Pos, tolerance float64) float64 {
+	radius := tolerance * 100
+	if location := d.nearest(pos, radius); location != nil {
+		return location.Distance
+	}
+	return radius
+}
+
+// Report is a plain text summary of the comparison.
+func (c *Comparison) Report() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "Comparison of %s\n", c.Name)
+	fmt.Fprintf(sb, "Recorded %.1f km, %.1f km on-track (tolerance %.0f m)\n", c.Length, c.OnTrack, c.Tolerance*1000)
+	fmt.Fprintln(sb)
+
+	fmt.Fprintln(sb, "Segment coverage:")
+	for _, cov := range c.Coverage {
+		fmt.Fprintf(sb, "  %3.0f%%  %s  %s  %s\n", cov.Fraction()*100, cov.Route.Section.FolderName(), cov.Route.Debug(), cov.Segment.PlacemarkName())
+	}
+	fmt.Fprintln(sb)
+
+	describe := func(l *Location) string {
+		if l == nil {
+			return "(off-track)"
+		}
+		return fmt.Sprintf("%s at %.1f km", l.Segment.PlacemarkName(), l.Chainage())
+	}
+	fmt.Fprintln(sb, "Deviations:")
+	for i, deviation := range c.Deviations {
+		var kind string
+		if deviation.NewPath {
+			kind = " (new path)"
+		}
+		fmt.Fprintf(sb, "  %d: %.2f km, up to %.0f m off-track%s\n", i+1, deviation.Length, deviation.Max*1000, kind)
+		fmt.Fprintf(sb, "     from %s\n", describe(deviation.From))
+		fmt.Fprintf(sb, "     to   %s\n", describe(deviation.To))
+	}
+	if len(c.Deviations) == 0 {
+		fmt.Fprintln(sb, "  none")
+	}
+	return sb.String()
+}
+
+// Save writes the text report and a KML file of discrepancies for review.
+func (c *Comparison) Save(dpath string) error {
+	base := strings.TrimSuffix(c.Name, filepath.Ext(c.Name))
+
+	if err := writeText(filepath.Join(dpath, base+" report.txt"), c.Report()); err != nil {
+		return fmt.Errorf("writing comparison report: %w", err)
+	}
+
+	deviationsFolder := &kml.Folder{Name: "Deviations", Visibility: 1}
+	newPathsFolder := &kml.Folder{Name: "New paths", Visibility: 1}
+	for i, deviation := range c.Deviations {
+		folder, style := deviationsFolder, "#deviation"
+		if deviation.NewPath {
+			folder, style = newPathsFolder, "#new-path"
+		}
+		folder.Placemarks = append(folder.Placemarks, &kml.Placemark{
+			Name:        fmt.Sprintf("Deviation %d", i+1),
+			Description: fmt.Sprintf("%.2f km, up to %.0f m off-track", deviation.Length, deviation.Max*1000),
+			Visibility:  1,
+			StyleUrl:    style,
+			LineString: &kml.LineString{
+				Tessellate:  true,
+				Coordinates: kml.LineCoordinates(deviation.Line),
+			},
+		})
+	}
+	partialFolder := &kml.Folder{Name: "Partially covered segments", Visibility: 1}
+	for _, cov := range c.Coverage {
+		if cov.Fraction() == 1 {
+			continue
+		}
+		partialFolder.Placemarks = append(partialFolder.Placemarks, &kml.Placemark{
+			Name:        cov.Segment.PlacemarkName(),
+			Description: fmt.Sprintf("%.0f%% covered", cov.Fraction()*100),
+			Visibility:  1,
+			StyleUrl:    "#partial",
+			LineString: &kml.LineString{
+				Tessellate:  true,
+				Coordinates: kml.LineCoordinates(cov.Segment.Line),
+			},
+		})
+	}
+
+	lineStyle := func(id, colour string) *kml.Style {
+		return &kml.Style{Id: id, LineStyle: &kml.LineStyle{Color: colour, Width: 4}}
+	}
+	root := kml.Root{
+		Xmlns: "http://www.opengis.net/kml/2.2",
+		Document: kml.Document{
+			Name:       base + " discrepancies",
+			Visibility: 1,
+			Open:       1,
+			Styles: []*kml.Style{
+				lineStyle("deviation", "ff0080ff"),
+				lineStyle("new-path", "ff00ff00"),
+				lineStyle("partial", "ffff00ff"),
+			},
+			Folders: []*kml.Folder{deviationsFolder, newPathsFolder, partialFolder},
+		},
+	}
+	if err := root.Save(filepath.Join(dpath, base+" discrepancies.kml")); err != nil {
+		return fmt.Errorf("writing discrepancies kml: %w", err)
+	}
+	return nil
+}
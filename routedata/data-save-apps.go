@@ -0,0 +1,155 @@
+package routedata
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/gpt/globals"
+	"github.com/dave/gpt/gpx"
+)
+
+// appStyle creates the GPX extensions that a mapping app uses to style tracks and waypoints.
+type appStyle struct {
+	folder   string
+	attrs    []xml.Attr
+	track    func(weight, colour string) *gpx.Extensions
+	waypoint func(paddle string) *gpx.Extensions
+}
+
+// paddleIcons maps the KML paddle styles used in the master file to OsmAnd icons and colours.
+var paddleIcons = map[string]struct{ icon, colour string }{
+	"red-stars":  {"special_star", "#ff0000"},
+	"ylw-circle": {"shop_supermarket", "#ffff00"},
+	"wht-blank":  {"special_marker", "#ffffff"},
+	"ylw-blank":  {"special_marker", "#ffff00"},
+	"go":         {"special_flag_start", "#00ff00"},
+	"grn-square": {"special_flag_finish", "#00ff00"},
+}
+
+var osmandStyle = appStyle{
+	folder: "GPX Files (For OsmAnd)",
+	attrs:  []xml.Attr{{Name: xml.Name{Local: "xmlns:osmand"}, Value: "https://osmand.net"}},
+	track: func(weight, colour string) *gpx.Extensions {
+		width := "medium"
+		if weight == "thick" {
+			width = "bold"
+		}
+		return &gpx.Extensions{Elements: []gpx.Element{
+			gpx.NewElement("osmand:color", "#"+colours[colour]),
+			gpx.NewElement("osmand:width", width),
+		}}
+	},
+	waypoint: func(paddle string) *gpx.Extensions {
+		icon := paddleIcons[paddle]
+		return &gpx.Extensions{Elements: []gpx.Element{
+			gpx.NewElement("osmand:icon", icon.icon),
+			gpx.NewElement("osmand:background", "circle"),
+			gpx.NewElement("osmand:color", icon.colour),
+		}}
+	},
+}
+
+var locusStyle = appStyle{
+	folder: "GPX Files (For Locus Map)",
+	attrs: []xml.Attr{
+		{Name: xml.Name{Local: "xmlns:locus"}, Value: "https://www.locusmap.app"},
+		{Name: xml.Name{Local: "xmlns:gpx_style"}, Value: "http://www.topografix.com/GPX/gpx_style/0/2"},
+	},
+	track: func(weight, colour string) *gpx.Extensions {
+		line := gpx.NewElement("gpx_style:line", "")
+		line.Elements = []gpx.Element{
+			gpx.NewElement("gpx_style:color", strings.ToUpper(colours[colour])),
+			gpx.NewElement("gpx_style:opacity", "0.85"),
+			gpx.NewElement("gpx_style:width", fmt.Sprintf("%.1f", weights[weight]*2)),
+		}
+		return &gpx.Extensions{Elements: []gpx.Element{line}}
+	},
+	waypoint: func(paddle string) *gpx.Extensions {
+		return &gpx.Extensions{Elements: []gpx.Element{
+			gpx.NewElement("locus:icon", fmt.Sprintf("http://maps.google.com/mapfiles/kml/paddle/%s.png", paddle)),
+		}}
+	},
+}
+
+// SaveOsmand saves GPX files with OsmAnd track colours and waypoint icons.
+func (d *Data) SaveOsmand(dpath string) error {
+	logln("saving osmand files")
+	return d.saveStyledGpx(dpath, osmandStyle)
+}
+
+// SaveLocus saves GPX files with Locus Map track styles and waypoint icons.
+func (d *Data) SaveLocus(dpath string) error {
+	logln("saving locus files")
+	return d.saveStyledGpx(dpath, locusStyle)
+}
+
+func (d *Data) saveStyledGpx(dpath string, style appStyle) error {
+	waypoint := func(w Waypoint, paddle string, desc string) gpx.Waypoint {
+		return gpx.Waypoint{
+			Point:      gpx.PosPoint(w.Pos),
+			Name:       w.Name,
+			Desc:       desc,
+			Extensions: style.waypoint(paddle),
+		}
+	}
+	for _, key := range d.Keys {
+		if globals.HAS_SINGLE && key != globals.SINGLE {
+			continue
+		}
+		section := d.Sections[key]
+		for _, mode := range globals.MODES {
+			ok, err := shouldEmitSection(mode, section)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			root := gpx.Root{Version: 1.1, Attrs: style.attrs}
+			for _, routeKey := range section.RouteKeys {
+				route := section.Routes[routeKey]
+				routeMode := route.Modes[mode]
+				if routeMode == nil {
+					continue
+				}
+				if route.Key.Required == globals.REGULAR {
+					start := Waypoint{Pos: routeMode.Segments[0].Line.Start(), Name: fmt.Sprintf("GPT%s%s start", key.Code(), route.Key.Direction)}
+					end := Waypoint{Pos: routeMode.Segments[len(routeMode.Segments)-1].Line.End(), Name: fmt.Sprintf("GPT%s%s end", key.Code(), route.Key.Direction)}
+					root.Waypoints = append(root.Waypoints, waypoint(start, "go", section.FolderName()), waypoint(end, "grn-square", section.FolderName()))
+				}
+				for _, segment := range routeMode.Segments {
+					parts := strings.SplitN(segment.Style(), "-", 2)
+					root.Tracks = append(root.Tracks, gpx.Track{
+						Name:       segment.PlacemarkName(),
+						Desc:       route.Debug(),
+						Extensions: style.track(parts[0], parts[1]),
+						Segments:   []gpx.TrackSegment{{Points: gpx.LineTrackPoints(segment.Line)}},
+					})
+				}
+			}
+			for i, w := range section.Waypoints {
+				root.Waypoints = append(root.Waypoints, waypoint(w, "ylw-blank", section.waypointDescription(i, mode)))
+			}
+			if err := root.Save(filepath.Join(dpath, style.folder, fmt.Sprintf("GPT%s %s.gpx", key.Code(), modeName(mode)))); err != nil {
+				return fmt.Errorf("writing GPT%s %s gpx: %w", key.Code(), modeName(mode), err)
+			}
+		}
+	}
+
+	root := gpx.Root{Version: 1.1, Attrs: style.attrs}
+	for _, w := range d.Important {
+		root.Waypoints = append(root.Waypoints, waypoint(w, "red-stars", "Important information"))
+	}
+	for _, w := range d.Resupplies {
+		root.Waypoints = append(root.Waypoints, waypoint(w, "ylw-circle", "Resupply location"))
+	}
+	for _, w := range d.Geographic {
+		root.Waypoints = append(root.Waypoints, waypoint(w, "wht-blank", "Geographic designation"))
+	}
+	if err := root.Save(filepath.Join(dpath, style.folder, "Waypoints.gpx")); err != nil {
+		return fmt.Errorf("writing waypoints gpx: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package routedata
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/dave/gpt/fit"
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/globals"
+)
+
+// TURN_ANGLE is the smallest change of direction in degrees where adjacent segments join that's added to a FIT course
+// as a turn.
+const TURN_ANGLE = 45
+
+// TURN_DISTANCE is the distance in km before and after a join used to measure the change of direction, so small
+// wiggles at the join are ignored.
+const TURN_DISTANCE = 0.1
+
+// SaveFitCourses saves a FIT course for each regular route in each mode. Section waypoints closer than threshold km
+// to the route are added as course points.
+func (d *Data) SaveFitCourses(dpath string, stamp string, threshold float64) error {
+	logln("saving fit courses")
+	start, err := time.Parse("20060102", stamp)
+	if err != nil {
+		start = time.Now()
+	}
+	for _, key := range d.Keys {
+		if globals.HAS_SINGLE && key != globals.SINGLE {
+			continue
+		}
+		section := d.Sections[key]
+		for _, mode := range globals.MODES {
+			ok, err := shouldEmitSection(mode, section)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			for _, routeKey := range section.RouteKeys {
+				if routeKey.Required != globals.REGULAR {
+					continue
+				}
+				route := section.Routes[routeKey]
+				routeMode := route.Modes[mode]
+				if routeMode == nil {
+					continue
+				}
+				sport := fit.SPORT_HIKING
+				if mode == globals.RAFT {
+					sport = fit.SPORT_PADDLING
+				}
+				course := fit.Course{
+					Name:  garminName(route),
+					Sport: sport,
+					Start: start,
+				}
+				// index in course.Line of the points where adjacent segments join
+				var joins []int
+				for _, segment := range routeMode.Segments {
+					from := segment.Modes[mode].From
+					var along float64
+					for i, pos := range segment.Line {
+						if i > 0 {
+							along += segment.Line[i-1].Distance(pos)
+						}
+						if i == 0 && len(course.Line) > 0 && course.Line.End().IsClose(pos, globals.DELTA) {
+							// skip the join between adjacent segments
+							joins = append(joins, len(course.Line)-1)
+							continue
+						}
+						distance := from + along
+						if len(course.Distances) > 0 && distance < course.Distances[len(course.Distances)-1] {
+							distance = course.Distances[len(course.Distances)-1]
+						}
+						course.Line = append(course.Line, pos)
+						course.Distances = append(course.Distances, distance)
+					}
+				}
+				for _, w := range section.Waypoints {
+					proj := course.Line.Project(w.Pos)
+					if proj.Distance > threshold {
+						continue
+					}
+					distance := course.Distances[proj.Index]
+					if proj.Index < len(course.Line)-1 {
+						distance += (course.Distances[proj.Index+1] - distance) * proj.Fraction
+					}
+					course.CoursePoints = append(course.CoursePoints, fit.CoursePoint{
+						Pos:      proj.Pos,
+						Distance: distance,
+						Name:     w.Name,
+						Type:     fit.POINT_GENERIC,
+					})
+				}
+				for _, i := range joins {
+					change, ok := turn(course.Line, i)
+					if !ok || math.Abs(change) < TURN_ANGLE {
+						continue
+					}
+					p := fit.CoursePoint{Pos: course.Line[i], Distance: course.Distances[i], Name: "Right", Type: fit.POINT_RIGHT}
+					if change < 0 {
+						p.Name, p.Type = "Left", fit.POINT_LEFT
+					}
+					course.CoursePoints = append(course.CoursePoints, p)
+				}
+				sort.SliceStable(course.CoursePoints, func(i, j int) bool {
+					return course.CoursePoints[i].Distance < course.CoursePoints[j].Distance
+				})
+				fpath := filepath.Join(dpath, "FIT Files (For Garmin watches)", fmt.Sprintf("%s %s.fit", garminName(route), modeName(mode)))
+				if err := course.Save(fpath); err != nil {
+					return fmt.Errorf("saving %s fit course: %w", route.Debug(), err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// turn is the change of direction in degrees at line[i], from -180 (left) to 180 (right). The direction is measured
+// from TURN_DISTANCE before to TURN_DISTANCE after, and ok is false at the ends of the line.
+func turn(line geo.Line, i int) (change float64, ok bool) {
+	before, after := i, i
+	for before > 0 && line[before].Distance(line[i]) < TURN_DISTANCE {
+		before--
+	}
+	for after < len(line)-1 && line[after].Distance(line[i]) < TURN_DISTANCE {
+		after++
+	}
+	if before == i || after == i {
+		return 0, false
+	}
+	in, out := line[before].Bearing(line[i]), line[i].Bearing(line[after])
+	return math.Mod(out-in+540, 360) - 180, true
+}
@@ -0,0 +1,263 @@
+package routedata
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/globals"
+	"github.com/dave/gpt/gpx"
+)
+
+// GarminProfile describes the limits of a family of Garmin devices.
+type GarminProfile struct {
+	Name          string  // name of the output folder
+	MaxPoints     int     // maximum number of points in a single track or route
+	MaxFilePoints int     // maximum number of points in a single file (0: no limit)
+	MaxItems      int     // maximum number of tracks, routes and waypoints in a single file
+	MaxNameLength int     // names are truncated to this length
+	Routes        bool    // output routes instead of tracks
+	Tolerance     float64 // simplification tolerance in km, applied before tracks are split
+	Symbols       map[string]string
+}
+
+// Waypoint kinds, used to find the symbol in GarminProfile.Symbols
+const (
+	GARMIN_START     = "start"
+	GARMIN_WAYPOINT  = "waypoint"
+	GARMIN_RESUPPLY  = "resupply"
+	GARMIN_IMPORTANT = "important"
+)
+
+var garminSymbols = map[string]string{
+	GARMIN_START:     "Flag, Green",
+	GARMIN_WAYPOINT:  "Flag, Blue",
+	GARMIN_RESUPPLY:  "Shopping Center",
+	GARMIN_IMPORTANT: "Danger Area",
+}
+
+var GarminProfiles = []GarminProfile{
+	{
+		Name:          "Modern devices (Fenix, Epix, GPSMAP 66, eTrex 22-32)",
+		MaxPoints:     10000,
+		MaxItems:      200,
+		MaxNameLength: 30,
+		Symbols:       garminSymbols,
+	},
+	{
+		Name:          "Older devices (eTrex 10-30, GPSMAP 62, Oregon 600)",
+		MaxPoints:     500,
+		MaxFilePoints: 10000,
+		MaxItems:      200,
+		MaxNameLength: 14,
+		Tolerance:     0.010,
+		Symbols:       garminSymbols,
+	},
+	{
+		Name:          "inReach (Mini, Explorer, Messenger Plus)",
+		MaxPoints:     250,
+		MaxItems:      100,
+		MaxNameLength: 20,
+		Routes:        true,
+		Tolerance:     0.020,
+		Symbols: map[string]string{
+			GARMIN_START:     "Flag, Green",
+			GARMIN_WAYPOINT:  "Waypoint",
+			GARMIN_RESUPPLY:  "Waypoint",
+			GARMIN_IMPORTANT: "Waypoint",
+		},
+	},
+}
+
+// SaveGarmin saves GPX files for each of the Garmin device profiles, with a folder for each section.
+func (d *Data) SaveGarmin(dpath string) error {
+	logln("saving garmin files")
+	for _, profile := range GarminProfiles {
+		if err := d.saveGarminProfile(filepath.Join(dpath, "GPX Files (For Garmin devices)", profile.Name), profile); err != nil {
+			return fmt.Errorf("saving garmin files (%s): %w", profile.Name, err)
+		}
+	}
+	return nil
+}
+
+func (d *Data) saveGarminProfile(dpath string, profile GarminProfile) error {
+	simplify := geo.Simplification{Tolerance: profile.Tolerance}
+	newPaged := func() *gpx.Paged {
+		return &gpx.Paged{Max: profile.MaxItems, MaxPoints: profile.MaxFilePoints}
+	}
+	for _, key := range d.Keys {
+		if globals.HAS_SINGLE && key != globals.SINGLE {
+			continue
+		}
+		section := d.Sections[key]
+		for _, mode := range globals.MODES {
+			ok, err := shouldEmitSection(mode, section)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			regular, options := newPaged(), newPaged()
+			// names must be unique within each file, and waypoint names are separate from track and route names
+			regularNames, optionNames, startNames := newGarminNames(profile), newGarminNames(profile), newGarminNames(profile)
+			for _, routeKey := range section.RouteKeys {
+				route := section.Routes[routeKey]
+				routeMode := route.Modes[mode]
+				if routeMode == nil {
+					continue
+				}
+				// each straight is a continuous line, so it can be output as a single track or route
+				var lines []geo.Line
+				for _, straight := range routeMode.Network.Straights {
+					var segmentLines []geo.Line
+					for _, segment := range straight.Segments {
+						segmentLines = append(segmentLines, segment.Line)
+					}
+					lines = append(lines, splitLine(simplify.Apply(geo.MergeLines(segmentLines)), profile.MaxPoints)...)
+				}
+				bucket := &gpx.Bucket{Order: routeMode.Segments[0].Line.Start().Lat}
+				names := regularNames
+				if route.Key.Required != globals.REGULAR {
+					names = optionNames
+				}
+				for i, line := range lines {
+					var suffix string
+					if len(lines) > 1 {
+						suffix = fmt.Sprintf(" %d/%d", i+1, len(lines))
+					}
+					name := names.unique(garminName(route), suffix)
+					desc := fmt.Sprintf("%s (%s)", route.Debug(), modeName(mode))
+					if profile.Routes {
+						bucket.Routes = append(bucket.Routes, gpx.Route{Name: name, Desc: desc, Points: gpx.LinePoints(line)})
+					} else {
+						bucket.Tracks = append(bucket.Tracks, gpx.Track{Name: name, Desc: desc, Segments: []gpx.TrackSegment{{Points: gpx.LineTrackPoints(line)}}})
+					}
+				}
+				if route.Key.Required == globals.REGULAR {
+					bucket.Waypoints = append(bucket.Waypoints, gpx.Waypoint{
+						Point: gpx.PosPoint(routeMode.Segments[0].Line.Start()),
+						Name:  startNames.unique(garminName(route), ""),
+						Sym:   profile.Symbols[GARMIN_START],
+					})
+					regular.Buckets = append(regular.Buckets, bucket)
+				} else {
+					options.Buckets = append(options.Buckets, bucket)
+				}
+			}
+			sectionPath := filepath.Join(dpath, section.FolderName())
+			if len(regular.Buckets) > 0 {
+				if err := regular.Save(filepath.Join(sectionPath, fmt.Sprintf("GPT%s %s route.gpx", key.Code(), modeName(mode)))); err != nil {
+					return fmt.Errorf("writing GPT%s route: %w", key.Code(), err)
+				}
+			}
+			if len(options.Buckets) > 0 {
+				if err := options.Save(filepath.Join(sectionPath, fmt.Sprintf("GPT%s %s options.gpx", key.Code(), modeName(mode)))); err != nil {
+					return fmt.Errorf("writing GPT%s options: %w", key.Code(), err)
+				}
+			}
+		}
+		if len(section.Waypoints) > 0 {
+			waypoints, names := newPaged(), newGarminNames(profile)
+			for _, w := range section.Waypoints {
+				waypoints.Buckets = append(waypoints.Buckets, &gpx.Bucket{
+					Order: w.Lat,
+					Waypoints: []gpx.Waypoint{{
+						Point: gpx.PosPoint(w.Pos),
+						Name:  names.unique(w.Name, ""),
+						Desc:  w.Name,
+						Sym:   profile.Symbols[GARMIN_WAYPOINT],
+					}},
+				})
+			}
+			if err := waypoints.Save(filepath.Join(dpath, section.FolderName(), fmt.Sprintf("GPT%s waypoints.gpx", key.Code()))); err != nil {
+				return fmt.Errorf("writing GPT%s waypoints: %w", key.Code(), err)
+			}
+		}
+	}
+
+	wp := func(waypoints []Waypoint, name string, symbol string) error {
+		paged, names := newPaged(), newGarminNames(profile)
+		for _, w := range waypoints {
+			paged.Buckets = append(paged.Buckets, &gpx.Bucket{
+				Order: w.Lat,
+				Waypoints: []gpx.Waypoint{{
+					Point: gpx.PosPoint(w.Pos),
+					Name:  names.unique(w.Name, ""),
+					Desc:  w.Name,
+					Sym:   profile.Symbols[symbol],
+				}},
+			})
+		}
+		return paged.Save(filepath.Join(dpath, name))
+	}
+	if err := wp(d.Resupplies, "Resupply Locations.gpx", GARMIN_RESUPPLY); err != nil {
+		return fmt.Errorf("writing resupplies: %w", err)
+	}
+	if err := wp(d.Important, "Important Information.gpx", GARMIN_IMPORTANT); err != nil {
+		return fmt.Errorf("writing important information: %w", err)
+	}
+	return nil
+}
+
+// garminName is a short name for a route e.g. GPT24, GPT24S, GPT24-03B, GPT24-HA2
+func garminName(r *Route) string {
+	name := "GPT" + r.Section.Key.Code() + r.Key.Direction
+	if r.Key.Required == globals.OPTIONAL {
+		if r.Key.Alternatives {
+			name += fmt.Sprintf("-HA%d", r.Key.AlternativesIndex)
+		} else if r.Key.Option > 0 {
+			name += fmt.Sprintf("-%02d%s%s", r.Key.Option, r.Key.Variant, r.Key.Network)
+		} else {
+			name += fmt.Sprintf("-%s%s", r.Key.Variant, r.Key.Network)
+		}
+	}
+	return name
+}
+
+// splitLine splits a line into several lines of at most max points. Adjacent lines share a point so there's no gap.
+func splitLine(line geo.Line, max int) []geo.Line {
+	if max < 2 || len(line) <= max {
+		return []geo.Line{line}
+	}
+	var lines []geo.Line
+	for start := 0; start < len(line)-1; start += max - 1 {
+		end := start + max
+		if end > len(line) {
+			end = len(line)
+		}
+		lines = append(lines, line[start:end])
+	}
+	return lines
+}
+
+// garminNames makes names unique within a file. Garmin devices treat items with the same name as duplicates, so
+// names that are the same after truncating would overwrite each other.
+type garminNames struct {
+	max  int
+	used map[string]bool
+}
+
+func newGarminNames(profile GarminProfile) *garminNames {
+	return &garminNames{max: profile.MaxNameLength, used: map[string]bool{}}
+}
+
+// unique truncates s so the name with the suffix fits the maximum length. If the name has already been used, s is
+// truncated further and a number is added e.g. "Refugio Lag~2".
+func (n *garminNames) unique(s, suffix string) string {
+	name := truncate(s, n.max-len([]rune(suffix))) + suffix
+	for i := 2; n.used[name]; i++ {
+		number := fmt.Sprintf("~%d", i)
+		name = truncate(s, n.max-len([]rune(suffix))-len(number)) + number + suffix
+	}
+	n.used[name] = true
+	return name
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if max <= 0 || len(r) <= max {
+		return s
+	}
+	return string(r[:max])
+}
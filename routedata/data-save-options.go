@@ -0,0 +1,82 @@
+package routedata
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/gpt/globals"
+)
+
+// SaveOptionTable writes a table of where each option leaves and rejoins the regular route, as CSV and as Markdown
+// with a table per section.
+func (d *Data) SaveOptionTable(dpath string) error {
+	logln("saving option table")
+	titles := []string{"Section", "Option", "Mode", "Regular route", "Length km", "Departs km", "Rejoins km", "Bypassed km", "Difference km"}
+	km := func(ok bool, v float64) string {
+		if !ok {
+			return ""
+		}
+		return fmt.Sprintf("%.1f", v)
+	}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.Write(titles); err != nil {
+		return fmt.Errorf("writing option table csv: %w", err)
+	}
+	md := &strings.Builder{}
+	fmt.Fprintln(md, "# Options")
+	for _, key := range d.Keys {
+		if globals.HAS_SINGLE && key != globals.SINGLE {
+			continue
+		}
+		section := d.Sections[key]
+		var rows [][]string
+		for _, routeKey := range section.RouteKeys {
+			if routeKey.Required != globals.OPTIONAL {
+				continue
+			}
+			route := section.Routes[routeKey]
+			for _, mode := range globals.MODES {
+				if route.Modes[mode] == nil || route.Modes[mode].Network.Junction == nil {
+					continue
+				}
+				j := route.Modes[mode].Network.Junction
+				both := j.Departs && j.Rejoins
+				rows = append(rows, []string{
+					"GPT" + section.Key.Code(),
+					routeKey.Debug(),
+					modeName(mode),
+					j.Regular.Key.Debug(),
+					km(true, j.Length),
+					km(j.Departs, j.Depart),
+					km(j.Rejoins, j.Rejoin),
+					km(both, j.Bypassed),
+					km(both, j.Difference),
+				})
+			}
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		if err := w.WriteAll(rows); err != nil {
+			return fmt.Errorf("writing option table csv: %w", err)
+		}
+		fmt.Fprintf(md, "\n## %s\n\n", section.FolderName())
+		fmt.Fprintf(md, "| %s |\n", strings.Join(titles[1:], " | "))
+		fmt.Fprintf(md, "|%s\n", strings.Repeat("---|", len(titles)-1))
+		for _, row := range rows {
+			fmt.Fprintf(md, "| %s |\n", strings.Join(row[1:], " | "))
+		}
+	}
+
+	for ext, contents := range map[string]string{"csv": buf.String(), "md": md.String()} {
+		if err := writeText(filepath.Join(dpath, "Summary", "Options."+ext), contents); err != nil {
+			return fmt.Errorf("writing option table: %w", err)
+		}
+	}
+	return nil
+}
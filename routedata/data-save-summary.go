@@ -0,0 +1,226 @@
+package routedata
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/gpt/globals"
+)
+
+// TERRAINS is the terrain codes in the order they are shown in the summary.
+var TERRAINS = []string{"TL", "MR", "PR", "CC", "BB", "FJ", "LK", "RI", "FY"}
+
+// SectionStats is the summary of a regular route in a section for one mode.
+type SectionStats struct {
+	Section      *Section
+	Route        *Route
+	Mode         globals.ModeType
+	Length       float64            // km
+	Options      int                // number of options
+	Variants     int                // number of variants
+	Terrains     map[string]float64 // km by terrain code. Segments with several terrains are shared equally.
+	Verification map[string]float64 // km by verification code ("" for none)
+	Experimental float64            // km
+	Ascent       float64            // m
+	Descent      float64            // m
+	Start, End   [2]float64         // lat, lon
+}
+
+// SectionSummary builds the summary for each regular route and mode.
+func (d *Data) SectionSummary() ([]*SectionStats, error) {
+	var summary []*SectionStats
+	for _, key := range d.Keys {
+		if globals.HAS_SINGLE && key != globals.SINGLE {
+			continue
+		}
+		section := d.Sections[key]
+		for _, mode := range globals.MODES {
+			ok, err := shouldEmitSection(mode, section)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			options := map[int]bool{}
+			var variants int
+			for _, routeKey := range section.RouteKeys {
+				route := section.Routes[routeKey]
+				if routeKey.Required != globals.OPTIONAL || routeKey.Alternatives || route.Modes[mode] == nil {
+					continue
+				}
+				if routeKey.Option > 0 {
+					options[routeKey.Option] = true
+				} else {
+					variants++
+				}
+			}
+			for _, routeKey := range section.RouteKeys {
+				if routeKey.Required != globals.REGULAR {
+					continue
+				}
+				route := section.Routes[routeKey]
+				routeMode := route.Modes[mode]
+				if routeMode == nil || len(routeMode.Segments) == 0 {
+					continue
+				}
+				stats := &SectionStats{
+					Section:      section,
+					Route:        route,
+					Mode:         mode,
+					Options:      len(options),
+					Variants:     variants,
+					Terrains:     map[string]float64{},
+					Verification: map[string]float64{},
+				}
+				for _, straight := range routeMode.Network.Straights {
+					for _, flush := range straight.Flushes {
+						stats.Length += flush.Length
+						for _, terrain := range flush.Terrains {
+							stats.Terrains[terrain] += flush.Length / float64(len(flush.Terrains))
+						}
+						stats.Verification[flush.Verification] += flush.Length
+						if flush.Experimental {
+							stats.Experimental += flush.Length
+						}
+					}
+				}
+				for _, segment := range routeMode.Segments {
+					for i := 1; i < len(segment.Line); i++ {
+						if climb := segment.Line[i].Ele - segment.Line[i-1].Ele; climb > 0 {
+							stats.Ascent += climb
+						} else {
+							stats.Descent -= climb
+						}
+					}
+				}
+				start := routeMode.Segments[0].Line.Start()
+				end := routeMode.Segments[len(routeMode.Segments)-1].Line.End()
+				stats.Start = [2]float64{start.Lat, start.Lon}
+				stats.End = [2]float64{end.Lat, end.Lon}
+				summary = append(summary, stats)
+			}
+		}
+	}
+	return summary, nil
+}
+
+// summaryColumn is a column in the summary table.
+type summaryColumn struct {
+	title string
+	value func(s *SectionStats) string
+}
+
+func summaryColumns() []summaryColumn {
+	km := func(v float64) string { return fmt.Sprintf("%.1f", v) }
+	columns := []summaryColumn{
+		{"Section", func(s *SectionStats) string { return "GPT" + s.Section.Key.Code() }},
+		{"Name", func(s *SectionStats) string { return s.Section.Name }},
+		{"Direction", func(s *SectionStats) string { return s.Route.Key.Direction }},
+		{"Mode", func(s *SectionStats) string { return modeName(s.Mode) }},
+		{"Length km", func(s *SectionStats) string { return km(s.Length) }},
+		{"Options", func(s *SectionStats) string { return fmt.Sprint(s.Options) }},
+		{"Variants", func(s *SectionStats) string { return fmt.Sprint(s.Variants) }},
+	}
+	for _, terrain := range TERRAINS {
+		terrain := terrain
+		columns = append(columns, summaryColumn{Terrain(terrain) + " km", func(s *SectionStats) string { return km(s.Terrains[terrain]) }})
+	}
+	for _, v := range []struct{ code, title string }{{"V", "Verified"}, {"A", "Approximate"}, {"I", "Investigation"}} {
+		v := v
+		columns = append(columns, summaryColumn{v.title + " km", func(s *SectionStats) string { return km(s.Verification[v.code]) }})
+	}
+	columns = append(columns,
+		summaryColumn{"Exploration km", func(s *SectionStats) string { return km(s.Experimental) }},
+		summaryColumn{"Ascent m", func(s *SectionStats) string { return fmt.Sprintf("%.0f", s.Ascent) }},
+		summaryColumn{"Descent m", func(s *SectionStats) string { return fmt.Sprintf("%.0f", s.Descent) }},
+		summaryColumn{"Start", func(s *SectionStats) string { return fmt.Sprintf("%.5f,%.5f", s.Start[0], s.Start[1]) }},
+		summaryColumn{"End", func(s *SectionStats) string { return fmt.Sprintf("%.5f,%.5f", s.End[0], s.End[1]) }},
+	)
+	// from the wiki summary table, if it was scraped
+	wiki := func(value func(summary *SectionSummary, mode *SectionModeSummary) string) func(s *SectionStats) string {
+		return func(s *SectionStats) string {
+			summary := s.Section.Summary
+			if summary == nil {
+				return ""
+			}
+			mode := summary.Modes[s.Mode]
+			if mode == nil {
+				mode = &SectionModeSummary{}
+			}
+			return value(summary, mode)
+		}
+	}
+	columns = append(columns,
+		summaryColumn{"Status", wiki(func(s *SectionSummary, m *SectionModeSummary) string { return s.Status })},
+		summaryColumn{"Traversable", wiki(func(s *SectionSummary, m *SectionModeSummary) string { return s.Traversable })},
+		summaryColumn{"Packraft", wiki(func(s *SectionSummary, m *SectionModeSummary) string { return s.Packraft })},
+		summaryColumn{"Difficulty", wiki(func(s *SectionSummary, m *SectionModeSummary) string { return m.Difficulty })},
+		summaryColumn{"Attraction", wiki(func(s *SectionSummary, m *SectionModeSummary) string { return m.Attraction })},
+	)
+	return columns
+}
+
+// SaveSummary writes the section summary table as CSV, Markdown and HTML.
+func (d *Data) SaveSummary(dpath string) error {
+	logln("saving summary")
+	summary, err := d.SectionSummary()
+	if err != nil {
+		return fmt.Errorf("building summary: %w", err)
+	}
+	columns := summaryColumns()
+	var rows [][]string
+	for _, stats := range summary {
+		var row []string
+		for _, column := range columns {
+			row = append(row, column.value(stats))
+		}
+		rows = append(rows, row)
+	}
+	var titles []string
+	for _, column := range columns {
+		titles = append(titles, column.title)
+	}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.Write(titles); err != nil {
+		return fmt.Errorf("writing summary csv: %w", err)
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return fmt.Errorf("writing summary csv: %w", err)
+	}
+
+	md := &strings.Builder{}
+	fmt.Fprintf(md, "| %s |\n", strings.Join(titles, " | "))
+	fmt.Fprintf(md, "|%s\n", strings.Repeat("---|", len(titles)))
+	for _, row := range rows {
+		fmt.Fprintf(md, "| %s |\n", strings.Join(row, " | "))
+	}
+
+	h := &strings.Builder{}
+	h.WriteString("<table class=\"gpt-summary\">\n<thead>\n<tr>")
+	for _, title := range titles {
+		fmt.Fprintf(h, "<th>%s</th>", html.EscapeString(title))
+	}
+	h.WriteString("</tr>\n</thead>\n<tbody>\n")
+	for _, row := range rows {
+		h.WriteString("<tr>")
+		for _, cell := range row {
+			fmt.Fprintf(h, "<td>%s</td>", html.EscapeString(cell))
+		}
+		h.WriteString("</tr>\n")
+	}
+	h.WriteString("</tbody>\n</table>\n")
+
+	for ext, contents := range map[string]string{"csv": buf.String(), "md": md.String(), "html": h.String()} {
+		if err := writeText(filepath.Join(dpath, "Summary", "Section Summary."+ext), contents); err != nil {
+			return fmt.Errorf("writing summary: %w", err)
+		}
+	}
+	return nil
+}
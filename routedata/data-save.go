@@ -44,6 +44,7 @@ func (r *LegacyRenameHolder) waypoint(legacy, name string) string {
 func (d *Data) SaveMaster(dpath string, updateLegacy bool) error {
 	logln("saving kml master")
 	legacy := &LegacyRenameHolder{update: updateLegacy}
+	d.renames = legacy
 
 	tracksFolder := &kml.Folder{Name: "Tracks"}
 
@@ -145,7 +146,7 @@ func (d *Data) SaveMaster(dpath string, updateLegacy bool) error {
 	//	return of.Folders[i].Name < of.Folders[j].Name
 	//})
 
-	regularStartEndFolder, optionalStartEndFolder, resupplyFolder, geographicFolder, importantFolder, waypointsFolder := d.getWaypointFolders(legacy)
+	regularStartEndFolder, optionalStartEndFolder, resupplyFolder, geographicFolder, importantFolder, waypointsFolder := d.getWaypointFolders(legacy, false)
 
 	pointsFolder := &kml.Folder{
 		Name: "Points",
@@ -193,7 +194,9 @@ func (d *Data) SaveMaster(dpath string, updateLegacy bool) error {
 	return nil
 }
 
-func (d *Data) getWaypointFolders(legacy *LegacyRenameHolder) (regularStartEndFolder, optionalStartEndFolder, resupplyFolder, geographicFolder, importantFolder, waypointsFolder *kml.Folder) {
+// getWaypointFolders builds the waypoint folders. If describe is set, section waypoints are given their snapped route
+// and chainage as a description (not wanted in the master file).
+func (d *Data) getWaypointFolders(legacy *LegacyRenameHolder, describe bool) (regularStartEndFolder, optionalStartEndFolder, resupplyFolder, geographicFolder, importantFolder, waypointsFolder *kml.Folder) {
 
 	collect := func(waypoints []Waypoint, style string) []*kml.Placemark {
 		var placemarks []*kml.Placemark
@@ -327,15 +330,22 @@ func (d *Data) getWaypointFolders(legacy *LegacyRenameHolder) (regularStartEndFo
 			Name: section.FolderName(),
 		}
 		subfolders := map[string]*kml.Folder{}
-		for _, w := range section.Waypoints {
+		description := func(i int) string {
+			if !describe {
+				return ""
+			}
+			return section.waypointDescription(i)
+		}
+		for i, w := range section.Waypoints {
 			if w.Folder == "" {
 				sectionFolder.Placemarks = append(sectionFolder.Placemarks, &kml.Placemark{
-					Visibility: 1,
-					Open:       0,
-					StyleUrl:   "#ylw-blank",
-					Name:       w.Name,
-					Legacy:     legacy.waypoint(w.Legacy, w.Name),
-					Point:      kml.PosPoint(w.Pos),
+					Visibility:  1,
+					Open:        0,
+					StyleUrl:    "#ylw-blank",
+					Name:        w.Name,
+					Description: description(i),
+					Legacy:      legacy.waypoint(w.Legacy, w.Name),
+					Point:       kml.PosPoint(w.Pos),
 				})
 			} else {
 				if subfolders[w.Folder] == nil {
@@ -346,12 +356,13 @@ func (d *Data) getWaypointFolders(legacy *LegacyRenameHolder) (regularStartEndFo
 					sectionFolder.Folders = append(sectionFolder.Folders, f)
 				}
 				subfolders[w.Folder].Placemarks = append(subfolders[w.Folder].Placemarks, &kml.Placemark{
-					Visibility: 1,
-					Open:       0,
-					StyleUrl:   "#ylw-blank",
-					Name:       w.Name,
-					Legacy:     legacy.waypoint(w.Legacy, w.Name),
-					Point:      kml.PosPoint(w.Pos),
+					Visibility:  1,
+					Open:        0,
+					StyleUrl:    "#ylw-blank",
+					Name:        w.Name,
+					Description: description(i),
+					Legacy:      legacy.waypoint(w.Legacy, w.Name),
+					Point:       kml.PosPoint(w.Pos),
 				})
 			}
 		}
@@ -374,7 +385,7 @@ func (d *Data) SaveKmlWaypoints(dpath string, stamp string) error {
 
 	legacy := &LegacyRenameHolder{update: false}
 
-	regularStartEndFolder, optionalStartEndFolder, resupplyFolder, geographicFolder, importantFolder, waypointsFolder := d.getWaypointFolders(legacy)
+	regularStartEndFolder, optionalStartEndFolder, resupplyFolder, geographicFolder, importantFolder, waypointsFolder := d.getWaypointFolders(legacy, true)
 
 	all := kml.Root{
 		Xmlns: "http://www.opengis.net/kml/2.2",
@@ -546,6 +557,14 @@ func (d *Data) SaveKmlTracks(dpath string, stamp string) error {
 					trackFolder = &kml.Folder{
 						Name: route.FolderName(),
 					}
+					for _, mode := range globals.MODES {
+						if route.Modes[mode] != nil && route.Modes[mode].Network.Junction != nil {
+							trackFolder.Description += fmt.Sprintf("%s: %s\n", strings.Title(modeName(mode)), route.Modes[mode].Network.Junction.Description())
+						}
+					}
+					if scraped := strings.TrimSpace(route.Scraped); scraped != "" {
+						trackFolder.Description += "\n" + scraped
+					}
 					sectionFolder.Folders = append(sectionFolder.Folders, trackFolder)
 				}
 				for _, segment := range route.All {
@@ -622,7 +641,7 @@ func (d *Data) SaveKmlTracks(dpath string, stamp string) error {
 	return nil
 }
 
-func (d *Data) SaveGpx(dpath string, stamp string) error {
+func (d *Data) SaveGpx(dpath string, stamp string, simplify geo.Simplification) error {
 	logln("saving gpx files")
 	type matcher struct {
 		path     []string
@@ -1128,7 +1147,7 @@ func (d *Data) SaveGpx(dpath string, stamp string) error {
 		for _, segment := range m.segments {
 			g.Tracks = append(g.Tracks, gpx.Track{
 				Name:     segment.PlacemarkName(),
-				Segments: []gpx.TrackSegment{{Points: gpx.LineTrackPoints(segment.Line)}},
+				Segments: []gpx.TrackSegment{{Points: gpx.LineTrackPoints(simplify.Apply(segment.Line))}},
 			})
 		}
 		fpath := filepath.Join(append([]string{dpath, "GPX Files (For Smartphones and Basecamp)"}, m.path...)...)
@@ -1143,10 +1162,11 @@ func (d *Data) SaveGpx(dpath string, stamp string) error {
 			continue
 		}
 		section := d.Sections[key]
-		for _, w := range section.Waypoints {
+		for i, w := range section.Waypoints {
 			wpAll.Waypoints = append(wpAll.Waypoints, gpx.Waypoint{
 				Point: gpx.PosPoint(w.Pos),
 				Name:  w.Name,
+				Desc:  section.waypointDescription(i),
 			})
 		}
 	}
@@ -1266,7 +1286,7 @@ type bySectionFiles struct {
 	options *gpx.Paged
 }
 
-func (d *Data) SaveGaia(dpath string) error {
+func (d *Data) SaveGaia(dpath string, simplify geo.Simplification) error {
 	logln("saving gaia files")
 
 	bySection := map[globals.ModeType]map[globals.SectionKey]*bySectionFiles{}
@@ -1342,6 +1362,9 @@ func (d *Data) SaveGaia(dpath string) error {
 					}
 					rte.Name = fmt.Sprintf("GPT%s %s%s", section.Key.Code(), section.Name, direction)
 					rte.Desc = H1_SYMBOL + " " + rte.Name + "\n\n"
+					if v := network.ViolationsDescription(); v != "" {
+						rte.Desc += v + "\n"
+					}
 
 					var lines []geo.Line
 					for _, segment := range routeMode.Segments {
@@ -1359,7 +1382,7 @@ func (d *Data) SaveGaia(dpath string) error {
 						}
 					}
 
-					rte.Points = gpx.LinePoints(geo.MergeLines(lines))
+					rte.Points = gpx.LinePoints(simplify.Apply(geo.MergeLines(lines)))
 					rte.Desc += section.Scraped[mode]
 					bucket.Routes = append(bucket.Routes, rte)
 					bucketBySection.Routes = append(bucketBySection.Routes, rte)
@@ -1464,6 +1487,12 @@ func (d *Data) SaveGaia(dpath string) error {
 						}
 					}
 					trk.Desc = H1_SYMBOL + " " + trk.Name + "\n\n"
+					if network.Junction != nil {
+						trk.Desc += network.Junction.Description() + "\n\n"
+					}
+					if v := network.ViolationsDescription(); v != "" {
+						trk.Desc += v + "\n"
+					}
 
 					var id int
 					for i, straight := range network.Straights {
@@ -1475,12 +1504,25 @@ func (d *Data) SaveGaia(dpath string) error {
 							trk.Desc += flush.Description(id, false) + "\n"
 						}
 					}
+					trk.Desc += route.Scraped
 
+					var lines []geo.Line
 					for _, segment := range routeMode.Segments {
-						trk.Segments = append(trk.Segments, gpx.TrackSegment{Points: gpx.LineTrackPoints(segment.Line)})
+						lines = append(lines, segment.Line)
+					}
+					// lines that don't fit within the maximum points are split into extra tracks
+					groups := simplify.ApplyAll(lines)
+					for i, group := range groups {
+						part := gpx.Track{Name: trk.Name, Desc: trk.Desc}
+						if len(groups) > 1 {
+							part.Name += fmt.Sprintf(" %d/%d", i+1, len(groups))
+						}
+						for _, line := range group {
+							part.Segments = append(part.Segments, gpx.TrackSegment{Points: gpx.LineTrackPoints(line)})
+						}
+						bucket.Tracks = append(bucket.Tracks, part)
+						bucketBySection.Tracks = append(bucketBySection.Tracks, part)
 					}
-					bucket.Tracks = append(bucket.Tracks, trk)
-					bucketBySection.Tracks = append(bucketBySection.Tracks, trk)
 				}
 				root.Buckets = append(root.Buckets, bucket)
 				bySection[mode][key].options.Buckets = append(bySection[mode][key].options.Buckets, bucketBySection)
@@ -1530,7 +1572,7 @@ func (d *Data) SaveGaia(dpath string) error {
 			waypointsByKey[key] = &gpx.Paged{
 				Max: 1000,
 			}
-			for _, w := range d.Sections[key].Waypoints {
+			for i, w := range d.Sections[key].Waypoints {
 				bucket := &gpx.Bucket{
 					Order: w.Pos.Lat,
 				}
@@ -1540,7 +1582,7 @@ func (d *Data) SaveGaia(dpath string) error {
 				wpt := gpx.Waypoint{
 					Point: gpx.PosPoint(w.Pos),
 					Name:  w.Name,
-					Desc:  "GPT" + key.Code(),
+					Desc:  d.Sections[key].waypointDescription(i),
 				}
 				bucket.Waypoints = append(bucket.Waypoints, wpt)
 				bucketByKey.Waypoints = append(bucketByKey.Waypoints, wpt)
@@ -89,7 +89,7 @@ func (d *Data) Scan(inputRoot kml.Root, elevation bool) error {
 			suffix := matches[2]
 			name := strings.TrimSpace(matches[3])
 
-			sectionKey := globals.SectionKey{number, suffix}
+			sectionKey := globals.SectionKey{Number: number, Suffix: suffix}
 
 			if globals.HAS_SINGLE && sectionKey != globals.SINGLE {
 				continue
@@ -499,7 +499,7 @@ func (d *Data) Scan(inputRoot kml.Root, elevation bool) error {
 				}
 				suffix := matches[2]
 				name := strings.TrimSpace(matches[3])
-				sectionKey := globals.SectionKey{number, suffix}
+				sectionKey := globals.SectionKey{Number: number, Suffix: suffix}
 				section := d.Sections[sectionKey]
 				if section == nil {
 					continue
@@ -0,0 +1,320 @@
+package routedata
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// scrapeApi gets the wikitext of the section page from the MediaWiki API, and converts it to the same description
+// format as the web page.
+func (s *Section) scrapeApi(fetcher *Fetcher) (*scrapedPage, error) {
+	url := fmt.Sprintf("http://www.wikiexplora.com/api.php?action=parse&page=GPT%s&prop=wikitext&redirects=1&format=json&formatversion=2", s.Key.Code())
+	b, err := fetcher.Get(url, fmt.Sprintf("GPT%s.json", s.Key.Code()))
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Parse *struct {
+			Wikitext string `json:"wikitext"`
+		} `json:"parse"`
+		Error *struct {
+			Code string `json:"code"`
+			Info string `json:"info"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(b, &response); err != nil {
+		return nil, fmt.Errorf("decoding %q: %w", url, err)
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("getting %q: %s (%s)", url, response.Error.Info, response.Error.Code)
+	}
+	if response.Parse == nil {
+		return nil, fmt.Errorf("getting %q: no page in response", url)
+	}
+	return parseWikitext(response.Parse.Wikitext), nil
+}
+
+// wikiHeading is a heading in the wikitext, and the lines up to the next heading.
+type wikiHeading struct {
+	level int // number of "=" e.g. "== Route ==" is 2
+	title string
+	body  []string
+}
+
+var headingRegex = regexp.MustCompile(`^(=+)(.*?)(=+)\s*$`)
+
+// parseWikitext converts the wikitext of a section page to a description. The same sections are removed as for the
+// web page, tables are replaced with a warning, and links are kept as "text [url]".
+func parseWikitext(wikitext string) *scrapedPage {
+	p := &scrapedPage{}
+
+	wikitext = commentRegex.ReplaceAllString(wikitext, "")
+	var headings []*wikiHeading
+	for _, line := range strings.Split(wikitext, "\n") {
+		if matches := headingRegex.FindStringSubmatch(line); matches != nil {
+			// with unequal runs of "=" the level is the shorter run, and the rest of the longer run is part of the title
+			// e.g. "=== Route ==" is "= Route" at level 2
+			level := len(matches[1])
+			if len(matches[3]) < level {
+				level = len(matches[3])
+			}
+			title := matches[1][level:] + matches[2] + matches[3][level:]
+			headings = append(headings, &wikiHeading{level: level, title: strings.TrimSpace(convertWikitext(title, false))})
+			continue
+		}
+		if len(headings) > 0 {
+			headings[len(headings)-1].body = append(headings[len(headings)-1].body, line)
+		}
+	}
+
+	parents := &headingStack{}
+	for i := 0; i < len(headings); i++ {
+		heading := headings[i]
+		headingSymbol := H2_SYMBOL
+		if heading.level == 1 {
+			headingSymbol = H1_SYMBOL
+		}
+		ancestors := parents.push(heading.level, heading.title)
+		// the body of the heading and all the subheadings
+		body := append([]string{}, heading.body...)
+		end := i + 1
+		for end < len(headings) && headings[end].level > heading.level {
+			body = append(body, headings[end].body...)
+			end++
+		}
+
+		switch heading.title {
+		case "How to a add new entry", "Elevation Profile", "Satellite Image Map", "Summary Table", "Alerts and Logs of Past Seasons", "Older information for review", "Image Gallery", "Images":
+			if heading.title == "Summary Table" && heading.level <= 2 {
+				p.hiking += headingSymbol + " " + heading.title + "\n\n"
+				p.packrafting += headingSymbol + " " + heading.title + "\n\n"
+				for _, row := range wikiTableRows(body) {
+					var values, plain []string
+					for _, cell := range row[1:] {
+						values = append(values, strings.TrimSpace(convertWikitext(cell, true)))
+						plain = append(plain, strings.TrimSpace(convertWikitext(cell, false)))
+					}
+					p.summaryRow(strings.TrimSpace(convertWikitext(row[0], false)), values, plain)
+				}
+				p.hiking += "\n"
+				p.packrafting += "\n"
+			} else if wikiHasContent(body) && heading.title != "How to a add new entry" {
+				p.description += headingSymbol + " " + heading.title + "\n\n"
+				p.description += WARNING_SYMBOL + " Section removed - see web page.\n\n"
+			}
+			i = end - 1
+			continue
+		}
+
+		if !wikiHasContent(body) {
+			continue
+		}
+		var text string
+		for _, block := range wikiBlocks(heading.body) {
+			if strings.HasPrefix(block, "{|") {
+				text += WARNING_SYMBOL + " Table removed - see web page.\n\n"
+			} else if str := strings.TrimSpace(convertWikitext(block, true)); !wikiPlaceholder(str) {
+				text += str + "\n\n"
+			}
+		}
+		p.description += headingSymbol + " " + heading.title + "\n\n" + text
+		p.subsections = append(p.subsections, &scrapedSubsection{parents: ancestors, title: heading.title, text: text})
+	}
+	return p
+}
+
+// wikiHasContent is true if there's text other than "To be issued." or "Not applicable.".
+func wikiHasContent(lines []string) bool {
+	for _, block := range wikiBlocks(lines) {
+		if !wikiPlaceholder(strings.TrimSpace(convertWikitext(block, false))) {
+			return true
+		}
+	}
+	return false
+}
+
+// wikiPlaceholder is true for a paragraph with no text, or only "To be issued." or "Not applicable.", which are left
+// out like on the web page.
+func wikiPlaceholder(s string) bool {
+	switch s {
+	case "", "To be issued.", "Not applicable.":
+		return true
+	}
+	return false
+}
+
+// wikiBlocks splits lines into paragraphs and tables. Paragraphs are separated by blank lines, and tables are from "{|"
+// to "|}".
+func wikiBlocks(lines []string) []string {
+	var blocks []string
+	var current []string
+	var table int
+	flush := func() {
+		if len(current) > 0 {
+			blocks = append(blocks, strings.Join(current, "\n"))
+			current = nil
+		}
+	}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "{|"):
+			if table == 0 {
+				flush()
+			}
+			table++
+			current = append(current, trimmed)
+		case table > 0:
+			current = append(current, trimmed)
+			if strings.HasPrefix(trimmed, "|}") {
+				table--
+				if table == 0 {
+					flush()
+				}
+			}
+		case trimmed == "":
+			flush()
+		default:
+			// remove list and indent markers
+			current = append(current, strings.TrimLeft(trimmed, "*#:; "))
+		}
+	}
+	flush()
+	return blocks
+}
+
+// wikiTableRows finds the cells of each row of the tables in the lines. Cells are separated by "||" or "!!", or are
+// on separate lines starting with "|" or "!". Cell attributes (e.g. `style="..." | value`) are removed.
+func wikiTableRows(lines []string) [][]string {
+	var rows [][]string
+	var row []string
+	flush := func() {
+		if len(row) > 0 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "{|"), strings.HasPrefix(line, "|+"):
+		case strings.HasPrefix(line, "|-"), strings.HasPrefix(line, "|}"):
+			flush()
+		case strings.HasPrefix(line, "|"), strings.HasPrefix(line, "!"):
+			for _, cell := range splitOutsideLinks(line[1:], "||", "!!") {
+				if parts := splitOutsideLinks(cell, "|"); len(parts) > 1 {
+					// attributes before the value
+					cell = strings.Join(parts[1:], "|")
+				}
+				row = append(row, strings.TrimSpace(cell))
+			}
+		default:
+			// continuation of the previous cell
+			if len(row) > 0 {
+				row[len(row)-1] += "\n" + line
+			}
+		}
+	}
+	flush()
+	return rows
+}
+
+// splitOutsideLinks splits s at any of the separators that aren't inside [[links]] or {{templates}}.
+func splitOutsideLinks(s string, separators ...string) []string {
+	var parts []string
+	var depth, start int
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "[[") || strings.HasPrefix(s[i:], "{{"):
+			depth++
+			i++
+			continue
+		case strings.HasPrefix(s[i:], "]]") || strings.HasPrefix(s[i:], "}}"):
+			if depth > 0 {
+				depth--
+			}
+			i++
+			continue
+		}
+		if depth > 0 {
+			continue
+		}
+		for _, separator := range separators {
+			if strings.HasPrefix(s[i:], separator) {
+				parts = append(parts, s[start:i])
+				start = i + len(separator)
+				i += len(separator) - 1
+				break
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+var (
+	commentRegex      = regexp.MustCompile(`(?s)<!--.*?-->`)
+	breakRegex        = regexp.MustCompile(`(?i)<br\s*/?>`)
+	templateRegex     = regexp.MustCompile(`\{\{[^{}]*\}\}`)
+	fileRegex         = regexp.MustCompile(`(?i)\[\[(file|image|archivo|imagen|category|categoría):([^\[\]]|\[\[[^\[\]]*\]\]|\[[^\[\]]*\])*\]\]`)
+	internalLinkRegex = regexp.MustCompile(`\[\[([^\[\]|]+)(?:\|([^\[\]]*))?\]\]`)
+	externalLinkRegex = regexp.MustCompile(`\[((?:https?:)?//[^\s\]]+)(?:\s+([^\]]*))?\]`)
+	formattingRegex   = regexp.MustCompile(`'{2,}`)
+	tagRegex          = regexp.MustCompile(`<[^>]+>`)
+)
+
+// convertWikitext converts wikitext markup to plain text. If links is set, links are written as "text [url]" like
+// the links in the web page, otherwise only the text is kept.
+func convertWikitext(s string, links bool) string {
+	s = commentRegex.ReplaceAllString(s, "")
+	s = breakRegex.ReplaceAllString(s, "\n")
+	for {
+		// templates can be nested, so remove the innermost until there are none left
+		replaced := templateRegex.ReplaceAllString(s, "")
+		if replaced == s {
+			break
+		}
+		s = replaced
+	}
+	s = fileRegex.ReplaceAllString(s, "")
+	// external links first, so the urls of internal links aren't mistaken for external links
+	s = externalLinkRegex.ReplaceAllStringFunc(s, func(match string) string {
+		parts := externalLinkRegex.FindStringSubmatch(match)
+		url, text := parts[1], strings.TrimSpace(parts[2])
+		if strings.HasPrefix(url, "//") {
+			url = "http:" + url
+		}
+		if text == "" {
+			return url
+		}
+		if !links || text == url {
+			return text
+		}
+		return fmt.Sprintf("%s [%s]", text, url)
+	})
+	s = internalLinkRegex.ReplaceAllStringFunc(s, func(match string) string {
+		parts := internalLinkRegex.FindStringSubmatch(match)
+		page, text := strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2])
+		if text == "" {
+			text = strings.TrimPrefix(page, "#")
+		}
+		if !links || strings.HasPrefix(page, "#") {
+			return text
+		}
+		// the wiki capitalises the first letter of page names
+		if first, size := utf8.DecodeRuneInString(page); first != utf8.RuneError {
+			page = string(unicode.ToUpper(first)) + page[size:]
+		}
+		return fmt.Sprintf("%s [http://www.wikiexplora.com/%s]", text, strings.ReplaceAll(page, " ", "_"))
+	})
+	s = formattingRegex.ReplaceAllString(s, "")
+	s = tagRegex.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	s = strings.ReplaceAll(s, "\uF0B1", WAYPOINT_SYMBOL)
+	s = strings.ReplaceAll(s, "\uF08F", ROUTE_SYMBOL)
+	return s
+}
@@ -0,0 +1,461 @@
+package routedata
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dave/gpt/globals"
+)
+
+func TestSplitOutsideLinks(t *testing.T) {
+	tests := []struct {
+		s          string
+		separators []string
+		expected   []string
+	}{
+		{"", []string{"|"}, []string{""}},
+		{"a", []string{"|"}, []string{"a"}},
+		{"a|b|c", []string{"|"}, []string{"a", "b", "c"}},
+		{"a||b!!c", []string{"||", "!!"}, []string{"a", "b", "c"}},
+		{"[[Page|text]]|b", []string{"|"}, []string{"[[Page|text]]", "b"}},
+		{"{{convert|1|km}}||b", []string{"||"}, []string{"{{convert|1|km}}", "b"}},
+		{"{{a|{{b|c}}|d}}|e", []string{"|"}, []string{"{{a|{{b|c}}|d}}", "e"}},
+		{"[[File:a.jpg|thumb|[[Page|caption]]]]|b", []string{"|"}, []string{"[[File:a.jpg|thumb|[[Page|caption]]]]", "b"}},
+		{"a]]|b", []string{"|"}, []string{"a]]", "b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			if found := splitOutsideLinks(test.s, test.separators...); !reflect.DeepEqual(found, test.expected) {
+				t.Errorf("expected %q, found %q", test.expected, found)
+			}
+		})
+	}
+}
+
+func TestConvertWikitext(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		links string // expected with links
+		plain string // expected without links
+	}{
+		{"text", "Text.", "Text.", "Text."},
+		{"formatting", "'''Bold''' and ''italic''", "Bold and italic", "Bold and italic"},
+		{"comment", "a<!-- hidden -->b", "ab", "ab"},
+		{"break", "a<br>b<br />c", "a\nb\nc", "a\nb\nc"},
+		{"tags", `<span style="color:red">red</span>`, "red", "red"},
+		{"entities", "a&nbsp;&amp;&nbsp;b", "a & b", "a & b"},
+		{"template", "a{{Template|x}}b", "ab", "ab"},
+		{"nested templates", "a{{Outer|{{Inner|{{Deepest}}}}|x}}b", "ab", "ab"},
+		{"file", "a[[File:a.jpg]]b", "ab", "ab"},
+		{"file with caption", "a[[Archivo:a.jpg|thumb|right|The lake]]b", "ab", "ab"},
+		{"file with linked caption", "a[[Image:a.jpg|thumb|The [[Lago Puelo|lake]] from [http://example.com the pass]]]b", "ab", "ab"},
+		{"category", "a[[Category:GPT]]b", "ab", "ab"},
+		{"internal", "[[Rio Puelo]]", "Rio Puelo [http://www.wikiexplora.com/Rio_Puelo]", "Rio Puelo"},
+		{"internal with text", "the [[Rio Puelo|river]]", "the river [http://www.wikiexplora.com/Rio_Puelo]", "the river"},
+		{"internal lower case", "[[rio Puelo|river]]", "river [http://www.wikiexplora.com/Rio_Puelo]", "river"},
+		{"internal section", "[[GPT01#Route|route]]", "route [http://www.wikiexplora.com/GPT01#Route]", "route"},
+		{"anchor", "[[#Route]] and [[#Route|the route]]", "Route and the route", "Route and the route"},
+		{"external", "[http://example.com/a the page]", "the page [http://example.com/a]", "the page"},
+		{"external https", "[https://example.com/a page]", "page [https://example.com/a]", "page"},
+		{"external without text", "[http://example.com/a]", "http://example.com/a", "http://example.com/a"},
+		{"external with url text", "[http://example.com/a http://example.com/a]", "http://example.com/a", "http://example.com/a"},
+		{"protocol relative", "[//example.com/a page]", "page [http://example.com/a]", "page"},
+		{"protocol relative without text", "[//example.com/a]", "http://example.com/a", "http://example.com/a"},
+		{"bare url", "see http://example.com/a", "see http://example.com/a", "see http://example.com/a"},
+		{"brackets", "[not a link]", "[not a link]", "[not a link]"},
+		{"symbols", "\uF0B1 camp \uF08F route", WAYPOINT_SYMBOL + " camp " + ROUTE_SYMBOL + " route", WAYPOINT_SYMBOL + " camp " + ROUTE_SYMBOL + " route"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if found := convertWikitext(test.s, true); found != test.links {
+				t.Errorf("with links: expected %q, found %q", test.links, found)
+			}
+			if found := convertWikitext(test.s, false); found != test.plain {
+				t.Errorf("without links: expected %q, found %q", test.plain, found)
+			}
+		})
+	}
+}
+
+func TestWikiBlocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{"empty", "", nil},
+		{"paragraph", "a\nb", []string{"a\nb"}},
+		{"paragraphs", "a\n\n\nb\n", []string{"a", "b"}},
+		{"lists", "* a\n** b\n# c\n: d\n; e", []string{"a\nb\nc\nd\ne"}},
+		{"table", "a\n{| class=\"wikitable\"\n| x\n\n| y\n|}\nb", []string{"a", "{| class=\"wikitable\"\n| x\n\n| y\n|}", "b"}},
+		{"nested tables", "{|\n| x\n{|\n| y\n|}\n| z\n|}", []string{"{|\n| x\n{|\n| y\n|}\n| z\n|}"}},
+		{"indented", "  a  \n\t\nb", []string{"a", "b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if found := wikiBlocks(strings.Split(test.text, "\n")); !reflect.DeepEqual(found, test.expected) {
+				t.Errorf("expected %q, found %q", test.expected, found)
+			}
+		})
+	}
+}
+
+func TestWikiTableRows(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected [][]string
+	}{
+		{
+			name:     "one line per row",
+			text:     "{| class=\"wikitable\"\n|+ Caption\n|-\n| Status || Open\n|-\n| Attraction || 4 || 5\n|}",
+			expected: [][]string{{"Status", "Open"}, {"Attraction", "4", "5"}},
+		},
+		{
+			name:     "one cell per line",
+			text:     "{|\n|-\n| Status\n| Open\n|-\n| Attraction\n| 4\n| 5\n|}",
+			expected: [][]string{{"Status", "Open"}, {"Attraction", "4", "5"}},
+		},
+		{
+			name:     "header cells",
+			text:     "{|\n! Status !! Open\n|-\n! Attraction\n| 4\n|}",
+			expected: [][]string{{"Status", "Open"}, {"Attraction", "4"}},
+		},
+		{
+			name:     "attributes",
+			text:     "{|\n|- style=\"background:#eee\"\n| style=\"width:30%\" | Status || align=\"center\" | Open\n|-\n| '''Attraction'''\n| colspan=\"2\" | 4\n|}",
+			expected: [][]string{{"Status", "Open"}, {"'''Attraction'''", "4"}},
+		},
+		{
+			name:     "links and templates",
+			text:     "{|\n| Connects to || [[GPT02|GPT 2]] {{flag|Chile}}\n|}",
+			expected: [][]string{{"Connects to", "[[GPT02|GPT 2]] {{flag|Chile}}"}},
+		},
+		{
+			name:     "continued cell",
+			text:     "{|\n| Comment\n| First line.\nSecond line.\n|}",
+			expected: [][]string{{"Comment", "First line.\nSecond line."}},
+		},
+		{
+			name:     "text around the table",
+			text:     "Before.\n{|\n| Status || Open\n|}\nAfter.",
+			expected: [][]string{{"Status", "Open"}},
+		},
+		{
+			name: "no table",
+			text: "To be issued.",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if found := wikiTableRows(strings.Split(test.text, "\n")); !reflect.DeepEqual(found, test.expected) {
+				t.Errorf("expected %q, found %q", test.expected, found)
+			}
+		})
+	}
+}
+
+func TestParseWikitext(t *testing.T) {
+	tests := []struct {
+		name        string
+		wikitext    string
+		description string
+		subsections []*scrapedSubsection
+	}{
+		{
+			name:        "headings",
+			wikitext:    "Intro.\n= Top =\nText.\n==Route==\nMore [[Rio Puelo|text]].\n=== Sub ===\nDeep.",
+			description: "● Top\n\nText.\n\n★ Route\n\nMore text [http://www.wikiexplora.com/Rio_Puelo].\n\n★ Sub\n\nDeep.\n\n",
+			subsections: []*scrapedSubsection{
+				{parents: []string{}, title: "Top", text: "Text.\n\n"},
+				{parents: []string{"Top"}, title: "Route", text: "More text [http://www.wikiexplora.com/Rio_Puelo].\n\n"},
+				{parents: []string{"Top", "Route"}, title: "Sub", text: "Deep.\n\n"},
+			},
+		},
+		{
+			name:        "unequal headings",
+			wikitext:    "== Route ===\nText.\n=== Option ==\nMore.\n=== Sub ===\nDeep.",
+			description: "★ Route =\n\nText.\n\n★ = Option\n\nMore.\n\n★ Sub\n\nDeep.\n\n",
+			subsections: []*scrapedSubsection{
+				{parents: []string{}, title: "Route =", text: "Text.\n\n"},
+				{parents: []string{}, title: "= Option", text: "More.\n\n"},
+				{parents: []string{"= Option"}, title: "Sub", text: "Deep.\n\n"},
+			},
+		},
+		{
+			name:        "heading with markup",
+			wikitext:    "== '''Route''' <!-- old --> ==\nText.",
+			description: "★ Route\n\nText.\n\n",
+			subsections: []*scrapedSubsection{{parents: []string{}, title: "Route", text: "Text.\n\n"}},
+		},
+		{
+			name:        "heading with only subheadings",
+			wikitext:    "== Route ==\n=== Sub ===\nText.",
+			description: "★ Route\n\n★ Sub\n\nText.\n\n",
+			subsections: []*scrapedSubsection{
+				{parents: []string{}, title: "Route", text: ""},
+				{parents: []string{"Route"}, title: "Sub", text: "Text.\n\n"},
+			},
+		},
+		{
+			name:        "removed",
+			wikitext:    "== Images ==\n[[File:a.jpg|thumb|A caption]]\nPhotos.\n=== Old ===\nMore photos.\n== Route ==\nText.",
+			description: "★ Images\n\n☞ Section removed - see web page.\n\n★ Route\n\nText.\n\n",
+			subsections: []*scrapedSubsection{{parents: []string{}, title: "Route", text: "Text.\n\n"}},
+		},
+		{
+			name:     "removed without content",
+			wikitext: "== Elevation Profile ==\nTo be issued.\n== How to a add new entry ==\nEdit the page.\n== Image Gallery ==\n[[File:a.jpg]]",
+		},
+		{
+			name:        "removed subheading",
+			wikitext:    "== Route ==\nText.\n=== Images ===\nPhotos.\n=== Sub ===\nDeep.",
+			description: "★ Route\n\nText.\n\n★ Images\n\n☞ Section removed - see web page.\n\n★ Sub\n\nDeep.\n\n",
+			subsections: []*scrapedSubsection{
+				{parents: []string{}, title: "Route", text: "Text.\n\n"},
+				{parents: []string{"Route"}, title: "Sub", text: "Deep.\n\n"},
+			},
+		},
+		{
+			name:     "to be issued",
+			wikitext: "== Route ==\nTo be issued.\n== Ferry ==\n''Not applicable.''\n=== Sub ===\n\nTo be issued.\n",
+		},
+		{
+			name:        "to be issued with text",
+			wikitext:    "== Route ==\nTo be issued.\n\nText.\n\nNot applicable.",
+			description: "★ Route\n\nText.\n\n",
+			subsections: []*scrapedSubsection{{parents: []string{}, title: "Route", text: "Text.\n\n"}},
+		},
+		{
+			name:        "table",
+			wikitext:    "== Route ==\nText.\n{| class=\"wikitable\"\n| a || b\n|}\n* One\n* Two",
+			description: "★ Route\n\nText.\n\n☞ Table removed - see web page.\n\nOne\nTwo\n\n",
+			subsections: []*scrapedSubsection{{parents: []string{}, title: "Route", text: "Text.\n\n☞ Table removed - see web page.\n\nOne\nTwo\n\n"}},
+		},
+		{
+			name:        "comment",
+			wikitext:    "== Route ==\n<!--\n== Hidden ==\nHidden text.\n-->\nText.",
+			description: "★ Route\n\nText.\n\n",
+			subsections: []*scrapedSubsection{{parents: []string{}, title: "Route", text: "Text.\n\n"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := parseWikitext(test.wikitext)
+			if p.description != test.description {
+				t.Errorf("expected description %q, found %q", test.description, p.description)
+			}
+			if !reflect.DeepEqual(p.subsections, test.subsections) {
+				t.Errorf("expected subsections %s, found %s", formatSubsections(test.subsections), formatSubsections(p.subsections))
+			}
+			if p.summary != nil || p.hiking != "" || p.packrafting != "" {
+				t.Errorf("unexpected summary %q", p.hiking)
+			}
+		})
+	}
+}
+
+func formatSubsections(subsections []*scrapedSubsection) string {
+	var s []string
+	for _, subsection := range subsections {
+		s = append(s, strings.Join(append(subsection.parents, subsection.title), " / ")+": "+subsection.text)
+	}
+	return strings.Join(s, ", ")
+}
+
+func TestParseWikitextSummary(t *testing.T) {
+	expected := &SectionSummary{
+		Status:      "Open",
+		ConnectsTo:  "GPT 2",
+		Connections: []globals.SectionKey{{Number: 2}},
+		Modes: map[globals.ModeType]*SectionModeSummary{
+			globals.HIKE: {Attraction: "4"},
+			globals.RAFT: {Attraction: "5"},
+		},
+	}
+	tests := []struct {
+		name     string
+		wikitext string
+	}{
+		{
+			name:     "one line per row",
+			wikitext: "== Summary Table ==\n{| class=\"wikitable\"\n|-\n| Status || Open\n|-\n| Attraction || 4 || 5\n|-\n| Connects to || [[GPT02|GPT 2]]\n|-\n| Unknown || x\n|}\n== Route ==\nText.",
+		},
+		{
+			name:     "one cell per line with attributes",
+			wikitext: "== Summary Table ==\n{|\n|- style=\"background:#eee\"\n| style=\"width:30%\" | '''Status'''\n| Open{{ref|1}}\n|-\n| Attraction\n| align=\"center\" | 4\n| align=\"center\" | 5\n|-\n| Connects to\n| [[GPT02|GPT 2]]\n|}\n== Route ==\nText.",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := parseWikitext(test.wikitext)
+			if !reflect.DeepEqual(p.summary, expected) {
+				t.Errorf("expected summary %+v, found %+v", expected, p.summary)
+			}
+			hiking := "★ Summary Table\n\nStatus: Open\nAttraction: 4\nConnects to: GPT 2 [http://www.wikiexplora.com/GPT02]\n\n"
+			if p.hiking != hiking {
+				t.Errorf("expected hiking %q, found %q", hiking, p.hiking)
+			}
+			packrafting := "★ Summary Table\n\nStatus: Open\nAttraction: 5\nConnects to: GPT 2 [http://www.wikiexplora.com/GPT02]\n\n"
+			if p.packrafting != packrafting {
+				t.Errorf("expected packrafting %q, found %q", packrafting, p.packrafting)
+			}
+			if description := "★ Route\n\nText.\n\n"; p.description != description {
+				t.Errorf("expected description %q, found %q", description, p.description)
+			}
+		})
+	}
+}
+
+// testWikitext and testHtml are the same page, as wikitext from the API and as rendered by the wiki.
+const testWikitext = `Intro.
+== Summary Table ==
+{| class="wikitable"
+|-
+| Status || Open
+|-
+| Attraction || 4 || 5
+|-
+| Connects to || [[GPT02]]
+|}
+== Route ==
+To be issued.
+
+Follow the [[Rio Puelo|river]] to the [http://example.com/lake lake] (see [[#Ferry|below]]).
+Camp at the '''beach'''.
+
+* First
+* Second
+{| class="wikitable"
+| a || b
+|}
+=== Ferry ===
+To be issued.
+=== Road ===
+Not applicable.
+== Optional Routes ==
+=== Option 1: Atajo ===
+Along the [//example.com/track track].
+==== Description ====
+Steep.
+== Route Options ===
+Text.
+== Images ==
+Photos.
+`
+
+const testHtml = `<!DOCTYPE html>
+<html><body><div id="mw-content-text">
+<p>Intro.
+</p>
+<h2><span class="mw-headline" id="Summary_Table">Summary Table</span></h2>
+<table class="wikitable">
+<tbody><tr>
+<td>Status</td>
+<td>Open
+</td></tr>
+<tr>
+<td>Attraction</td>
+<td>4</td>
+<td>5
+</td></tr>
+<tr>
+<td>Connects to</td>
+<td><a href="/GPT02" title="GPT02">GPT02</a>
+</td></tr></tbody></table>
+<h2><span class="mw-headline" id="Route">Route</span></h2>
+<p>To be issued.
+</p><p>Follow the <a href="/Rio_Puelo" title="Rio Puelo">river</a> to the <a rel="nofollow" class="external text" href="http://example.com/lake">lake</a> (see <a href="#Ferry">below</a>).
+Camp at the <b>beach</b>.
+</p>
+<ul><li>First</li>
+<li>Second</li></ul>
+<table class="wikitable">
+<tbody><tr>
+<td>a</td>
+<td>b
+</td></tr></tbody></table>
+<h3><span class="mw-headline" id="Ferry">Ferry</span></h3>
+<p>To be issued.
+</p>
+<h3><span class="mw-headline" id="Road">Road</span></h3>
+<p>Not applicable.
+</p>
+<h2><span class="mw-headline" id="Optional_Routes">Optional Routes</span></h2>
+<h3><span class="mw-headline" id="Option_1:_Atajo">Option 1: Atajo</span></h3>
+<p>Along the <a rel="nofollow" class="external text" href="//example.com/track">track</a>.
+</p>
+<h4><span class="mw-headline" id="Description">Description</span></h4>
+<p>Steep.
+</p>
+<h2><span class="mw-headline" id="Route_Options_=">Route Options =</span></h2>
+<p>Text.
+</p>
+<h2><span class="mw-headline" id="Images">Images</span></h2>
+<p>Photos.
+</p>
+</div></body></html>
+`
+
+func TestScrapeSources(t *testing.T) {
+	api, err := json.Marshal(map[string]interface{}{"parse": map[string]string{"title": "GPT99", "wikitext": testWikitext}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, map[string]http.HandlerFunc{
+		"/api.php": func(w http.ResponseWriter, r *http.Request) {
+			w.Write(api)
+		},
+		"/GPT99": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(testHtml))
+		},
+	})
+	target, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := testFetcher(t)
+	f.Client = &http.Client{Transport: redirect{target}}
+	section := &Section{Key: globals.SectionKey{Number: 99}}
+
+	fromApi, err := section.scrapeApi(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromHtml, err := section.scrapeHtml(f, "http://www.wikiexplora.com/GPT99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromApi.description != fromHtml.description {
+		t.Errorf("descriptions differ:\napi:\n%s\nhtml:\n%s", fromApi.description, fromHtml.description)
+	}
+	if fromApi.hiking != fromHtml.hiking || fromApi.packrafting != fromHtml.packrafting {
+		t.Errorf("summaries differ:\napi:\n%s%s\nhtml:\n%s%s", fromApi.hiking, fromApi.packrafting, fromHtml.hiking, fromHtml.packrafting)
+	}
+	if !reflect.DeepEqual(fromApi.summary, fromHtml.summary) {
+		t.Errorf("summaries differ: api %+v, html %+v", fromApi.summary, fromHtml.summary)
+	}
+	if !reflect.DeepEqual(fromApi.subsections, fromHtml.subsections) {
+		t.Errorf("subsections differ:\napi: %s\nhtml: %s", formatSubsections(fromApi.subsections), formatSubsections(fromHtml.subsections))
+	}
+	// check the page has been converted, not just that both sources are equally wrong
+	for _, text := range []string{
+		"Follow the river [http://www.wikiexplora.com/Rio_Puelo] to the lake [http://example.com/lake] (see below).\nCamp at the beach.\n\nFirst\nSecond\n\n☞ Table removed - see web page.\n\n",
+		"Along the track [http://example.com/track].",
+		"★ Images\n\n☞ Section removed - see web page.",
+	} {
+		if !strings.Contains(fromApi.description, text) {
+			t.Errorf("expected description to contain %q, found %q", text, fromApi.description)
+		}
+	}
+	for _, text := range []string{"Intro.", "To be issued.", "Not applicable.", "Ferry", "Road"} {
+		if strings.Contains(fromApi.description, text) {
+			t.Errorf("expected description not to contain %q, found %q", text, fromApi.description)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package routedata
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dave/gpt/globals"
+)
+
+var (
+	optionHeadingRegex  = regexp.MustCompile(`(?i:option|opci[oó]n)\s*#?\s*0*(\d+)([A-Z]{0,2})\b`)
+	variantHeadingRegex = regexp.MustCompile(`(?i:variant|variante)\s+([A-Z]{1,2})\b`)
+	codeHeadingRegex    = regexp.MustCompile(`^0*(\d+)(?:([A-Z]{1,2})\b|\s*[:.)\-–])`)
+)
+
+// AttachOptionDescriptions adds the scraped wiki text of each option to the optional routes. It must be called after
+// the options are named, because headings may be matched by option name.
+func (d *Data) AttachOptionDescriptions() {
+	for _, key := range d.Keys {
+		if globals.HAS_SINGLE && key != globals.SINGLE {
+			continue
+		}
+		d.Sections[key].attachOptionDescriptions()
+	}
+}
+
+// attachOptionDescriptions adds the text of the wiki subsections under an options heading (e.g. "Optional Routes")
+// to the matching optional routes. Deeper subsections (e.g. "Description" under "Option 1") are added to the option
+// that their enclosing heading matched.
+func (s *Section) attachOptionDescriptions() {
+	warned := map[string]bool{}
+	for _, sub := range s.subsections {
+		headings := append(append([]string{}, sub.parents...), sub.title)
+		index := -1
+		for i, heading := range headings[:len(headings)-1] {
+			if isOptionsHeading(heading) {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			continue
+		}
+		title := headings[index+1]
+		routes := s.optionRoutes(title)
+		if len(routes) == 0 {
+			if !warned[title] {
+				logf("GPT%s: no option matches the wiki heading %q\n", s.Key.Code(), title)
+				warned[title] = true
+			}
+			continue
+		}
+		symbol := H1_SYMBOL
+		if sub.title != title {
+			symbol = H2_SYMBOL
+		}
+		for _, route := range routes {
+			route.Scraped += fmt.Sprintf("\n%s %s\n\n%s", symbol, sub.title, sub.text)
+		}
+	}
+}
+
+// isOptionsHeading is true for a heading that encloses the option descriptions.
+func isOptionsHeading(title string) bool {
+	title = strings.ToLower(title)
+	for _, word := range []string{"option", "variant", "opcion", "opción"} {
+		if strings.Contains(title, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// optionRoutes finds the optional routes for a wiki heading. The heading is matched by option number and variant
+// (e.g. "Option 1A: Atajo", "Variant B", "01A Atajo" or "2: Atajo", but not "2 days"), or failing that, by the route or
+// option name.
+func (s *Section) optionRoutes(title string) []*Route {
+	option, variant, found := -1, "", false
+	if matches := optionHeadingRegex.FindStringSubmatch(title); matches != nil {
+		option, _ = strconv.Atoi(matches[1])
+		variant, found = matches[2], true
+	} else if matches := variantHeadingRegex.FindStringSubmatch(title); matches != nil {
+		option, variant, found = 0, matches[1], true
+	} else if matches := codeHeadingRegex.FindStringSubmatch(title); matches != nil {
+		option, _ = strconv.Atoi(matches[1])
+		variant, found = matches[2], true
+	}
+
+	var byKey, byName, byOption []*Route
+	lower := strings.ToLower(title)
+	for _, routeKey := range s.RouteKeys {
+		if routeKey.Required != globals.OPTIONAL || routeKey.Alternatives {
+			continue
+		}
+		route := s.Routes[routeKey]
+		switch {
+		case found && routeKey.Option == option && routeKey.Variant == variant:
+			byKey = append(byKey, route)
+		case route.Name != "" && strings.Contains(lower, strings.ToLower(route.Name)):
+			byName = append(byName, route)
+		case route.Option != "" && routeKey.Variant == "" && strings.Contains(lower, strings.ToLower(route.Option)):
+			byOption = append(byOption, route)
+		}
+	}
+	switch {
+	case len(byKey) > 0:
+		return byKey
+	case len(byName) > 0:
+		return byName
+	}
+	return byOption
+}
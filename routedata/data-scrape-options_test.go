@@ -0,0 +1,67 @@
+package routedata
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dave/gpt/globals"
+)
+
+func TestAttachOptionDescriptions(t *testing.T) {
+	key := globals.SectionKey{Number: 1}
+	section := &Section{Key: key, Routes: map[RouteKey]*Route{}}
+	for _, route := range []*Route{
+		{Key: RouteKey{Required: globals.REGULAR}},
+		{Key: RouteKey{Required: globals.OPTIONAL, Option: 1}, Name: "Atajo", Option: "Atajo"},
+		{Key: RouteKey{Required: globals.OPTIONAL, Option: 1, Variant: "A"}, Name: "Paso Alto", Option: "Atajo"},
+		{Key: RouteKey{Required: globals.OPTIONAL, Option: 2}, Name: "Lago Azul", Option: "Lago Azul"},
+		{Key: RouteKey{Required: globals.OPTIONAL, Option: 3}, Name: "Rio Norte", Option: "Valle Norte"},
+	} {
+		route.Section = section
+		section.RouteKeys = append(section.RouteKeys, route.Key)
+		section.Routes[route.Key] = route
+	}
+	options := []string{"Optional Routes"}
+	section.subsections = []*scrapedSubsection{
+		{parents: options, title: "Option 1: Atajo", text: "by number"},
+		{parents: []string{"Optional Routes", "Option 1: Atajo"}, title: "Description", text: "under option 1"},
+		{parents: []string{"Optional Routes", "Option 1: Atajo", "Description"}, title: "Water", text: "deeper under option 1"},
+		{parents: options, title: "Variant A", text: "variant without option number"},
+		{parents: []string{"Opciones"}, title: "02: Lago Azul", text: "by code"},
+		{parents: options, title: "Paso Alto", text: "by route name"},
+		{parents: options, title: "Around the Valle Norte", text: "by option name"},
+		{parents: options, title: "Unknown trail", text: "no match"},
+		{parents: []string{"Optional Routes", "Unknown trail"}, title: "Description", text: "under no match"},
+		{parents: options, title: "2 days", text: "not a code"},
+		{parents: []string{"Resupply"}, title: "Option 2", text: "not under an options heading"},
+	}
+	d := &Data{Keys: []globals.SectionKey{key}, Sections: map[globals.SectionKey]*Section{key: section}}
+	d.AttachOptionDescriptions()
+
+	expected := map[RouteKey][]string{
+		{Required: globals.REGULAR}:                           nil,
+		{Required: globals.OPTIONAL, Option: 1}:               {"by number", "under option 1", "deeper under option 1"},
+		{Required: globals.OPTIONAL, Option: 1, Variant: "A"}: {"by route name"},
+		{Required: globals.OPTIONAL, Option: 2}:               {"by code"},
+		{Required: globals.OPTIONAL, Option: 3}:               {"by option name"},
+	}
+	if scraped := section.Routes[RouteKey{Required: globals.OPTIONAL, Option: 1}].Scraped; !strings.Contains(scraped, H2_SYMBOL+" Description") {
+		t.Errorf("expected the sub-heading in %q", scraped)
+	}
+	for routeKey, texts := range expected {
+		scraped := section.Routes[routeKey].Scraped
+		for _, text := range texts {
+			if !strings.Contains(scraped, text) {
+				t.Errorf("%s: expected %q in %q", routeKey.Debug(), text, scraped)
+			}
+		}
+		for _, text := range []string{"variant without option number", "no match", "not a code", "not under an options heading"} {
+			if strings.Contains(scraped, text) {
+				t.Errorf("%s: unexpected %q in %q", routeKey.Debug(), text, scraped)
+			}
+		}
+		if len(texts) == 0 && scraped != "" {
+			t.Errorf("%s: expected no description, found %q", routeKey.Debug(), scraped)
+		}
+	}
+}
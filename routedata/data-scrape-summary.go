@@ -0,0 +1,232 @@
+package routedata
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dave/gpt/globals"
+)
+
+// SectionSummary is the Summary Table from the wikiexplora page of a section.
+type SectionSummary struct {
+	Group       string
+	Region      string
+	Start       string
+	Finish      string
+	Status      string
+	Traversable string       // e.g. "December - March"
+	Months      []time.Month // traversable months parsed from Traversable, empty if it couldn't be parsed
+	Packraft    string       // packraft requirement e.g. "Optional"
+	ConnectsTo  string
+	Connections []globals.SectionKey // sections parsed from ConnectsTo
+	Options     string
+	Comment     string
+	Character   string
+	Challenges  string
+	Modes       map[globals.ModeType]*SectionModeSummary
+}
+
+// SectionModeSummary is the part of the Summary Table that has a column for each mode.
+type SectionModeSummary struct {
+	Attraction string
+	Difficulty string
+	Direction  string
+}
+
+// set stores a value from a row of the table. For rows with a column per mode, hiking and packrafting are the two
+// columns, otherwise only hiking is used.
+func (s *SectionSummary) set(title, hiking, packrafting string) {
+	switch title {
+	case "Group":
+		s.Group = hiking
+	case "Region":
+		s.Region = hiking
+	case "Start":
+		s.Start = hiking
+	case "Finish":
+		s.Finish = hiking
+	case "Status":
+		s.Status = hiking
+	case "Traversable":
+		s.Traversable = hiking
+		s.Months = parseMonths(hiking)
+	case "Packraft":
+		s.Packraft = hiking
+	case "Connects to":
+		s.ConnectsTo = hiking
+		s.Connections = parseConnections(hiking)
+	case "Options":
+		s.Options = hiking
+	case "Comment":
+		s.Comment = hiking
+	case "Character":
+		s.Character = hiking
+	case "Challenges":
+		s.Challenges = hiking
+	case "Attraction", "Difficulty", "Direction":
+		for mode, value := range map[globals.ModeType]string{globals.HIKE: hiking, globals.RAFT: packrafting} {
+			if s.Modes[mode] == nil {
+				s.Modes[mode] = &SectionModeSummary{}
+			}
+			switch title {
+			case "Attraction":
+				s.Modes[mode].Attraction = value
+			case "Difficulty":
+				s.Modes[mode].Difficulty = value
+			case "Direction":
+				s.Modes[mode].Direction = value
+			}
+		}
+	}
+}
+
+var connectionRegex = regexp.MustCompile(`GPT ?(\d+[HP]?)`)
+
+// parseConnections finds the section codes e.g. "GPT27" in the Connects to text.
+func parseConnections(text string) []globals.SectionKey {
+	var keys []globals.SectionKey
+	found := map[globals.SectionKey]bool{}
+	for _, match := range connectionRegex.FindAllStringSubmatch(text, -1) {
+		key, err := NewSectionKey(match[1])
+		if err != nil || found[key] {
+			continue
+		}
+		found[key] = true
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+var monthRegex = regexp.MustCompile(`\b(january|february|march|april|may|june|july|august|september|october|november|december|enero|febrero|marzo|abril|mayo|junio|julio|agosto|septiembre|octubre|noviembre|diciembre|sept|jan|feb|mar|apr|jun|jul|aug|sep|oct|nov|dec|ene|abr|ago|dic)\b`)
+
+// monthPrefixes is the month for the first three letters of the English and Spanish month names.
+var monthPrefixes = map[string]time.Month{
+	"jan": time.January, "ene": time.January,
+	"feb": time.February,
+	"mar": time.March,
+	"apr": time.April, "abr": time.April,
+	"may": time.May,
+	"jun": time.June,
+	"jul": time.July,
+	"aug": time.August, "ago": time.August,
+	"sep": time.September,
+	"oct": time.October,
+	"nov": time.November,
+	"dec": time.December, "dic": time.December,
+}
+
+// ambiguousMonths are month names that are also common words ("may be closed", "mar" is Spanish for sea). They're only
+// months if they're the end of a range, or joined to the next month, or listed after another month at the end of the
+// text, or are the whole text.
+var ambiguousMonths = map[string]bool{"may": true, "mar": true}
+
+// rangeSeparators join the first and last months of a range, and listSeparators join months in a list.
+var rangeSeparators = map[string]bool{"-": true, "–": true, "to": true, "a": true}
+var listSeparators = map[string]bool{",": true, "and": true, "y": true, "/": true, "&": true, ";": true}
+
+// parseMonths parses the traversable months e.g. "All year", "December - March" (a range, which may span the new
+// year) or "January, February" (a list). Pairs of months joined by "-" or "to" are ranges, other months are listed
+// individually. Words like "may" are ignored unless they're clearly a month (see ambiguousMonths).
+func parseMonths(text string) []time.Month {
+	lower := strings.ToLower(text)
+	if strings.Contains(lower, "all year") || strings.Contains(lower, "year round") || strings.Contains(lower, "year-round") {
+		var months []time.Month
+		for m := time.January; m <= time.December; m++ {
+			months = append(months, m)
+		}
+		return months
+	}
+	all := monthRegex.FindAllStringSubmatchIndex(lower, -1)
+	// between is the text between two matches
+	between := func(m1, m2 []int) string {
+		return strings.TrimSpace(lower[m1[1]:m2[0]])
+	}
+	joined := func(m1, m2 []int) bool {
+		return rangeSeparators[between(m1, m2)] || listSeparators[between(m1, m2)]
+	}
+	var matches [][]int
+	for i, match := range all {
+		word := lower[match[2]:match[3]]
+		if ambiguousMonths[word] && strings.TrimSpace(lower) != word {
+			ends := i > 0 && rangeSeparators[between(all[i-1], match)]
+			next := i < len(all)-1 && joined(match, all[i+1])
+			last := i > 0 && i == len(all)-1 && joined(all[i-1], match) && strings.Trim(lower[match[1]:], " .;)") == ""
+			if !ends && !next && !last {
+				continue
+			}
+		}
+		matches = append(matches, match)
+	}
+	included := map[time.Month]bool{}
+	for i := 0; i < len(matches); i++ {
+		from := monthPrefixes[lower[matches[i][2]:matches[i][2]+3]]
+		included[from] = true
+		if i == len(matches)-1 {
+			continue
+		}
+		if !rangeSeparators[between(matches[i], matches[i+1])] {
+			continue
+		}
+		to := monthPrefixes[lower[matches[i+1][2]:matches[i+1][2]+3]]
+		for m := from; m != to; m = m%12 + 1 {
+			included[m] = true
+		}
+	}
+	var months []time.Month
+	for m := time.January; m <= time.December; m++ {
+		if included[m] {
+			months = append(months, m)
+		}
+	}
+	return months
+}
+
+// CheckConnections logs a warning where the wiki says a section connects to another section, but no route of either
+// section starts or ends within globals.DELTA of a route of the other.
+func (d *Data) CheckConnections() {
+	if d.locators == nil {
+		d.buildLocators()
+	}
+	// meets is true if any route of a starts or ends near a route of b
+	meets := func(a, b *Section) bool {
+		filter := func(value interface{}) bool {
+			return value.(*locatorItem).route.Section == b
+		}
+		for _, routeKey := range a.RouteKeys {
+			route := a.Routes[routeKey]
+			for _, mode := range globals.MODES {
+				if route.Modes[mode] == nil || len(route.Modes[mode].Segments) == 0 {
+					continue
+				}
+				segments := route.Modes[mode].Segments
+				if _, found := d.locators[mode].Nearest(segments[0].Line.Start(), globals.DELTA, filter); found {
+					return true
+				}
+				if _, found := d.locators[mode].Nearest(segments[len(segments)-1].Line.End(), globals.DELTA, filter); found {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for _, key := range d.Keys {
+		if globals.HAS_SINGLE && key != globals.SINGLE {
+			continue
+		}
+		section := d.Sections[key]
+		if section.Summary == nil {
+			continue
+		}
+		for _, connection := range section.Summary.Connections {
+			other, ok := d.Sections[connection]
+			if !ok || other == section {
+				continue
+			}
+			if meets(section, other) || meets(other, section) {
+				continue
+			}
+			logf("%s GPT%s: the wiki says it connects to GPT%s but the tracks don't meet\n", WARNING_SYMBOL, key.Code(), connection.Code())
+		}
+	}
+}
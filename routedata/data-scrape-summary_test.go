@@ -0,0 +1,64 @@
+package routedata
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dave/gpt/globals"
+)
+
+func TestParseMonths(t *testing.T) {
+	all := []time.Month{time.January, time.February, time.March, time.April, time.May, time.June, time.July, time.August, time.September, time.October, time.November, time.December}
+	tests := []struct {
+		text     string
+		expected []time.Month
+	}{
+		{"", nil},
+		{"Unknown", nil},
+		{"All year", all},
+		{"Year-round", all},
+		{"December - March", []time.Month{time.January, time.February, time.March, time.December}},
+		{"November to April", []time.Month{time.January, time.February, time.March, time.April, time.November, time.December}},
+		{"Noviembre a Abril", []time.Month{time.January, time.February, time.March, time.April, time.November, time.December}},
+		{"Jan–Mar", []time.Month{time.January, time.February, time.March}},
+		{"January, February", []time.Month{time.January, time.February}},
+		{"March, May and June", []time.Month{time.March, time.May, time.June}},
+		{"May - June", []time.Month{time.May, time.June}},
+		{"Mar - May", []time.Month{time.March, time.April, time.May}},
+		{"May", []time.Month{time.May}},
+		{"Dec - Mar (approx.)", []time.Month{time.January, time.February, time.March, time.December}},
+		{"January, March.", []time.Month{time.January, time.March}},
+		{"December to March, may be closed after heavy snow", []time.Month{time.January, time.February, time.March, time.December}},
+		{"May be closed in winter. December to February", []time.Month{time.January, time.February, time.December}},
+		{"Mid-November to April (crossing the mar may need a boat)", []time.Month{time.January, time.February, time.March, time.April, time.November, time.December}},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			if found := parseMonths(test.text); !reflect.DeepEqual(found, test.expected) {
+				t.Errorf("expected %v, found %v", test.expected, found)
+			}
+		})
+	}
+}
+
+func TestParseConnections(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected []globals.SectionKey
+	}{
+		{"", nil},
+		{"None", nil},
+		{"GPT27", []globals.SectionKey{{Number: 27}}},
+		{"GPT27 and GPT 28H", []globals.SectionKey{{Number: 27}, {Number: 28, Suffix: "H"}}},
+		{"GPT24P / GPT25, GPT24P", []globals.SectionKey{{Number: 24, Suffix: "P"}, {Number: 25}}},
+		{"GPT01 (north) and GPT03", []globals.SectionKey{{Number: 1}, {Number: 3}}},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			if found := parseConnections(test.text); !reflect.DeepEqual(found, test.expected) {
+				t.Errorf("expected %v, found %v", test.expected, found)
+			}
+		})
+	}
+}
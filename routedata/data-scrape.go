@@ -2,12 +2,8 @@ package routedata
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
@@ -21,69 +17,139 @@ const H2_SYMBOL = "★" //"◉" //"✪" //"●" //"★" //"⦿"
 const WAYPOINT_SYMBOL = "☉"
 const ROUTE_SYMBOL = "⬲" //"⛢"
 
-func (d *Data) Scrape(cachedir string) error {
+func (d *Data) Scrape(fetcher *Fetcher, source string) error {
 	logln("web scraping")
 	for _, key := range d.Keys {
 		if globals.HAS_SINGLE && key != globals.SINGLE {
 			continue
 		}
 		section := d.Sections[key]
-		if err := section.Scrape(cachedir); err != nil {
+		if err := section.Scrape(fetcher, source); err != nil {
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) {
+				// e.g. a section without a wiki page yet
+				logf("%s GPT%s: %v - skipping description\n", WARNING_SYMBOL, section.Key.Code(), err)
+				continue
+			}
 			return fmt.Errorf("scraping GPT%s: %w", section.Key.Code(), err)
 		}
 	}
 	return nil
 }
 
-func (s *Section) Scrape(cachedir string) error {
-	var description string
-	var summaryPackrafting, summaryHiking string
-	write := func(s string) {
-		description += s
-	}
-	writeS := func(s string) {
-		summaryPackrafting += s
-		summaryHiking += s
+// Scrape gets the description of the section from wikiexplora. The source is "api" (the MediaWiki API, falling back
+// to the web page if it fails) or "html" (the web page).
+func (s *Section) Scrape(fetcher *Fetcher, source string) error {
+	url := fmt.Sprintf("http://www.wikiexplora.com/GPT%s", s.Key.Code())
+	var page *scrapedPage
+	var err error
+	switch source {
+	case "api":
+		page, err = s.scrapeApi(fetcher)
+		if err != nil {
+			logf("GPT%s: %v - falling back to web page\n", s.Key.Code(), err)
+			page, err = s.scrapeHtml(fetcher, url)
+		}
+	case "html":
+		page, err = s.scrapeHtml(fetcher, url)
+	default:
+		return fmt.Errorf("unknown source %q", source)
 	}
-	writeSP := func(s string) {
-		summaryPackrafting += s
+	if err != nil {
+		return err
 	}
-	writeSH := func(s string) {
-		summaryHiking += s
+	s.Summary = page.summary
+	s.subsections = page.subsections
+
+	s.Scraped[globals.HIKE] += fmt.Sprintf("\n%s Full information\n\nThe following information may be incomplete and out of date. Be sure to check the up to date source:\n\n%s\n\n", H1_SYMBOL, url)
+	s.Scraped[globals.HIKE] += page.hiking
+	s.Scraped[globals.HIKE] += page.description
+
+	s.Scraped[globals.RAFT] += fmt.Sprintf("\n%s Full information\n\nThe following information may be incomplete and out of date. Be sure to check the up to date source:\n\n%s\n\n", H1_SYMBOL, url)
+	s.Scraped[globals.RAFT] += page.packrafting
+	s.Scraped[globals.RAFT] += page.description
+
+	return nil
+}
+
+// scrapedPage is the description of a section, from either source.
+type scrapedPage struct {
+	description         string
+	hiking, packrafting string // the summary table for each mode
+	summary             *SectionSummary
+	subsections         []*scrapedSubsection
+}
+
+// scrapedSubsection is a heading and its text (not including subheadings).
+type scrapedSubsection struct {
+	parents []string // titles of the enclosing headings, outermost first
+	title   string
+	text    string
+}
+
+// headingStack is the enclosing headings of the current heading.
+type headingStack struct {
+	levels []int
+	titles []string
+}
+
+// push adds a heading, and returns the titles of the enclosing headings, outermost first.
+func (h *headingStack) push(level int, title string) []string {
+	for len(h.levels) > 0 && h.levels[len(h.levels)-1] >= level {
+		h.levels = h.levels[:len(h.levels)-1]
+		h.titles = h.titles[:len(h.titles)-1]
 	}
-	var reader io.Reader
-	url := fmt.Sprintf("http://www.wikiexplora.com/GPT%s", s.Key.Code())
-	cachefpath := filepath.Join(cachedir, fmt.Sprintf("GPT%s.html", s.Key.Code()))
-	f, err := os.Open(cachefpath)
-	if err == nil {
-		reader = f
-		defer f.Close()
-	} else {
-		if !os.IsNotExist(err) {
-			return fmt.Errorf("opening file: %w", err)
-		} else {
-			logf("Scraping %q for description\n", url)
-			// file not found
-			resp, err := http.Get(url)
-			if err != nil {
-				return fmt.Errorf("getting %q: %w", url, err)
-			}
-			b, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				return fmt.Errorf("reading %q: %w", url, err)
-			}
-			if err := ioutil.WriteFile(cachefpath, b, 0666); err != nil {
-				return fmt.Errorf("writing %s: %w", cachefpath, err)
-			}
-			reader = bytes.NewBuffer(b)
+	parents := append([]string{}, h.titles...)
+	h.levels = append(h.levels, level)
+	h.titles = append(h.titles, title)
+	return parents
+}
+
+// summaryRow adds a row of the summary table. The values have links as text, the plain values don't.
+func (p *scrapedPage) summaryRow(title string, values, plain []string) {
+	at := func(values []string, i int) string {
+		if i < len(values) {
+			return values[i]
 		}
+		return ""
+	}
+	if p.summary == nil {
+		p.summary = &SectionSummary{Modes: map[globals.ModeType]*SectionModeSummary{}}
+	}
+	switch title {
+	case "Group", "Region", "Start", "Finish", "Status", "Traversable", "Packraft", "Connects to", "Options", "Comment", "Character", "Challenges":
+		// one column
+		p.hiking += title + ": " + at(values, 0) + "\n"
+		p.packrafting += title + ": " + at(values, 0) + "\n"
+		p.summary.set(title, at(plain, 0), "")
+	case "Attraction", "Difficulty", "Direction":
+		// two columns
+		p.hiking += title + ": " + at(values, 0) + "\n"
+		p.packrafting += title + ": " + at(values, 1) + "\n"
+		p.summary.set(title, at(plain, 0), at(plain, 1))
+	}
+}
+
+func (s *Section) scrapeHtml(fetcher *Fetcher, url string) (*scrapedPage, error) {
+	p := &scrapedPage{}
+	write := func(s string) {
+		p.description += s
+	}
+	writeS := func(s string) {
+		p.packrafting += s
+		p.hiking += s
+	}
+	b, err := fetcher.Get(url, fmt.Sprintf("GPT%s.html", s.Key.Code()))
+	if err != nil {
+		return nil, err
 	}
 
-	dom, err := goquery.NewDocumentFromReader(reader)
+	dom, err := goquery.NewDocumentFromReader(bytes.NewReader(b))
 	if err != nil {
-		return fmt.Errorf("reading %s: %w", url, err)
+		return nil, fmt.Errorf("reading %s: %w", url, err)
 	}
 	ignored := map[*html.Node]bool{}
+	parents := &headingStack{}
 	dom.Find(".mw-headline").Each(func(i int, selection *goquery.Selection) {
 
 		c := selection
@@ -101,6 +167,7 @@ func (s *Section) Scrape(cachedir string) error {
 		if level(selection.Parent().Nodes[0].Data) == 1 {
 			headingSymbol = H1_SYMBOL
 		}
+		ancestors := parents.push(level(selection.Parent().Nodes[0].Data), title)
 		switch title {
 		case "How to a add new entry", "Elevation Profile", "Satellite Image Map", "Summary Table", "Alerts and Logs of Past Seasons", "Older information for review", "Image Gallery", "Images":
 			var ignoredCount int
@@ -137,18 +204,12 @@ func (s *Section) Scrape(cachedir string) error {
 				trs := summary.Find("tr")
 				trs.Each(func(i int, tr *goquery.Selection) {
 					tds := tr.Find("td")
-					firstColumn := strings.TrimSpace(tds.First().Text())
-					switch firstColumn {
-					case "Group", "Region", "Start", "Finish", "Status", "Traversable", "Packraft", "Connects to", "Options", "Comment", "Character", "Challenges":
-						// one column
-						writeS(firstColumn + ": " + strings.TrimSpace(getText(tds.First().Next())))
-						writeS("\n")
-					case "Attraction", "Difficulty", "Direction":
-						// two columns
-						writeSH(firstColumn + ": " + strings.TrimSpace(getText(tds.First().Next())))
-						writeSP(firstColumn + ": " + strings.TrimSpace(getText(tds.First().Next().Next())))
-						writeS("\n")
+					var values, plain []string
+					for td := tds.First().Next(); len(td.Nodes) > 0; td = td.Next() {
+						values = append(values, strings.TrimSpace(getText(td)))
+						plain = append(plain, strings.TrimSpace(td.Text()))
 					}
+					p.summaryRow(strings.TrimSpace(tds.First().Text()), values, plain)
 				})
 				writeS("\n")
 
@@ -203,25 +264,20 @@ func (s *Section) Scrape(cachedir string) error {
 			return
 		} else if sectionHasContent { // if len(section) > 0 {
 			write(headingSymbol + " " + strings.TrimSpace(getText(selection)) + "\n\n")
+			var text string
 			for _, part := range section {
 				if part.Nodes[0].Data == "table" {
-					write(WARNING_SYMBOL + " Table removed - see web page.\n\n")
+					text += WARNING_SYMBOL + " Table removed - see web page.\n\n"
 				} else if str := strings.TrimSpace(getText(part)); len(str) > 0 {
-					write(str + "\n\n")
+					text += str + "\n\n"
 				}
 			}
+			write(text)
+			p.subsections = append(p.subsections, &scrapedSubsection{parents: ancestors, title: title, text: text})
 		}
 	})
 
-	s.Scraped[globals.HIKE] += fmt.Sprintf("\n%s Full information\n\nThe following information may be incomplete and out of date. Be sure to check the up to date source:\n\n%s\n\n", H1_SYMBOL, url)
-	s.Scraped[globals.HIKE] += summaryHiking
-	s.Scraped[globals.HIKE] += description
-
-	s.Scraped[globals.RAFT] += fmt.Sprintf("\n%s Full information\n\nThe following information may be incomplete and out of date. Be sure to check the up to date source:\n\n%s\n\n", H1_SYMBOL, url)
-	s.Scraped[globals.RAFT] += summaryPackrafting
-	s.Scraped[globals.RAFT] += description
-
-	return nil
+	return p, nil
 }
 
 func getTextRaw(n *html.Node) string {
@@ -272,7 +328,10 @@ func getText(s *goquery.Selection) string {
 					break
 				}
 			}
-			if strings.HasPrefix(href, "/") {
+			if strings.HasPrefix(href, "//") {
+				// protocol relative
+				href = "http:" + href
+			} else if strings.HasPrefix(href, "/") {
 				href = "http://www.wikiexplora.com" + href
 			}
 			if href != "" && !strings.HasPrefix(href, "#") && href != text {
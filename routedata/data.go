@@ -14,6 +14,9 @@ type Data struct {
 	Resupplies []Waypoint
 	Geographic []Waypoint
 	Important  []Waypoint
+
+	locators map[globals.ModeType]*geo.Index // spatial index of segments for each mode, built by Locate
+	renames  *LegacyRenameHolder             // legacy rename log from the last SaveMaster
 }
 
 func Initialise() {
@@ -131,11 +134,37 @@ func (d *Data) Normalise() error {
 				if err := route.Modes[mode].Network.Normalise(); err != nil {
 					return fmt.Errorf("normalising network: %w", err)
 				}
+				for _, segment := range route.Modes[mode].Network.Violations {
+					logf("GPT%s %s (%s): one-way segment %q is travelled against its direction\n", section.Key.Code(), route.Key.Debug(), modeName(mode), segment.Raw)
+				}
 			}
 
 		}
 	}
 
+	logln("finding option junctions")
+	for _, sectionKey := range d.Keys {
+		section := d.Sections[sectionKey]
+		var regulars []*Route
+		for _, routeKey := range section.RouteKeys {
+			if routeKey.Required == globals.REGULAR {
+				regulars = append(regulars, section.Routes[routeKey])
+			}
+		}
+		for _, routeKey := range section.RouteKeys {
+			if routeKey.Required != globals.OPTIONAL {
+				continue
+			}
+			route := section.Routes[routeKey]
+			for _, mode := range globals.MODES {
+				if route.Modes[mode] == nil {
+					continue
+				}
+				route.Modes[mode].Network.BuildJunction(regulars)
+			}
+		}
+	}
+
 	//ioutil.WriteFile("./debug.txt", []byte(debugString), 0666)
 
 	return nil
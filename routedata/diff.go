@@ -0,0 +1,537 @@
+package routedata
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/globals"
+	"github.com/dave/gpt/kml"
+)
+
+// DIFF_MATCH is the maximum Hausdorff distance in km between an old and new segment that are matched by geometry.
+const DIFF_MATCH = 0.1
+
+// DIFF_MOVED is the minimum distance in km that a waypoint or segment must move to be reported.
+const DIFF_MOVED = 0.005
+
+// Changes is the difference between two versions of the master file.
+type Changes struct {
+	Sections  []*SectionChanges
+	Waypoints []*WaypointChange // resupply, important and geographic waypoints
+}
+
+// SectionChanges is the difference between two versions of a section. Old or New is nil if the section was added or
+// removed.
+type SectionChanges struct {
+	Key       globals.SectionKey
+	Old, New  *Section
+	Routes    []*RouteChange
+	Segments  []*SegmentChange
+	Waypoints []*WaypointChange
+}
+
+func (s *SectionChanges) empty() bool {
+	return s.Old != nil && s.New != nil && s.Old.Name == s.New.Name && len(s.Routes) == 0 && len(s.Segments) == 0 && len(s.Waypoints) == 0
+}
+
+// RouteChange is an added, removed or renamed route.
+type RouteChange struct {
+	Old, New *Route
+}
+
+// SegmentChange is an added, removed or changed segment.
+type SegmentChange struct {
+	Old, New    *Segment
+	Hausdorff   float64  // km
+	LengthDelta float64  // km
+	Reversed    bool     // the line is drawn in the opposite direction
+	Codes       []string // descriptions of changes to the codes in the name
+}
+
+// WaypointChange is an added, removed, moved or renamed waypoint.
+type WaypointChange struct {
+	Group    string // e.g. "waypoint" or "resupply location"
+	Old, New *Waypoint
+	Moved    float64 // km
+}
+
+// Diff finds the changes between two versions of the master file. Sections and routes are matched by key. Segments are
+// matched by name (ignoring the chainage), then by legacy name, then by geometry. Waypoints are matched by name, then
+// by legacy name, then by position.
+func Diff(old, updated *Data) *Changes {
+	c := &Changes{}
+	keys := append([]globals.SectionKey{}, updated.Keys...)
+	for _, key := range old.Keys {
+		if updated.Sections[key] == nil {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		if globals.HAS_SINGLE && key != globals.SINGLE {
+			continue
+		}
+		s := &SectionChanges{Key: key, Old: old.Sections[key], New: updated.Sections[key]}
+		s.Routes = diffRoutes(s.Old, s.New)
+		s.Segments = diffSegments(sectionSegments(s.Old), sectionSegments(s.New))
+		var oldWaypoints, newWaypoints []Waypoint
+		if s.Old != nil {
+			oldWaypoints = s.Old.Waypoints
+		}
+		if s.New != nil {
+			newWaypoints = s.New.Waypoints
+		}
+		s.Waypoints = diffWaypoints("waypoint", oldWaypoints, newWaypoints)
+		if !s.empty() {
+			c.Sections = append(c.Sections, s)
+		}
+	}
+	c.Waypoints = append(c.Waypoints, diffWaypoints("resupply location", old.Resupplies, updated.Resupplies)...)
+	c.Waypoints = append(c.Waypoints, diffWaypoints("important information", old.Important, updated.Important)...)
+	c.Waypoints = append(c.Waypoints, diffWaypoints("geographic designation", old.Geographic, updated.Geographic)...)
+	return c
+}
+
+func diffRoutes(old, updated *Section) []*RouteChange {
+	var changes []*RouteChange
+	routes := func(s *Section) ([]RouteKey, map[RouteKey]*Route) {
+		if s == nil {
+			return nil, nil
+		}
+		var keys []RouteKey
+		for _, key := range s.RouteKeys {
+			// hiking alternatives routes are derived from the regular route
+			if !key.Alternatives {
+				keys = append(keys, key)
+			}
+		}
+		return keys, s.Routes
+	}
+	oldKeys, oldRoutes := routes(old)
+	updatedKeys, updatedRoutes := routes(updated)
+	for _, key := range updatedKeys {
+		o, n := oldRoutes[key], updatedRoutes[key]
+		if o == nil || o.Name != n.Name || o.Option != n.Option {
+			changes = append(changes, &RouteChange{Old: o, New: n})
+		}
+	}
+	for _, key := range oldKeys {
+		if updatedRoutes[key] == nil {
+			changes = append(changes, &RouteChange{Old: oldRoutes[key]})
+		}
+	}
+	return changes
+}
+
+// sectionSegments is the unique segments in all the routes of a section, in master file order.
+func sectionSegments(s *Section) []*Segment {
+	if s == nil {
+		return nil
+	}
+	var segments []*Segment
+	done := map[*Segment]bool{}
+	for _, key := range s.RouteKeys {
+		for _, segment := range s.Routes[key].All {
+			if !done[segment] {
+				done[segment] = true
+				segments = append(segments, segment)
+			}
+		}
+	}
+	return segments
+}
+
+func diffSegments(old, updated []*Segment) []*SegmentChange {
+	pairs := map[*Segment]*Segment{} // updated -> old
+	used := map[*Segment]bool{}
+	match := func(key func(*Segment) string, oldKey func(*Segment) string) {
+		// segments with the same name are matched in order
+		byKey := map[string][]*Segment{}
+		for _, o := range old {
+			if !used[o] {
+				byKey[oldKey(o)] = append(byKey[oldKey(o)], o)
+			}
+		}
+		for _, n := range updated {
+			if pairs[n] != nil || key(n) == "" || len(byKey[key(n)]) == 0 {
+				continue
+			}
+			o := byKey[key(n)][0]
+			byKey[key(n)] = byKey[key(n)][1:]
+			pairs[n] = o
+			used[o] = true
+		}
+	}
+	name := func(s *Segment) string { return normaliseName(s.PlacemarkName()) }
+	legacy := func(s *Segment) string {
+		if s.Legacy == "" {
+			return ""
+		}
+		return normaliseName(s.Legacy)
+	}
+	match(name, name)
+	match(legacy, name)
+	for _, n := range updated {
+		if pairs[n] != nil {
+			continue
+		}
+		var best *Segment
+		var bestDistance float64
+		for _, o := range old {
+			if used[o] || !endsMatch(o.Line, n.Line) {
+				continue
+			}
+			if d := o.Line.Hausdorff(n.Line); d < DIFF_MATCH && (best == nil || d < bestDistance) {
+				best, bestDistance = o, d
+			}
+		}
+		if best != nil {
+			pairs[n] = best
+			used[best] = true
+		}
+	}
+
+	var changes []*SegmentChange
+	for _, n := range updated {
+		o := pairs[n]
+		if o == nil {
+			changes = append(changes, &SegmentChange{New: n})
+			continue
+		}
+		change := &SegmentChange{
+			Old:         o,
+			New:         n,
+			Hausdorff:   o.Line.Hausdorff(n.Line),
+			LengthDelta: n.Length - o.Length,
+			Reversed:    reversed(o.Line, n.Line),
+			Codes:       segmentCodeChanges(o, n),
+		}
+		if change.Hausdorff > DIFF_MOVED || change.Reversed || len(change.Codes) > 0 || name(o) != name(n) {
+			changes = append(changes, change)
+		}
+	}
+	for _, o := range old {
+		if !used[o] {
+			changes = append(changes, &SegmentChange{Old: o})
+		}
+	}
+	return changes
+}
+
+// endsMatch is true if either end of a is within DIFF_MATCH of the same end of b, in either orientation, so a segment
+// that was reversed still matches.
+func endsMatch(a, b geo.Line) bool {
+	return a.Start().Distance(b.Start()) <= DIFF_MATCH || a.End().Distance(b.End()) <= DIFF_MATCH ||
+		a.Start().Distance(b.End()) <= DIFF_MATCH || a.End().Distance(b.Start()) <= DIFF_MATCH
+}
+
+// reversed is true if the ends of b are closer to the opposite ends of a than to the same ends.
+func reversed(a, b geo.Line) bool {
+	same := a.Start().Distance(b.Start()) + a.End().Distance(b.End())
+	opposite := a.Start().Distance(b.End()) + a.End().Distance(b.Start())
+	return opposite < same
+}
+
+func segmentCodeChanges(o, n *Segment) []string {
+	var codes []string
+	changed := func(description, from, to string) {
+		if from != to {
+			if from == "" {
+				from = "none"
+			}
+			if to == "" {
+				to = "none"
+			}
+			codes = append(codes, fmt.Sprintf("%s %s → %s", description, from, to))
+		}
+	}
+	experimental := func(s *Segment) string {
+		if s.Experimental {
+			return "EXP"
+		}
+		return ""
+	}
+	changed("code", o.Code, n.Code)
+	changed("terrain", strings.Join(o.Terrains, "&"), strings.Join(n.Terrains, "&"))
+	changed("verification", o.Verification, n.Verification)
+	changed("directional", o.Directional, n.Directional)
+	changed("exploration", experimental(o), experimental(n))
+	return codes
+}
+
+func diffWaypoints(group string, old, updated []Waypoint) []*WaypointChange {
+	pairs := map[int]int{} // updated index -> old index
+	used := map[int]bool{}
+	match := func(key func(Waypoint) string, oldKey func(Waypoint) string) {
+		// waypoints with the same name are matched in order
+		byKey := map[string][]int{}
+		for i, o := range old {
+			if !used[i] {
+				byKey[oldKey(o)] = append(byKey[oldKey(o)], i)
+			}
+		}
+		for j, n := range updated {
+			if _, done := pairs[j]; done || key(n) == "" || len(byKey[key(n)]) == 0 {
+				continue
+			}
+			i := byKey[key(n)][0]
+			byKey[key(n)] = byKey[key(n)][1:]
+			pairs[j] = i
+			used[i] = true
+		}
+	}
+	name := func(w Waypoint) string { return normaliseName(w.Name) }
+	legacy := func(w Waypoint) string {
+		if w.Legacy == "" {
+			return ""
+		}
+		return normaliseName(w.Legacy)
+	}
+	match(name, name)
+	match(legacy, name)
+	for j, n := range updated {
+		if _, done := pairs[j]; done {
+			continue
+		}
+		for i, o := range old {
+			if !used[i] && o.Pos.Distance(n.Pos) < DIFF_MOVED {
+				pairs[j] = i
+				used[i] = true
+				break
+			}
+		}
+	}
+
+	var changes []*WaypointChange
+	for j := range updated {
+		n := &updated[j]
+		i, ok := pairs[j]
+		if !ok {
+			changes = append(changes, &WaypointChange{Group: group, New: n})
+			continue
+		}
+		o := &old[i]
+		moved := o.Pos.Distance(n.Pos)
+		if moved > DIFF_MOVED || name(*o) != name(*n) {
+			changes = append(changes, &WaypointChange{Group: group, Old: o, New: n, Moved: moved})
+		}
+	}
+	for i := range old {
+		if !used[i] {
+			changes = append(changes, &WaypointChange{Group: group, Old: &old[i]})
+		}
+	}
+	return changes
+}
+
+// Markdown is a changelog of the changes.
+func (c *Changes) Markdown() string {
+	sb := &strings.Builder{}
+	fmt.Fprintln(sb, "# Changes")
+	if len(c.Sections) == 0 && len(c.Waypoints) == 0 {
+		fmt.Fprintln(sb)
+		fmt.Fprintln(sb, "No changes.")
+	}
+	for _, s := range c.Sections {
+		fmt.Fprintln(sb)
+		switch {
+		case s.Old == nil:
+			fmt.Fprintf(sb, "## %s (added)\n", s.New.FolderName())
+		case s.New == nil:
+			fmt.Fprintf(sb, "## %s (removed)\n", s.Old.FolderName())
+		case s.Old.Name != s.New.Name:
+			fmt.Fprintf(sb, "## %s (renamed from %s)\n", s.New.FolderName(), s.Old.FolderName())
+		default:
+			fmt.Fprintf(sb, "## %s\n", s.New.FolderName())
+		}
+		if len(s.Routes) > 0 {
+			fmt.Fprintln(sb)
+			fmt.Fprintln(sb, "### Routes")
+			fmt.Fprintln(sb)
+			for _, r := range s.Routes {
+				switch {
+				case r.Old == nil:
+					fmt.Fprintf(sb, "- Added %s\n", r.New.Debug())
+				case r.New == nil:
+					fmt.Fprintf(sb, "- Removed %s\n", r.Old.Debug())
+				default:
+					fmt.Fprintf(sb, "- Renamed %s → %s\n", r.Old.Debug(), r.New.Debug())
+				}
+			}
+		}
+		if len(s.Segments) > 0 {
+			fmt.Fprintln(sb)
+			fmt.Fprintln(sb, "### Segments")
+			fmt.Fprintln(sb)
+			for _, seg := range s.Segments {
+				switch {
+				case seg.Old == nil:
+					fmt.Fprintf(sb, "- Added `%s` (%.1f km)\n", seg.New.PlacemarkName(), seg.New.Length)
+				case seg.New == nil:
+					fmt.Fprintf(sb, "- Removed `%s` (%.1f km)\n", seg.Old.PlacemarkName(), seg.Old.Length)
+				default:
+					var details []string
+					details = append(details, seg.Codes...)
+					if seg.Reversed {
+						details = append(details, "reversed")
+					}
+					if seg.Hausdorff > DIFF_MOVED {
+						details = append(details, fmt.Sprintf("geometry moved up to %.0f m, length %+.1f km", seg.Hausdorff*1000, seg.LengthDelta))
+					}
+					oldName, newName := seg.Old.PlacemarkName(), seg.New.PlacemarkName()
+					title := fmt.Sprintf("`%s`", newName)
+					if normaliseName(oldName) != normaliseName(newName) {
+						title = fmt.Sprintf("`%s` → `%s`", oldName, newName)
+					}
+					if len(details) == 0 {
+						fmt.Fprintf(sb, "- Renamed %s\n", title)
+					} else {
+						fmt.Fprintf(sb, "- Changed %s: %s\n", title, strings.Join(details, "; "))
+					}
+				}
+			}
+		}
+		if len(s.Waypoints) > 0 {
+			fmt.Fprintln(sb)
+			fmt.Fprintln(sb, "### Waypoints")
+			fmt.Fprintln(sb)
+			writeWaypointChanges(sb, s.Waypoints)
+		}
+	}
+	if len(c.Waypoints) > 0 {
+		fmt.Fprintln(sb)
+		fmt.Fprintln(sb, "## Other waypoints")
+		fmt.Fprintln(sb)
+		writeWaypointChanges(sb, c.Waypoints)
+	}
+	return sb.String()
+}
+
+func writeWaypointChanges(sb *strings.Builder, changes []*WaypointChange) {
+	for _, w := range changes {
+		switch {
+		case w.Old == nil:
+			fmt.Fprintf(sb, "- Added %s `%s`\n", w.Group, w.New.Name)
+		case w.New == nil:
+			fmt.Fprintf(sb, "- Removed %s `%s`\n", w.Group, w.Old.Name)
+		default:
+			var details []string
+			if normaliseName(w.Old.Name) != normaliseName(w.New.Name) {
+				details = append(details, fmt.Sprintf("renamed `%s` → `%s`", w.Old.Name, w.New.Name))
+			}
+			if w.Moved > DIFF_MOVED {
+				details = append(details, fmt.Sprintf("moved %.0f m", w.Moved*1000))
+			}
+			fmt.Fprintf(sb, "- %s%s `%s`: %s\n", strings.ToUpper(w.Group[:1]), w.Group[1:], w.New.Name, strings.Join(details, ", "))
+		}
+	}
+}
+
+// Save writes the Markdown changelog and a KMZ file of the changed geometry. Added segments are green, removed
+// segments are red, and changed segments are orange with the old geometry in grey.
+func (c *Changes) Save(dpath string) error {
+	if err := writeText(filepath.Join(dpath, "Changes.md"), c.Markdown()); err != nil {
+		return fmt.Errorf("writing changelog: %w", err)
+	}
+
+	line := func(name, description, style string, l geo.Line) *kml.Placemark {
+		return &kml.Placemark{
+			Name:        name,
+			Description: description,
+			Visibility:  1,
+			StyleUrl:    style,
+			LineString: &kml.LineString{
+				Tessellate:  true,
+				Coordinates: kml.LineCoordinates(l),
+			},
+		}
+	}
+	point := func(name, description string, pos geo.Pos) *kml.Placemark {
+		return &kml.Placemark{
+			Name:        name,
+			Description: description,
+			Visibility:  1,
+			StyleUrl:    "#changed-point",
+			Point:       kml.PosPoint(pos),
+		}
+	}
+	var folders []*kml.Folder
+	waypointPlacemarks := func(changes []*WaypointChange) []*kml.Placemark {
+		var placemarks []*kml.Placemark
+		for _, w := range changes {
+			switch {
+			case w.Old == nil:
+				placemarks = append(placemarks, point(w.New.Name, "Added", w.New.Pos))
+			case w.New == nil:
+				placemarks = append(placemarks, point(w.Old.Name, "Removed", w.Old.Pos))
+			default:
+				placemarks = append(placemarks, point(w.New.Name, fmt.Sprintf("Was %q, moved %.0f m", w.Old.Name, w.Moved*1000), w.New.Pos))
+			}
+		}
+		return placemarks
+	}
+	for _, s := range c.Sections {
+		name := fmt.Sprintf("GPT%s", s.Key.Code())
+		if s.New != nil {
+			name = s.New.FolderName()
+		} else if s.Old != nil {
+			name = s.Old.FolderName()
+		}
+		folder := &kml.Folder{Name: name, Visibility: 1}
+		for _, seg := range s.Segments {
+			switch {
+			case seg.Old == nil:
+				folder.Placemarks = append(folder.Placemarks, line(seg.New.PlacemarkName(), "Added", "#added", seg.New.Line))
+			case seg.New == nil:
+				folder.Placemarks = append(folder.Placemarks, line(seg.Old.PlacemarkName(), "Removed", "#removed", seg.Old.Line))
+			case seg.Hausdorff > DIFF_MOVED || seg.Reversed:
+				description := fmt.Sprintf("Moved up to %.0f m, length %+.1f km", seg.Hausdorff*1000, seg.LengthDelta)
+				if seg.Reversed {
+					description += ", reversed"
+				}
+				folder.Placemarks = append(folder.Placemarks,
+					line(seg.Old.PlacemarkName(), "Old geometry", "#old", seg.Old.Line),
+					line(seg.New.PlacemarkName(), description, "#changed", seg.New.Line),
+				)
+			}
+		}
+		folder.Placemarks = append(folder.Placemarks, waypointPlacemarks(s.Waypoints)...)
+		if len(folder.Placemarks) > 0 {
+			folders = append(folders, folder)
+		}
+	}
+	if placemarks := waypointPlacemarks(c.Waypoints); len(placemarks) > 0 {
+		folders = append(folders, &kml.Folder{Name: "Other waypoints", Visibility: 1, Placemarks: placemarks})
+	}
+
+	lineStyle := func(id, colour string, width float64) *kml.Style {
+		return &kml.Style{Id: id, LineStyle: &kml.LineStyle{Color: colour, Width: geo.FloatOne(width)}}
+	}
+	root := kml.Root{
+		Xmlns: "http://www.opengis.net/kml/2.2",
+		Document: kml.Document{
+			Name:       "Changes.kmz",
+			Visibility: 1,
+			Open:       1,
+			Styles: []*kml.Style{
+				lineStyle("added", "ff00ff00", 4),
+				lineStyle("removed", "ff0000ff", 4),
+				lineStyle("changed", "ff0080ff", 4),
+				lineStyle("old", "ff808080", 2),
+				{
+					Id: "changed-point",
+					IconStyle: &kml.IconStyle{
+						Scale: 0.8,
+						Icon:  &kml.Icon{Href: "http://maps.google.com/mapfiles/kml/paddle/orange-circle.png"},
+					},
+				},
+			},
+			Folders: folders,
+		},
+	}
+	if err := root.Save(filepath.Join(dpath, "Changes.kmz")); err != nil {
+		return fmt.Errorf("writing changes kmz: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package routedata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/dave/gpt/globals"
+)
+
+// Fetcher downloads web pages into a cache directory. Requests are rate limited and retried with backoff, and cached
+// pages are revalidated with ETag / Last-Modified when they expire. Only successful responses are cached.
+type Fetcher struct {
+	Dir       string // cache directory
+	Client    *http.Client
+	UserAgent string
+	Retries   int           // number of retries after the first attempt
+	Backoff   time.Duration // wait before the first retry, doubled for each subsequent retry
+	Interval  time.Duration // minimum time between requests
+	MaxAge    time.Duration // cached pages older than this are revalidated
+	Refresh   bool          // revalidate all cached pages
+
+	last time.Time // time of the last request
+}
+
+// fetchMeta is the validators of a cached page, stored alongside it.
+type fetchMeta struct {
+	URL          string
+	ETag         string
+	LastModified string
+}
+
+// StatusError is an unsuccessful HTTP response for a page that isn't cached.
+type StatusError struct {
+	URL        string
+	Status     string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("getting %q: %s", e.URL, e.Status)
+}
+
+// NewFetcher creates a Fetcher with the default settings.
+func NewFetcher(dir string, refresh bool) *Fetcher {
+	return &Fetcher{
+		Dir:       dir,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+		UserAgent: fmt.Sprintf("gpt/%s (+https://github.com/dave/gpt)", globals.VERSION),
+		Retries:   3,
+		Backoff:   2 * time.Second,
+		Interval:  time.Second,
+		MaxAge:    30 * 24 * time.Hour,
+		Refresh:   refresh,
+	}
+}
+
+// Get returns the page at url, cached in the file name. If the page can't be downloaded but there's a cached copy,
+// the cached copy is used.
+func (f *Fetcher) Get(url, name string) ([]byte, error) {
+	fpath := filepath.Join(f.Dir, name)
+	mpath := fpath + ".meta"
+
+	cached, err := ioutil.ReadFile(fpath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading %s: %w", fpath, err)
+	}
+	found := err == nil
+	var meta fetchMeta
+	if found {
+		info, err := os.Stat(fpath)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", fpath, err)
+		}
+		if !f.Refresh && time.Since(info.ModTime()) < f.MaxAge {
+			return cached, nil
+		}
+		if b, err := ioutil.ReadFile(mpath); err == nil {
+			if err := json.Unmarshal(b, &meta); err != nil {
+				return nil, fmt.Errorf("reading %s: %w", mpath, err)
+			}
+		}
+		if meta.URL != url {
+			// cached from another url: don't revalidate
+			meta = fetchMeta{}
+		}
+	}
+
+	resp, body, err := f.request(url, meta)
+	if err != nil {
+		if found {
+			logf("%v - using cached copy of %q\n", err, url)
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if !found {
+			return nil, fmt.Errorf("getting %q: not modified, but no cached copy", url)
+		}
+		now := time.Now()
+		if err := os.Chtimes(fpath, now, now); err != nil {
+			return nil, fmt.Errorf("updating %s: %w", fpath, err)
+		}
+		return cached, nil
+	case http.StatusOK:
+		if err := os.MkdirAll(f.Dir, 0777); err != nil {
+			return nil, fmt.Errorf("creating %s: %w", f.Dir, err)
+		}
+		if err := ioutil.WriteFile(fpath, body, 0666); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", fpath, err)
+		}
+		b, err := json.Marshal(fetchMeta{URL: url, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")})
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s: %w", mpath, err)
+		}
+		if err := ioutil.WriteFile(mpath, b, 0666); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", mpath, err)
+		}
+		return body, nil
+	default:
+		if found {
+			logf("getting %q: %s - using cached copy\n", url, resp.Status)
+			return cached, nil
+		}
+		return nil, &StatusError{URL: url, Status: resp.Status, StatusCode: resp.StatusCode}
+	}
+}
+
+// request makes a conditional GET request, retrying after network errors and server errors (5xx and 429). If the
+// server sends a Retry-After header, that's the wait before the retry instead of the backoff. The wait is capped at the
+// longest backoff (Backoff doubled for each retry): if the server asks for longer, the response is returned without
+// retrying, so the cached copy is used or the page fails.
+func (f *Fetcher) request(url string, meta fetchMeta) (*http.Response, []byte, error) {
+	backoff := f.Backoff
+	for attempt := 0; ; attempt++ {
+		if wait := f.Interval - time.Since(f.last); wait > 0 {
+			time.Sleep(wait)
+		}
+		f.last = time.Now()
+
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating request for %q: %w", url, err)
+		}
+		req.Header.Set("User-Agent", f.UserAgent)
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+
+		logf("Scraping %q for description\n", url)
+		resp, err := f.Client.Do(req)
+		var body []byte
+		if err == nil {
+			body, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		retry := err != nil || resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		if !retry {
+			return resp, body, nil
+		}
+		if attempt == f.Retries {
+			if err != nil {
+				return nil, nil, fmt.Errorf("getting %q: %w", url, err)
+			}
+			return resp, body, nil
+		}
+		wait := backoff
+		if err != nil {
+			logf("getting %q: %v - retrying in %v\n", url, err, wait)
+		} else {
+			if after := retryAfter(resp); after > f.Backoff<<uint(f.Retries) {
+				logf("getting %q: %s - server asked to wait %v, giving up\n", url, resp.Status, after)
+				return resp, body, nil
+			} else if after > 0 {
+				wait = after
+			}
+			logf("getting %q: %s - retrying in %v\n", url, resp.Status, wait)
+		}
+		time.Sleep(wait)
+		backoff *= 2
+	}
+}
+
+// retryAfter is the wait requested by the Retry-After header of a response (in seconds or as a date), or zero if
+// there isn't one.
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,331 @@
+package routedata
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dave/gpt/globals"
+)
+
+// testServer records the requests it gets, and responds with the handler for the path.
+type testServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []*testRequest
+}
+
+type testRequest struct {
+	path   string
+	header http.Header
+	time   time.Time
+}
+
+func newTestServer(t *testing.T, handlers map[string]http.HandlerFunc) *testServer {
+	s := &testServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.requests = append(s.requests, &testRequest{path: r.URL.Path, header: r.Header, time: time.Now()})
+		s.mutex.Unlock()
+		handler, ok := handlers[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) count(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var n int
+	for _, r := range s.requests {
+		if r.path == path {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *testServer) last() *testRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[len(s.requests)-1]
+}
+
+// testFetcher is a fetcher with a temporary cache directory and short waits.
+func testFetcher(t *testing.T) *Fetcher {
+	dir, err := ioutil.TempDir("", "gpt-fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	f := NewFetcher(dir, false)
+	f.Backoff = 10 * time.Millisecond
+	f.Interval = 0
+	return f
+}
+
+func cached(f *Fetcher, name string) bool {
+	_, err := os.Stat(filepath.Join(f.Dir, name))
+	return err == nil
+}
+
+func TestFetcherCache(t *testing.T) {
+	s := newTestServer(t, map[string]http.HandlerFunc{
+		"/ok": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("page"))
+		},
+		"/error": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "broken", http.StatusInternalServerError)
+		},
+	})
+	f := testFetcher(t)
+	f.Retries = 0
+
+	b, err := f.Get(s.URL+"/ok", "ok.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "page" {
+		t.Errorf("expected page, found %q", b)
+	}
+	if !cached(f, "ok.html") {
+		t.Error("200 response not cached")
+	}
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"/missing", http.StatusNotFound},
+		{"/error", http.StatusInternalServerError},
+	} {
+		_, err := f.Get(s.URL+test.path, test.path[1:]+".html")
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != test.status {
+			t.Errorf("%s: expected a %d status error, found %v", test.path, test.status, err)
+		}
+		if cached(f, test.path[1:]+".html") {
+			t.Errorf("%s: %d response cached", test.path, test.status)
+		}
+	}
+
+	// a cached page is used when the server fails
+	f.Refresh = true
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	})
+	if b, err := f.Get(s.URL+"/ok", "ok.html"); err != nil || string(b) != "page" {
+		t.Errorf("expected the cached page, found %q, %v", b, err)
+	}
+}
+
+func TestFetcherRevalidate(t *testing.T) {
+	modified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+	s := newTestServer(t, map[string]http.HandlerFunc{
+		"/page": func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Last-Modified", modified)
+			w.Write([]byte("version 1"))
+		},
+	})
+	f := testFetcher(t)
+	if _, err := f.Get(s.URL+"/page", "page.html"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a fresh page isn't requested again
+	if _, err := f.Get(s.URL+"/page", "page.html"); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.count("/page"); n != 1 {
+		t.Fatalf("expected 1 request for a fresh page, found %d", n)
+	}
+
+	// an expired page is revalidated
+	old := time.Now().Add(-f.MaxAge - time.Hour)
+	if err := os.Chtimes(filepath.Join(f.Dir, "page.html"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	b, err := f.Get(s.URL+"/page", "page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := s.count("/page"); n != 2 {
+		t.Fatalf("expected 2 requests, found %d", n)
+	}
+	header := s.last().header
+	if header.Get("If-None-Match") != `"v1"` {
+		t.Errorf("expected If-None-Match \"v1\", found %q", header.Get("If-None-Match"))
+	}
+	if header.Get("If-Modified-Since") != modified {
+		t.Errorf("expected If-Modified-Since %q, found %q", modified, header.Get("If-Modified-Since"))
+	}
+	if string(b) != "version 1" {
+		t.Errorf("expected the cached copy after 304, found %q", b)
+	}
+	info, err := os.Stat(filepath.Join(f.Dir, "page.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(info.ModTime()) > time.Minute {
+		t.Error("cached copy not renewed after 304")
+	}
+}
+
+func TestFetcherRetry(t *testing.T) {
+	var failures int
+	s := newTestServer(t, map[string]http.HandlerFunc{
+		"/flaky": func(w http.ResponseWriter, r *http.Request) {
+			if failures < 2 {
+				failures++
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("page"))
+		},
+		"/busy": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		},
+		"/closed": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "86400")
+			http.Error(w, "come back tomorrow", http.StatusTooManyRequests)
+		},
+	})
+	f := testFetcher(t)
+
+	start := time.Now()
+	b, err := f.Get(s.URL+"/flaky", "flaky.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "page" {
+		t.Errorf("expected page, found %q", b)
+	}
+	if n := s.count("/flaky"); n != 3 {
+		t.Errorf("expected 3 requests, found %d", n)
+	}
+	// backoff of 10 ms then 20 ms
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("expected backoff of at least 30 ms, found %v", elapsed)
+	}
+
+	// the longest backoff is 2 s, so a Retry-After of 1 s is honoured
+	f.Retries, f.Backoff = 1, time.Second
+	start = time.Now()
+	_, err = f.Get(s.URL+"/busy", "busy.html")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected a 429 status error, found %v", err)
+	}
+	if n := s.count("/busy"); n != 2 {
+		t.Errorf("expected 2 requests, found %d", n)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected to wait for Retry-After of 1 s, found %v", elapsed)
+	}
+
+	// a wait longer than the longest backoff isn't honoured, and the page fails
+	start = time.Now()
+	_, err = f.Get(s.URL+"/closed", "closed.html")
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected a 429 status error, found %v", err)
+	}
+	if n := s.count("/closed"); n != 1 {
+		t.Errorf("expected 1 request, found %d", n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected to give up without waiting, found %v", elapsed)
+	}
+}
+
+func TestFetcherRefresh(t *testing.T) {
+	s := newTestServer(t, map[string]http.HandlerFunc{
+		"/page": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("page"))
+		},
+	})
+	f := testFetcher(t)
+	for i := 0; i < 2; i++ {
+		if _, err := f.Get(s.URL+"/page", "page.html"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := s.count("/page"); n != 1 {
+		t.Fatalf("expected 1 request, found %d", n)
+	}
+	f.Refresh = true
+	if _, err := f.Get(s.URL+"/page", "page.html"); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.count("/page"); n != 2 {
+		t.Errorf("expected a request with refresh, found %d requests", n)
+	}
+}
+
+func TestFetcherInterval(t *testing.T) {
+	s := newTestServer(t, map[string]http.HandlerFunc{})
+	f := testFetcher(t)
+	f.Interval = 50 * time.Millisecond
+	for _, name := range []string{"a", "b", "c"} {
+		f.Get(s.URL+"/"+name, name+".html")
+	}
+	// the requests are spaced when they're sent, so allow for jitter in when they arrive
+	for i := 1; i < len(s.requests); i++ {
+		if gap := s.requests[i].time.Sub(s.requests[i-1].time); gap < f.Interval-5*time.Millisecond {
+			t.Errorf("request %d was %v after the previous request", i, gap)
+		}
+	}
+	if len(s.requests) != 3 {
+		t.Errorf("expected 3 requests, found %d", len(s.requests))
+	}
+}
+
+// redirect sends every request to the test server.
+type redirect struct {
+	target *url.URL
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = r.target.Scheme, r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestScrapeNotFound(t *testing.T) {
+	// the wiki has no page for the section
+	s := newTestServer(t, map[string]http.HandlerFunc{})
+	target, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := testFetcher(t)
+	f.Client = &http.Client{Transport: redirect{target}}
+	key := globals.SectionKey{Number: 99}
+	d := &Data{
+		Keys:     []globals.SectionKey{key},
+		Sections: map[globals.SectionKey]*Section{key: {Key: key, Scraped: map[globals.ModeType]string{}}},
+	}
+	if err := d.Scrape(f, "api"); err != nil {
+		t.Fatalf("expected the section to be skipped, found %v", err)
+	}
+	if n := s.count("/GPT99"); n != 1 {
+		t.Errorf("expected the web page to be requested after the API, found %d requests", n)
+	}
+}
@@ -0,0 +1,494 @@
+package routedata
+
+import (
+	"container/heap"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/globals"
+	"github.com/dave/gpt/gpx"
+)
+
+// TERRAIN_SPEEDS is the estimated speed in km/h for each terrain code, used for time costs.
+var TERRAIN_SPEEDS = map[string]float64{
+	"TL": 4,
+	"MR": 5,
+	"PR": 5,
+	"CC": 2.5,
+	"BB": 1,
+	"FJ": 3,
+	"LK": 3,
+	"RI": 5,
+	"FY": 15,
+}
+
+// ASCENT_PER_HOUR is the metres of ascent that add an hour to the time estimate.
+const ASCENT_PER_HOUR = 600
+
+// AVOID_PENALTY multiplies the cost of terrains and segments that are avoided.
+const AVOID_PENALTY = 5
+
+// Graph is the segments of all regular and optional routes in a mode, joined where the start or end of one segment
+// is within globals.DELTA of any part of another.
+type Graph struct {
+	Mode  globals.ModeType
+	Nodes []*GraphNode
+	Edges []*GraphEdge
+	edges *geo.Index
+}
+
+// GraphNode is a position where edges meet.
+type GraphNode struct {
+	Pos   geo.Pos
+	Edges []*GraphEdge
+}
+
+// GraphEdge is a segment, or the part of a segment between two nodes.
+type GraphEdge struct {
+	Segment  *Segment
+	From, To *GraphNode
+	Line     geo.Line // from From to To
+	Length   float64  // km
+}
+
+// graphSplit is a position on a segment line where the segment is split.
+type graphSplit struct {
+	index    int
+	fraction float64
+	pos      geo.Pos
+}
+
+// BuildGraph builds the routing graph for a mode.
+func (d *Data) BuildGraph(mode globals.ModeType) *Graph {
+	g := &Graph{Mode: mode, edges: geo.NewIndex(1)}
+
+	var segments []*Segment
+	done := map[*Segment]bool{}
+	for _, key := range d.Keys {
+		if globals.HAS_SINGLE && key != globals.SINGLE {
+			continue
+		}
+		section := d.Sections[key]
+		for _, routeKey := range section.RouteKeys {
+			route := section.Routes[routeKey]
+			if route.Modes[mode] == nil {
+				continue
+			}
+			for _, segment := range route.Modes[mode].Segments {
+				if !done[segment] {
+					done[segment] = true
+					segments = append(segments, segment)
+				}
+			}
+		}
+	}
+
+	// split each segment at its own ends, where its ends touch the segment itself, and wherever the end of another
+	// segment joins it
+	lines := geo.NewIndex(1)
+	for _, segment := range segments {
+		lines.AddLine(segment.Line, segment)
+	}
+	splits := map[*Segment][]graphSplit{}
+	for _, segment := range segments {
+		last := len(segment.Line) - 1
+		splits[segment] = append(splits[segment], graphSplit{0, 0, segment.Line[0]}, graphSplit{last, 0, segment.Line[last]})
+		splits[segment] = append(splits[segment], selfSplits(segment.Line)...)
+	}
+	for _, segment := range segments {
+		for _, pos := range []geo.Pos{segment.Line.Start(), segment.Line.End()} {
+			for _, hit := range lines.Within(pos, globals.DELTA, func(v interface{}) bool { return v != segment }) {
+				other := hit.Value.(*Segment)
+				splits[other] = append(splits[other], graphSplit{hit.Index, hit.Fraction, hit.Pos})
+			}
+		}
+	}
+
+	nodes := geo.NewIndex(1)
+	node := func(pos geo.Pos) *GraphNode {
+		if hit, found := nodes.Nearest(pos, globals.DELTA, nil); found {
+			return hit.Value.(*GraphNode)
+		}
+		n := &GraphNode{Pos: pos}
+		nodes.AddPos(pos, n)
+		g.Nodes = append(g.Nodes, n)
+		return n
+	}
+	for _, segment := range segments {
+		s := splits[segment]
+		sort.Slice(s, func(i, j int) bool {
+			if s[i].index != s[j].index {
+				return s[i].index < s[j].index
+			}
+			return s[i].fraction < s[j].fraction
+		})
+		from := node(s[0].pos)
+		line := geo.Line{s[0].pos}
+		for i := 1; i < len(s); i++ {
+			for j := s[i-1].index + 1; j <= s[i].index; j++ {
+				line = append(line, segment.Line[j])
+			}
+			if s[i].fraction > 0 {
+				line = append(line, s[i].pos)
+			}
+			to := node(s[i].pos)
+			if to == from {
+				// a split at the same position, or a loop back to the same node
+				if line.Length() <= globals.DELTA {
+					continue
+				}
+				// a loop is split at the point furthest from the node, so it's two edges between different nodes
+				furthest := 0
+				for j := range line {
+					if line[j].Distance(from.Pos) > line[furthest].Distance(from.Pos) {
+						furthest = j
+					}
+				}
+				if line[furthest].Distance(from.Pos) > globals.DELTA {
+					if middle := node(line[furthest]); middle != from {
+						out, back := append(geo.Line{}, line[:furthest+1]...), append(geo.Line{}, line[furthest:]...)
+						g.addEdge(&GraphEdge{Segment: segment, From: from, To: middle, Line: out, Length: out.Length()})
+						g.addEdge(&GraphEdge{Segment: segment, From: middle, To: to, Line: back, Length: back.Length()})
+					}
+				}
+				line = geo.Line{s[i].pos}
+				continue
+			}
+			g.addEdge(&GraphEdge{Segment: segment, From: from, To: to, Line: line, Length: line.Length()})
+			from = to
+			line = geo.Line{s[i].pos}
+		}
+	}
+	return g
+}
+
+// selfSplits are where the ends of a line touch the line itself away from the end, e.g. where the loop of a lollipop
+// shaped segment returns to the stick. Only the parts of the line more than 2*DELTA along from each end are searched.
+func selfSplits(line geo.Line) []graphSplit {
+	if len(line) < 3 {
+		return nil
+	}
+	along := make([]float64, len(line))
+	for i := 1; i < len(line); i++ {
+		along[i] = along[i-1] + line[i-1].Distance(line[i])
+	}
+	total := along[len(line)-1]
+	var splits []graphSplit
+	last := len(line) - 1
+	for last > 0 && total-along[last] <= 2*globals.DELTA {
+		last--
+	}
+	if last > 0 {
+		if proj := line[:last+1].Project(line.End()); proj.Distance < globals.DELTA {
+			splits = append(splits, graphSplit{proj.Index, proj.Fraction, proj.Pos})
+		}
+	}
+	first := 0
+	for first < len(line)-1 && along[first] <= 2*globals.DELTA {
+		first++
+	}
+	if first < len(line)-1 {
+		if proj := line[first:].Project(line.Start()); proj.Distance < globals.DELTA {
+			splits = append(splits, graphSplit{first + proj.Index, proj.Fraction, proj.Pos})
+		}
+	}
+	return splits
+}
+
+func (g *Graph) addEdge(e *GraphEdge) {
+	e.From.Edges = append(e.From.Edges, e)
+	e.To.Edges = append(e.To.Edges, e)
+	g.Edges = append(g.Edges, e)
+	g.edges.AddLine(e.Line, e)
+}
+
+// Opposite is the node at the other end of the edge.
+func (e *GraphEdge) Opposite(n *GraphNode) *GraphNode {
+	if e.From == n {
+		return e.To
+	}
+	return e.From
+}
+
+// RoutingCost configures what is minimised when finding a path.
+type RoutingCost struct {
+	Time               bool     // minimise the estimated time instead of the distance
+	Avoid              []string // terrain codes to avoid e.g. "FY", "BB"
+	AvoidInvestigation bool     // avoid segments with verification code "I"
+}
+
+// Hours is the estimated time to travel along a line of a segment, using the terrain speed and the ascent.
+func Hours(segment *Segment, line geo.Line) float64 {
+	var speed float64
+	water := false
+	for _, terrain := range segment.Terrains {
+		speed += TERRAIN_SPEEDS[terrain]
+		if terrain == "FJ" || terrain == "LK" || terrain == "RI" || terrain == "FY" {
+			water = true
+		}
+	}
+	if len(segment.Terrains) == 0 || speed == 0 {
+		speed = TERRAIN_SPEEDS["TL"]
+	} else {
+		speed /= float64(len(segment.Terrains))
+	}
+	hours := line.Length() / speed
+	if !water {
+		var ascent float64
+		for i := 1; i < len(line); i++ {
+			if climb := line[i].Ele - line[i-1].Ele; climb > 0 {
+				ascent += climb
+			}
+		}
+		hours += ascent / ASCENT_PER_HOUR
+	}
+	return hours
+}
+
+// cost is the cost of travelling along a line of a segment.
+func (c RoutingCost) cost(segment *Segment, line geo.Line) float64 {
+	var cost float64
+	if c.Time {
+		cost = Hours(segment, line)
+	} else {
+		cost = line.Length()
+	}
+	avoid := c.AvoidInvestigation && segment.Verification == "I"
+	for _, terrain := range segment.Terrains {
+		for _, a := range c.Avoid {
+			if terrain == a {
+				avoid = true
+			}
+		}
+	}
+	if avoid {
+		cost *= AVOID_PENALTY
+	}
+	return cost
+}
+
+// heuristic is a lower bound of the cost between two positions.
+func (c RoutingCost) heuristic(from, to geo.Pos) float64 {
+	if c.Time {
+		return from.Distance(to) / TERRAIN_SPEEDS["FY"]
+	}
+	return from.Distance(to)
+}
+
+// Plan is the shortest path between two positions.
+type Plan struct {
+	Mode     globals.ModeType
+	From, To geo.Pos
+	Cost     RoutingCost
+	Steps    []*PlanStep
+	Length   float64 // km
+	Hours    float64
+	Offset   [2]float64 // km from the requested start and end positions to the graph
+}
+
+// PlanStep is the part of a segment travelled by a plan, in the direction of travel.
+type PlanStep struct {
+	Segment *Segment
+	Line    geo.Line
+	Length  float64 // km
+}
+
+// Line is the whole path of the plan.
+func (p *Plan) Line() geo.Line {
+	var line geo.Line
+	for _, step := range p.Steps {
+		if len(line) > 0 {
+			line = append(line, step.Line[1:]...)
+		} else {
+			line = append(line, step.Line...)
+		}
+	}
+	return line
+}
+
+// Route finds the lowest cost path between two positions using the A* algorithm. The positions are joined to the
+// nearest edge within radius km.
+func (g *Graph) Route(from, to geo.Pos, cost RoutingCost, radius float64) (*Plan, error) {
+	fromHit, found := g.edges.Nearest(from, radius, nil)
+	if !found {
+		return nil, fmt.Errorf("no %s route within %.0f km of the start", modeName(g.Mode), radius)
+	}
+	toHit, found := g.edges.Nearest(to, radius, nil)
+	if !found {
+		return nil, fmt.Errorf("no %s route within %.0f km of the end", modeName(g.Mode), radius)
+	}
+	start := &GraphNode{Pos: fromHit.Pos}
+	target := &GraphNode{Pos: toHit.Pos}
+
+	// temporary edges join the start and target to the ends of the edges they are on
+	extra := map[*GraphNode][]*GraphEdge{}
+	addExtra := func(e *GraphEdge) {
+		e.Length = e.Line.Length()
+		extra[e.From] = append(extra[e.From], e)
+		extra[e.To] = append(extra[e.To], e)
+	}
+	fromEdge, toEdge := fromHit.Value.(*GraphEdge), toHit.Value.(*GraphEdge)
+	before, after := splitAt(fromEdge.Line, fromHit.Projection)
+	addExtra(&GraphEdge{Segment: fromEdge.Segment, From: fromEdge.From, To: start, Line: before})
+	addExtra(&GraphEdge{Segment: fromEdge.Segment, From: start, To: fromEdge.To, Line: after})
+	before, after = splitAt(toEdge.Line, toHit.Projection)
+	addExtra(&GraphEdge{Segment: toEdge.Segment, From: toEdge.From, To: target, Line: before})
+	addExtra(&GraphEdge{Segment: toEdge.Segment, From: target, To: toEdge.To, Line: after})
+	if fromEdge == toEdge {
+		a, b := fromHit.Projection, toHit.Projection
+		if a.Index > b.Index || (a.Index == b.Index && a.Fraction > b.Fraction) {
+			addExtra(&GraphEdge{Segment: fromEdge.Segment, From: target, To: start, Line: between(fromEdge.Line, b, a)})
+		} else {
+			addExtra(&GraphEdge{Segment: fromEdge.Segment, From: start, To: target, Line: between(fromEdge.Line, a, b)})
+		}
+	}
+
+	type step struct {
+		edge    *GraphEdge
+		forward bool
+	}
+	costs := map[*GraphNode]float64{start: 0}
+	previous := map[*GraphNode]step{}
+	settled := map[*GraphNode]bool{}
+	queue := &graphQueue{}
+	heap.Push(queue, &graphItem{node: start, priority: cost.heuristic(start.Pos, target.Pos)})
+	for queue.Len() > 0 {
+		node := heap.Pop(queue).(*graphItem).node
+		if settled[node] {
+			continue
+		}
+		settled[node] = true
+		if node == target {
+			break
+		}
+		for _, edges := range [][]*GraphEdge{node.Edges, extra[node]} {
+			for _, edge := range edges {
+				next := edge.Opposite(node)
+				if settled[next] {
+					continue
+				}
+				line := edge.Line
+				forward := edge.From == node
+				if oneWay, flow := edge.Segment.OneWay(g.Mode); oneWay && forward != flow {
+					continue
+				}
+				if !forward {
+					line = reversedLine(line)
+				}
+				c := costs[node] + cost.cost(edge.Segment, line)
+				if existing, found := costs[next]; found && existing <= c {
+					continue
+				}
+				costs[next] = c
+				previous[next] = step{edge, forward}
+				heap.Push(queue, &graphItem{node: next, priority: c + cost.heuristic(next.Pos, target.Pos)})
+			}
+		}
+	}
+	if !settled[target] {
+		return nil, fmt.Errorf("no %s route between the start and end (one-way segments are only travelled in their direction)", modeName(g.Mode))
+	}
+
+	plan := &Plan{
+		Mode:   g.Mode,
+		From:   from,
+		To:     to,
+		Cost:   cost,
+		Offset: [2]float64{fromHit.Distance, toHit.Distance},
+	}
+	for node := target; node != start; {
+		s := previous[node]
+		line := s.edge.Line
+		if !s.forward {
+			line = reversedLine(line)
+		}
+		plan.Steps = append([]*PlanStep{{Segment: s.edge.Segment, Line: line, Length: s.edge.Length}}, plan.Steps...)
+		plan.Length += s.edge.Length
+		plan.Hours += Hours(s.edge.Segment, line)
+		node = s.edge.Opposite(node)
+	}
+
+	// merge consecutive steps on the same segment
+	var steps []*PlanStep
+	for _, s := range plan.Steps {
+		if len(s.Line) < 2 {
+			continue
+		}
+		if len(steps) > 0 && steps[len(steps)-1].Segment == s.Segment {
+			last := steps[len(steps)-1]
+			last.Line = append(last.Line, s.Line[1:]...)
+			last.Length += s.Length
+			continue
+		}
+		steps = append(steps, s)
+	}
+	plan.Steps = steps
+	return plan, nil
+}
+
+// splitAt splits a line at a projected position.
+func splitAt(line geo.Line, proj geo.Projection) (geo.Line, geo.Line) {
+	before := append(append(geo.Line{}, line[:proj.Index+1]...), proj.Pos)
+	after := append(geo.Line{proj.Pos}, line[proj.Index+1:]...)
+	return before, after
+}
+
+// between is the part of a line between two projected positions, where a is before b.
+func between(line geo.Line, a, b geo.Projection) geo.Line {
+	l := geo.Line{a.Pos}
+	l = append(l, line[a.Index+1:b.Index+1]...)
+	return append(l, b.Pos)
+}
+
+type graphItem struct {
+	node     *GraphNode
+	priority float64
+}
+
+type graphQueue []*graphItem
+
+func (q graphQueue) Len() int            { return len(q) }
+func (q graphQueue) Less(i, j int) bool  { return q[i].priority < q[j].priority }
+func (q graphQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *graphQueue) Push(x interface{}) { *q = append(*q, x.(*graphItem)) }
+func (q *graphQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// Report is a plain text list of the segments in the plan.
+func (p *Plan) Report() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "%s route from %.5f, %.5f to %.5f, %.5f\n", strings.Title(modeName(p.Mode)), p.From.Lat, p.From.Lon, p.To.Lat, p.To.Lon)
+	fmt.Fprintf(sb, "%.1f km, about %.1f hours\n", p.Length, p.Hours)
+	if p.Offset[0] > globals.DELTA || p.Offset[1] > globals.DELTA {
+		fmt.Fprintf(sb, "The start is %.1f km and the end is %.1f km from the nearest route\n", p.Offset[0], p.Offset[1])
+	}
+	fmt.Fprintln(sb)
+	var chainage float64
+	for _, step := range p.Steps {
+		fmt.Fprintf(sb, "%7.1f km  %s (%s)  %.1f km\n", chainage, step.Segment.PlacemarkName(), step.Segment.Route.Debug(), step.Length)
+		chainage += step.Length
+	}
+	return sb.String()
+}
+
+// Save writes the plan as a GPX route and track.
+func (p *Plan) Save(dpath string) error {
+	name := fmt.Sprintf("GPT %s route %.4f,%.4f to %.4f,%.4f", modeName(p.Mode), p.From.Lat, p.From.Lon, p.To.Lat, p.To.Lon)
+	line := p.Line()
+	root := gpx.Root{
+		Version: 1.1,
+		Routes:  []gpx.Route{{Name: name, Desc: p.Report(), Points: gpx.LinePoints(line)}},
+		Tracks:  []gpx.Track{{Name: name, Desc: p.Report(), Segments: []gpx.TrackSegment{{Points: gpx.LineTrackPoints(line)}}}},
+	}
+	if err := root.Save(filepath.Join(dpath, name+".gpx")); err != nil {
+		return fmt.Errorf("writing route gpx: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package routedata
+
+import (
+	"math"
+	"testing"
+
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/globals"
+)
+
+// testGraphData is a regular route heading east for 4 km in two segments, and an option that leaves the middle of the
+// first segment at 1 km, runs 1 km to the south for 2 km, and rejoins the middle of the second segment at 3 km. A loop
+// trail leaves the middle of the option and returns to the same place.
+func testGraphData(t *testing.T) (d *Data, regular, option *Route) {
+	t.Helper()
+	regular = testRoute(t, RouteKey{Required: globals.REGULAR},
+		geo.Line{offset(0, 0), offset(1, 0), offset(2, 0)},
+		geo.Line{offset(2, 0), offset(3, 0), offset(4, 0)},
+	)
+	option = testRoute(t, RouteKey{Required: globals.OPTIONAL, Option: 1},
+		geo.Line{offset(1, 0), offset(1, 1), offset(2, 1), offset(3, 1), offset(3, 0)},
+		geo.Line{offset(3, 1), offset(3.5, 1.5), offset(3, 2), offset(2.5, 1.5), offset(3, 1)},
+	)
+	return testSectionData(t, regular, option), regular, option
+}
+
+// edgesLength is the total length of the graph edges of a segment.
+func edgesLength(g *Graph, segment *Segment) (length float64, edges int) {
+	for _, edge := range g.Edges {
+		if edge.Segment == segment {
+			length += edge.Length
+			edges++
+		}
+	}
+	return length, edges
+}
+
+func TestBuildGraph(t *testing.T) {
+	d, regular, option := testGraphData(t)
+	g := d.BuildGraph(globals.HIKE)
+	// the regular segments are split where the option joins, the option where the loop joins, and the loop in the
+	// middle
+	for _, test := range []struct {
+		segment *Segment
+		edges   int
+	}{
+		{regular.Modes[globals.HIKE].Segments[0], 2},
+		{regular.Modes[globals.HIKE].Segments[1], 2},
+		{option.Modes[globals.HIKE].Segments[0], 2},
+		{option.Modes[globals.HIKE].Segments[1], 2},
+	} {
+		length, edges := edgesLength(g, test.segment)
+		if edges != test.edges {
+			t.Errorf("%s: expected %d edges, found %d", test.segment.Raw, test.edges, edges)
+		}
+		if math.Abs(length-test.segment.Line.Length()) > 0.001 {
+			t.Errorf("%s: expected edges of %.3f km, found %.3f km", test.segment.Raw, test.segment.Line.Length(), length)
+		}
+	}
+	// the ends of the regular segments, the two joins with the regular route, the loop's node and the middle of the loop
+	if len(g.Nodes) != 7 {
+		t.Errorf("expected 7 nodes, found %d", len(g.Nodes))
+	}
+	for _, edge := range g.Edges {
+		if edge.From == edge.To {
+			t.Errorf("%s: edge from a node to itself", edge.Segment.Raw)
+		}
+	}
+}
+
+func TestBuildGraphLollipop(t *testing.T) {
+	// a stick heading south for 1 km, then a loop back to the end of the stick
+	lollipop := testRoute(t, RouteKey{Required: globals.REGULAR},
+		geo.Line{offset(0, 0), offset(0, 1), offset(0.5, 1.5), offset(0, 2), offset(-0.5, 1.5), offset(0, 1)},
+	)
+	d := &Data{Keys: []globals.SectionKey{lollipop.Section.Key}, Sections: map[globals.SectionKey]*Section{lollipop.Section.Key: lollipop.Section}}
+	lollipop.Section.RouteKeys = []RouteKey{lollipop.Key}
+	lollipop.Section.Routes[lollipop.Key] = lollipop
+	g := d.BuildGraph(globals.HIKE)
+	segment := lollipop.Modes[globals.HIKE].Segments[0]
+	length, edges := edgesLength(g, segment)
+	if edges != 3 {
+		t.Errorf("expected the stick and two halves of the loop, found %d edges", edges)
+	}
+	if math.Abs(length-segment.Line.Length()) > 0.001 {
+		t.Errorf("expected edges of %.3f km, found %.3f km", segment.Line.Length(), length)
+	}
+	plan, err := g.Route(offset(0, 0), offset(0, 2), RoutingCost{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 1 + 2*math.Hypot(0.5, 0.5); math.Abs(plan.Length-expected) > 0.01 {
+		t.Errorf("expected %.3f km, found %.3f km", expected, plan.Length)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	optionLength := 4.0
+	tests := []struct {
+		name     string
+		setup    func(regular, option *Route)
+		from, to geo.Pos
+		cost     RoutingCost
+		length   float64 // km
+		option   bool    // the plan uses the option
+		offset   float64 // km from the start and end to the graph
+	}{
+		{name: "regular", from: offset(0, 0), to: offset(4, 0), length: 4},
+		{name: "reversed", from: offset(4, 0), to: offset(0, 0), length: 4},
+		{name: "off the route", from: offset(0.5, 0.1), to: offset(3.5, 0.1), length: 3, offset: 0.1},
+		{name: "along one edge", from: offset(0.2, 0), to: offset(0.8, 0), length: 0.6},
+		{name: "along one edge reversed", from: offset(0.8, 0), to: offset(0.2, 0), length: 0.6},
+		{name: "to the option", from: offset(0, 0), to: offset(2, 1), length: 3, option: true},
+		{name: "around the loop", from: offset(0, 0), to: offset(3, 2), length: 4 + 2*math.Hypot(0.5, 0.5), option: true},
+		{
+			name: "time",
+			// the regular route takes 2.5 hours with 2 km of boulders, and the option 2.25 hours with 1 km of boulders
+			setup: func(regular, option *Route) { regular.Modes[globals.HIKE].Segments[1].Terrains = []string{"BB"} },
+			from:  offset(0, 0), to: offset(4, 0), cost: RoutingCost{Time: true},
+			length: 2 + optionLength, option: true,
+		},
+		{
+			name:  "distance ignores terrain",
+			setup: func(regular, option *Route) { regular.Modes[globals.HIKE].Segments[1].Terrains = []string{"BB"} },
+			from:  offset(0, 0), to: offset(4, 0),
+			length: 4,
+		},
+		{
+			name:  "avoid terrain",
+			setup: func(regular, option *Route) { regular.Modes[globals.HIKE].Segments[1].Terrains = []string{"BB"} },
+			from:  offset(0, 0), to: offset(4, 0), cost: RoutingCost{Avoid: []string{"BB"}},
+			length: 2 + optionLength, option: true,
+		},
+		{
+			name:  "avoid investigation",
+			setup: func(regular, option *Route) { regular.Modes[globals.HIKE].Segments[1].Verification = "I" },
+			from:  offset(0, 0), to: offset(4, 0), cost: RoutingCost{AvoidInvestigation: true},
+			length: 2 + optionLength, option: true,
+		},
+		{
+			name:  "investigation not avoided",
+			setup: func(regular, option *Route) { regular.Modes[globals.HIKE].Segments[1].Verification = "I" },
+			from:  offset(0, 0), to: offset(4, 0),
+			length: 4,
+		},
+		{
+			name: "one-way",
+			setup: func(regular, option *Route) {
+				regular.Modes[globals.HIKE].Segments[1].Terrains = []string{"BB"}
+				option.Modes[globals.HIKE].Segments[0].Directional = "1"
+			},
+			from: offset(0, 0), to: offset(4, 0), cost: RoutingCost{Time: true},
+			length: 2 + optionLength, option: true,
+		},
+		{
+			name: "one-way the wrong way",
+			setup: func(regular, option *Route) {
+				regular.Modes[globals.HIKE].Segments[1].Terrains = []string{"BB"}
+				option.Modes[globals.HIKE].Segments[0].Directional = "1"
+			},
+			from: offset(4, 0), to: offset(0, 0), cost: RoutingCost{Time: true},
+			length: 4,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, regular, option := testGraphData(t)
+			if test.setup != nil {
+				test.setup(regular, option)
+			}
+			plan, err := d.BuildGraph(globals.HIKE).Route(test.from, test.to, test.cost, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(plan.Length-test.length) > 0.01 {
+				t.Errorf("expected %.3f km, found %.3f km", test.length, plan.Length)
+			}
+			var usesOption bool
+			var length float64
+			for _, step := range plan.Steps {
+				if step.Segment.Route == option {
+					usesOption = true
+				}
+				length += step.Line.Length()
+			}
+			if usesOption != test.option {
+				t.Errorf("expected option used %v, found %v", test.option, usesOption)
+			}
+			if math.Abs(length-plan.Length) > 0.01 {
+				t.Errorf("expected steps of %.3f km, found %.3f km", plan.Length, length)
+			}
+			line := plan.Line()
+			if km := line.Start().Distance(test.from); math.Abs(km-test.offset) > 0.01 {
+				t.Errorf("starts %.3f km from the start", km)
+			}
+			if km := line.End().Distance(test.to); math.Abs(km-test.offset) > 0.01 {
+				t.Errorf("ends %.3f km from the end", km)
+			}
+			for i := range plan.Offset {
+				if math.Abs(plan.Offset[i]-test.offset) > 0.01 {
+					t.Errorf("expected offset %.3f km, found %.3f km", test.offset, plan.Offset[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,610 @@
+package routedata
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/globals"
+	"github.com/dave/gpt/gpx"
+	"github.com/dave/gpt/kml"
+	"gopkg.in/yaml.v2"
+)
+
+// ItinerarySpec is a trip plan, read from a YAML file, e.g.
+//
+//	name: Cochamó to Puelo
+//	mode: hiking
+//	legs:
+//	  - section: 24
+//	    options: [3B]
+//	    skip: [FY]
+//	  - section: 25
+//	    mode: packrafting
+type ItinerarySpec struct {
+	Name      string             `yaml:"name"`
+	Mode      string             `yaml:"mode"`      // "hiking" or "packrafting", the default for each leg
+	Direction string             `yaml:"direction"` // "S" or "N", the default for each leg. Southbound if omitted.
+	Legs      []ItineraryLegSpec `yaml:"legs"`
+}
+
+// ItineraryLegSpec is the regular route of one section, with options spliced in.
+type ItineraryLegSpec struct {
+	Section   string   `yaml:"section"` // section code e.g. "24", "24H"
+	Mode      string   `yaml:"mode"`
+	Direction string   `yaml:"direction"`
+	Options   []string `yaml:"options"` // option and variant keys e.g. "3", "3B", "A". Include the network letter to only use one network e.g. "3Ba".
+	Skip      []string `yaml:"skip"`    // terrain codes to leave out e.g. "FY" to skip ferries
+}
+
+// LoadItinerarySpec reads an itinerary spec from a YAML file. If the spec has no name, the file name is used.
+func LoadItinerarySpec(fpath string) (*ItinerarySpec, error) {
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("reading itinerary: %w", err)
+	}
+	spec := &ItinerarySpec{}
+	if err := yaml.UnmarshalStrict(b, spec); err != nil {
+		return nil, fmt.Errorf("parsing itinerary: %w", err)
+	}
+	if spec.Name == "" {
+		spec.Name = strings.TrimSuffix(filepath.Base(fpath), filepath.Ext(fpath))
+	}
+	return spec, nil
+}
+
+// Itinerary is a continuous route built from an ItinerarySpec.
+type Itinerary struct {
+	Name   string
+	Legs   []*ItineraryLeg
+	Gaps   []*ItineraryGap
+	Length float64 // km
+}
+
+// ItineraryLeg is the path through one section.
+type ItineraryLeg struct {
+	Section   *Section
+	Mode      globals.ModeType
+	Direction string
+	Routes    []*Route // the regular route, followed by the options spliced in
+	Parts     []*ItineraryPart
+	Flushes   []*Flush // chainage is from the start of the itinerary
+	From      float64  // km
+	Length    float64  // km
+	// One-way segments that are travelled against their direction.
+	Violations []*Segment
+}
+
+// ItineraryPart is a segment, or the part of a segment between junctions, in the direction of travel.
+type ItineraryPart struct {
+	Segment *Segment
+	Line    geo.Line
+	Along   bool // the line is in the direction of the segment line
+	Skipped bool // the terrain is in the skip list, so it's not part of the itinerary
+}
+
+// ItineraryGap is where the end of one leg doesn't meet the start of the next.
+type ItineraryGap struct {
+	From, To *ItineraryLeg
+	Distance float64 // km
+}
+
+var optionKeyRegex = regexp.MustCompile(`^(\d*)([A-Z]{0,2})([a-z]?)$`)
+
+// Itinerary builds the itinerary for a spec. Options are spliced into the regular route at the nodes where they leave
+// and rejoin it, following the shortest path through the option in the direction of travel. If the option doesn't
+// rejoin the regular route, the rest of the regular route is replaced.
+func (d *Data) Itinerary(spec *ItinerarySpec) (*Itinerary, error) {
+	it := &Itinerary{Name: spec.Name}
+	for i, legSpec := range spec.Legs {
+		leg, err := d.itineraryLeg(spec, legSpec)
+		if err != nil {
+			return nil, fmt.Errorf("leg %d (GPT%s): %w", i+1, legSpec.Section, err)
+		}
+		leg.From = it.Length
+		var chainage = it.Length
+		for _, part := range leg.Parts {
+			if part.Skipped {
+				continue
+			}
+			length := part.Line.Length()
+			if len(leg.Flushes) > 0 {
+				last := leg.Flushes[len(leg.Flushes)-1]
+				if last.Segments[len(last.Segments)-1].Similar(part.Segment) {
+					last.Length += length
+					chainage += length
+					if last.Segments[len(last.Segments)-1] != part.Segment {
+						last.Segments = append(last.Segments, part.Segment)
+					}
+					continue
+				}
+			}
+			leg.Flushes = append(leg.Flushes, &Flush{
+				From:         chainage,
+				Length:       length,
+				Terrains:     part.Segment.Terrains,
+				Verification: part.Segment.Verification,
+				Directional:  part.Segment.Directional,
+				Experimental: part.Segment.Experimental,
+				Segments:     []*Segment{part.Segment},
+			})
+			chainage += length
+		}
+		for _, flush := range leg.Flushes {
+			names := map[string]bool{}
+			for _, segment := range flush.Segments {
+				if segment.Name != "" && !names[segment.Name] {
+					names[segment.Name] = true
+					flush.Names = append(flush.Names, segment.Name)
+				}
+			}
+		}
+		leg.Length = chainage - it.Length
+		it.Length = chainage
+		if len(it.Legs) > 0 {
+			previous := it.Legs[len(it.Legs)-1]
+			end := previous.Parts[len(previous.Parts)-1].Line.End()
+			if distance := end.Distance(leg.Parts[0].Line.Start()); distance > globals.DELTA {
+				it.Gaps = append(it.Gaps, &ItineraryGap{From: previous, To: leg, Distance: distance})
+			}
+		}
+		it.Legs = append(it.Legs, leg)
+	}
+	return it, nil
+}
+
+func (d *Data) itineraryLeg(spec *ItinerarySpec, legSpec ItineraryLegSpec) (*ItineraryLeg, error) {
+	key, err := NewSectionKey(legSpec.Section)
+	if err != nil {
+		return nil, fmt.Errorf("parsing section %q: %w", legSpec.Section, err)
+	}
+	section := d.Sections[key]
+	if section == nil {
+		return nil, fmt.Errorf("section not found")
+	}
+	modeString := legSpec.Mode
+	if modeString == "" {
+		modeString = spec.Mode
+	}
+	var mode globals.ModeType
+	switch modeString {
+	case "", "hiking":
+		mode = globals.HIKE
+	case "packrafting":
+		mode = globals.RAFT
+	default:
+		return nil, fmt.Errorf("unknown mode %q", modeString)
+	}
+	direction := legSpec.Direction
+	if direction == "" {
+		direction = spec.Direction
+	}
+	switch direction {
+	case "":
+		direction = "S"
+	case "S", "N":
+	default:
+		return nil, fmt.Errorf("unknown direction %q", direction)
+	}
+	skip := map[string]bool{}
+	for _, terrain := range legSpec.Skip {
+		skip[terrain] = true
+	}
+
+	leg := &ItineraryLeg{Section: section, Mode: mode, Direction: direction}
+
+	// the regular route for the direction if there is one
+	var regular *Route
+	for _, routeKey := range section.RouteKeys {
+		if routeKey.Required != globals.REGULAR || section.Routes[routeKey].Modes[mode] == nil {
+			continue
+		}
+		if routeKey.Direction == direction || regular == nil {
+			regular = section.Routes[routeKey]
+		}
+	}
+	if regular == nil {
+		return nil, fmt.Errorf("no regular %s route", modeName(mode))
+	}
+	leg.Routes = append(leg.Routes, regular)
+	for _, segment := range regular.Modes[mode].Segments {
+		leg.Parts = append(leg.Parts, &ItineraryPart{Segment: segment, Line: segment.Line, Along: true})
+	}
+	reverse := direction == "N" && regular.Key.Direction != "N"
+
+	for _, option := range legSpec.Options {
+		matches := optionKeyRegex.FindStringSubmatch(option)
+		if matches == nil || (matches[1] == "" && matches[2] == "") {
+			return nil, fmt.Errorf("parsing option %q", option)
+		}
+		var number int
+		if matches[1] != "" {
+			number, _ = strconv.Atoi(matches[1])
+		}
+		var found bool
+		for _, routeKey := range section.RouteKeys {
+			route := section.Routes[routeKey]
+			if routeKey.Required != globals.OPTIONAL || routeKey.Alternatives || route.Modes[mode] == nil {
+				continue
+			}
+			if routeKey.Option != number || routeKey.Variant != matches[2] || (matches[3] != "" && routeKey.Network != matches[3]) {
+				continue
+			}
+			if routeKey.Direction != "" && routeKey.Direction != direction {
+				continue
+			}
+			if err := leg.splice(route, reverse); err != nil {
+				return nil, fmt.Errorf("splicing %s: %w", routeKey.Debug(), err)
+			}
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("no %s option %q", modeName(mode), option)
+		}
+	}
+
+	if reverse {
+		for i, j := 0, len(leg.Parts)-1; i < j; i, j = i+1, j-1 {
+			leg.Parts[i], leg.Parts[j] = leg.Parts[j], leg.Parts[i]
+		}
+		for _, part := range leg.Parts {
+			part.Line = reversedLine(part.Line)
+			part.Along = !part.Along
+		}
+	}
+	for _, part := range leg.Parts {
+		if len(part.Segment.Terrains) == 0 {
+			continue
+		}
+		part.Skipped = true
+		for _, terrain := range part.Segment.Terrains {
+			if !skip[terrain] {
+				part.Skipped = false
+			}
+		}
+	}
+	violations := map[*Segment]bool{}
+	for _, part := range leg.Parts {
+		if part.Skipped || violations[part.Segment] {
+			continue
+		}
+		if !part.Segment.Route.Modes[mode].Network.allowedAlong(part.Segment, part.Along) {
+			violations[part.Segment] = true
+			leg.Violations = append(leg.Violations, part.Segment)
+		}
+	}
+	return leg, nil
+}
+
+// splice replaces the part of the leg between the junctions with the option. The option is the shortest path through
+// its network between the junction nodes, found in the direction of travel (against the leg if reverse is true) so
+// one-way segments are followed the right way if possible. If the option doesn't rejoin the leg, the rest of the leg
+// is replaced by the path to the furthest node of the option.
+func (leg *ItineraryLeg) splice(route *Route, reverse bool) error {
+	network := route.Modes[leg.Mode].Network
+	depart, rejoin := leg.junction(network)
+	if depart == nil {
+		return fmt.Errorf("doesn't join the route (options that branch from other options must be listed after them)")
+	}
+	end := rejoin
+	if end == nil {
+		// the furthest node from the junction
+		paths := network.shortestPaths(network.ShortEdges, depart, nil)
+		for _, node := range network.Nodes {
+			path, ok := paths[node]
+			if !ok {
+				// not reachable from the junction
+				continue
+			}
+			if end == nil || path.Length > paths[end].Length {
+				end = node
+			}
+		}
+	}
+	// the path may travel a one-way segment the wrong way if there's no other path, which is reported as a violation
+	var option []*ItineraryPart
+	if reverse {
+		path, _ := network.path(end, depart)
+		if path == nil {
+			return fmt.Errorf("option doesn't connect to the junction")
+		}
+		option = pathParts(path)
+		// the leg is in the direction of the route until it's reversed
+		for i, j := 0, len(option)-1; i < j; i, j = i+1, j-1 {
+			option[i], option[j] = option[j], option[i]
+		}
+		for _, part := range option {
+			part.Line = reversedLine(part.Line)
+			part.Along = !part.Along
+		}
+	} else {
+		path, _ := network.path(depart, end)
+		if path == nil {
+			return fmt.Errorf("option doesn't connect to the junction")
+		}
+		option = pathParts(path)
+	}
+
+	_, startPart, startProj := leg.projectNode(depart)
+	var parts []*ItineraryPart
+	parts = append(parts, leg.Parts[:startPart]...)
+	if before := leg.Parts[startPart].before(startProj); before != nil {
+		parts = append(parts, before)
+	}
+	parts = append(parts, option...)
+	if rejoin != nil {
+		_, endPart, endProj := leg.projectNode(rejoin)
+		if after := leg.Parts[endPart].after(endProj); after != nil {
+			parts = append(parts, after)
+		}
+		parts = append(parts, leg.Parts[endPart+1:]...)
+	}
+	leg.Parts = parts
+	leg.Routes = append(leg.Routes, route)
+	return nil
+}
+
+// junction finds the nodes of an option network where it leaves and rejoins the leg, in the order of the leg. These are
+// the junction nodes of the option (see Network.BuildJunction) if they're on the leg. Otherwise (e.g. for options that
+// branch from other options) they're the first and last nodes of the option on the leg. Rejoin is nil if the option
+// only joins the leg once, and both are nil if it doesn't join.
+func (leg *ItineraryLeg) junction(network *Network) (depart, rejoin *Node) {
+	on := func(node *Node) bool {
+		_, _, proj := leg.projectNode(node)
+		return proj.Distance <= globals.DELTA
+	}
+	if j := network.Junction; j != nil && j.Departs && j.Regular == leg.Routes[0] && on(j.DepartNode) && (!j.Rejoins || on(j.RejoinNode)) {
+		depart, rejoin = j.DepartNode, j.RejoinNode
+	} else {
+		attached := network.attachments(func(pos geo.Pos) (float64, float64) {
+			along, _, proj := leg.project(pos)
+			return along, proj.Distance
+		})
+		if len(attached) == 0 {
+			return nil, nil
+		}
+		depart = attached[0].node
+		if len(attached) > 1 {
+			rejoin = attached[len(attached)-1].node
+		}
+	}
+	if rejoin != nil {
+		departAlong, _, _ := leg.projectNode(depart)
+		rejoinAlong, _, _ := leg.projectNode(rejoin)
+		if rejoinAlong < departAlong {
+			depart, rejoin = rejoin, depart
+		}
+	}
+	return depart, rejoin
+}
+
+// pathParts are the parts of the segments along a path through a network, in the direction of the path.
+func pathParts(path *Path) []*ItineraryPart {
+	var parts []*ItineraryPart
+	from := path.From
+	for _, edge := range path.Edges {
+		first, last := edge.Points[0].Index, edge.Points[1].Index
+		if edge.Nodes[0] != from {
+			first, last = last, first
+		}
+		along := first < last
+		var line geo.Line
+		if along {
+			line = append(geo.Line{}, edge.Segment.Line[first:last+1]...)
+		} else {
+			line = reversedLine(edge.Segment.Line[last : first+1])
+		}
+		from = edge.Opposite(from)
+		if len(parts) > 0 {
+			// continue the previous part if the path continues along the same segment
+			previous := parts[len(parts)-1]
+			if previous.Segment == edge.Segment && previous.Along == along {
+				previous.Line = append(previous.Line, line[1:]...)
+				continue
+			}
+		}
+		parts = append(parts, &ItineraryPart{Segment: edge.Segment, Line: line, Along: along})
+	}
+	return parts
+}
+
+// projectNode projects the point of a node that's nearest to the leg.
+func (leg *ItineraryLeg) projectNode(node *Node) (float64, int, geo.Projection) {
+	var best geo.Projection
+	var bestIndex int
+	var bestAlong float64
+	for i, point := range node.Points {
+		along, index, proj := leg.project(point.Pos)
+		if i == 0 || proj.Distance < best.Distance {
+			best, bestIndex, bestAlong = proj, index, along
+		}
+	}
+	return bestAlong, bestIndex, best
+}
+
+// project finds the nearest position on the leg to pos, and returns the distance along the leg, the index of the part
+// and the projection onto that part.
+func (leg *ItineraryLeg) project(pos geo.Pos) (float64, int, geo.Projection) {
+	var best geo.Projection
+	var bestIndex int
+	var bestAlong, from float64
+	for i, part := range leg.Parts {
+		proj := part.Line.Project(pos)
+		if i == 0 || proj.Distance < best.Distance {
+			best, bestIndex, bestAlong = proj, i, from+part.Line.Along(proj)
+		}
+		from += part.Line.Length()
+	}
+	return bestAlong, bestIndex, best
+}
+
+// before is the part of the line up to the projected position, or nil if it's too short to keep.
+func (p *ItineraryPart) before(proj geo.Projection) *ItineraryPart {
+	line := append(append(geo.Line{}, p.Line[:proj.Index+1]...), proj.Pos)
+	if line.Length() < globals.DELTA/10 {
+		return nil
+	}
+	return &ItineraryPart{Segment: p.Segment, Line: line, Along: p.Along}
+}
+
+// after is the part of the line from the projected position, or nil if it's too short to keep.
+func (p *ItineraryPart) after(proj geo.Projection) *ItineraryPart {
+	line := append(geo.Line{proj.Pos}, p.Line[proj.Index+1:]...)
+	if line.Length() < globals.DELTA/10 {
+		return nil
+	}
+	return &ItineraryPart{Segment: p.Segment, Line: line, Along: p.Along}
+}
+
+func reversedLine(l geo.Line) geo.Line {
+	r := append(geo.Line{}, l...)
+	r.Reverse()
+	return r
+}
+
+// Lines are the continuous stretches of the leg, split where parts are skipped or don't join.
+func (leg *ItineraryLeg) Lines() []geo.Line {
+	var lines []geo.Line
+	var current geo.Line
+	for _, part := range leg.Parts {
+		if part.Skipped {
+			if len(current) > 0 {
+				lines = append(lines, current)
+			}
+			current = nil
+			continue
+		}
+		if len(current) > 0 && !current.End().IsClose(part.Line.Start(), globals.DELTA) {
+			lines = append(lines, current)
+			current = nil
+		}
+		if len(current) > 0 {
+			current = append(current, part.Line[1:]...)
+		} else {
+			current = append(current, part.Line...)
+		}
+	}
+	if len(current) > 0 {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// Name is the section and options of the leg e.g. "GPT24 hiking + option 3B".
+func (leg *ItineraryLeg) Name() string {
+	name := fmt.Sprintf("GPT%s %s", leg.Section.Key.Code(), modeName(leg.Mode))
+	if leg.Direction == "N" {
+		name += " northbound"
+	}
+	for _, route := range leg.Routes[1:] {
+		name += " + " + route.Key.Debug()
+	}
+	return name
+}
+
+// Description is the combined flush descriptions of the leg.
+func (leg *ItineraryLeg) Description() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "%s %s\n%.1f km from the start, %.1f km long\n\n", H1_SYMBOL, leg.Name(), leg.From, leg.Length)
+	for i, flush := range leg.Flushes {
+		fmt.Fprintln(sb, flush.Description(i+1, false))
+	}
+	return sb.String()
+}
+
+// SaveItinerary writes the itinerary as a GPX file with one track per leg (or more if the leg doesn't fit within the
+// maximum points), and a KML file with one placemark per continuous stretch of each leg.
+func (d *Data) SaveItinerary(dpath string, it *Itinerary, simplify geo.Simplification) error {
+	root := gpx.Root{Version: 1.1}
+	folder := &kml.Folder{Name: it.Name, Visibility: 1, Open: 1}
+	for _, leg := range it.Legs {
+		groups := simplify.ApplyAll(leg.Lines())
+		var lines []geo.Line
+		for i, group := range groups {
+			trk := gpx.Track{Name: leg.Name(), Desc: leg.Description()}
+			if len(groups) > 1 {
+				trk.Name += fmt.Sprintf(" %d/%d", i+1, len(groups))
+			}
+			for _, line := range group {
+				trk.Segments = append(trk.Segments, gpx.TrackSegment{Points: gpx.LineTrackPoints(line)})
+				lines = append(lines, line)
+			}
+			root.Tracks = append(root.Tracks, trk)
+		}
+		for i, line := range lines {
+			name := leg.Name()
+			if len(lines) > 1 {
+				name += fmt.Sprintf(" %d/%d", i+1, len(lines))
+			}
+			folder.Placemarks = append(folder.Placemarks, &kml.Placemark{
+				Name:        name,
+				Description: leg.Description(),
+				Visibility:  1,
+				StyleUrl:    "#itinerary",
+				LineString: &kml.LineString{
+					Tessellate:  true,
+					Coordinates: kml.LineCoordinates(line),
+				},
+			})
+		}
+	}
+	name := fileName(it.Name)
+	if err := root.Save(filepath.Join(dpath, name+".gpx")); err != nil {
+		return fmt.Errorf("writing itinerary gpx: %w", err)
+	}
+	doc := kml.Root{
+		Xmlns: "http://www.opengis.net/kml/2.2",
+		Document: kml.Document{
+			Name:       it.Name,
+			Visibility: 1,
+			Open:       1,
+			Styles: []*kml.Style{
+				{Id: "itinerary", LineStyle: &kml.LineStyle{Color: "ff0000ff", Width: 4}},
+			},
+			Folders: []*kml.Folder{folder},
+		},
+	}
+	if err := doc.Save(filepath.Join(dpath, name+".kml")); err != nil {
+		return fmt.Errorf("writing itinerary kml: %w", err)
+	}
+	return nil
+}
+
+// fileName makes a name from a spec safe to use as a file name in the output directory, so it can't contain a path.
+func fileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':':
+			return '-'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		return "itinerary"
+	}
+	return name
+}
+
+// Report is a plain text list of the legs and gaps.
+func (it *Itinerary) Report() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "%s: %.1f km\n\n", it.Name, it.Length)
+	for _, leg := range it.Legs {
+		fmt.Fprintf(sb, "%7.1f km  %s  %.1f km\n", leg.From, leg.Name(), leg.Length)
+		for _, segment := range leg.Violations {
+			fmt.Fprintf(sb, "           %s One-way segment travelled against its direction: %s\n", WARNING_SYMBOL, segment.PlacemarkName())
+		}
+	}
+	for _, gap := range it.Gaps {
+		fmt.Fprintf(sb, "\nGap of %.2f km between %s and %s", gap.Distance, gap.From.Name(), gap.To.Name())
+	}
+	if len(it.Gaps) > 0 {
+		fmt.Fprintln(sb)
+	}
+	return sb.String()
+}
@@ -0,0 +1,188 @@
+package routedata
+
+import (
+	"math"
+	"testing"
+
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/globals"
+)
+
+// testItineraryData is a section with a regular route heading east for 4 km, and an option that leaves at 0.5 km and
+// rejoins at 2 km. The option has a dead end branch, and the stretch between the junctions is a one-way trail drawn
+// west.
+func testItineraryData(t *testing.T) (d *Data, branch *Segment) {
+	t.Helper()
+	var line geo.Line
+	for east := 0.0; east <= 4; east += 0.5 {
+		line = append(line, offset(east, 0))
+	}
+	regular := testRoute(t, RouteKey{Required: globals.REGULAR}, line[:5], line[4:])
+	option := testRoute(t, RouteKey{Required: globals.OPTIONAL, Option: 1},
+		geo.Line{offset(2, 0), offset(2, 0.5)},
+		geo.Line{offset(2, 0.5), offset(1, 0.5), offset(0.5, 0.5)},
+		geo.Line{offset(0.5, 0.5), offset(0.5, 0)},
+		geo.Line{offset(1, 0.5), offset(1, 3.5)},
+	)
+	option.Modes[globals.HIKE].Segments[1].Directional = "1"
+	return testSectionData(t, regular, option), option.Modes[globals.HIKE].Segments[3]
+}
+
+// testSectionData puts the routes in the section of the regular route, normalises their networks and finds the
+// junctions of the options.
+func testSectionData(t *testing.T, regular *Route, options ...*Route) *Data {
+	t.Helper()
+	section := regular.Section
+	for _, route := range append([]*Route{regular}, options...) {
+		route.Section = section
+		section.RouteKeys = append(section.RouteKeys, route.Key)
+		section.Routes[route.Key] = route
+		if err := route.Modes[globals.HIKE].Network.Normalise(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, option := range options {
+		option.Modes[globals.HIKE].Network.BuildJunction([]*Route{regular})
+	}
+	return &Data{Keys: []globals.SectionKey{section.Key}, Sections: map[globals.SectionKey]*Section{section.Key: section}}
+}
+
+func TestItinerary(t *testing.T) {
+	d, branch := testItineraryData(t)
+	tests := []struct {
+		name       string
+		direction  string
+		options    []string
+		start, end geo.Pos
+		length     float64
+		violations int
+	}{
+		{"regular", "S", nil, offset(0, 0), offset(4, 0), 4, 0},
+		{"regular northbound", "N", nil, offset(4, 0), offset(0, 0), 4, 0},
+		// the one-way stretch of the option is travelled the wrong way southbound
+		{"option", "S", []string{"1"}, offset(0, 0), offset(4, 0), 5, 1},
+		{"option northbound", "N", []string{"1"}, offset(4, 0), offset(0, 0), 5, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			it, err := d.Itinerary(&ItinerarySpec{
+				Name:      test.name,
+				Direction: test.direction,
+				Legs:      []ItineraryLegSpec{{Section: "01", Options: test.options}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(it.Length-test.length) > 0.001 {
+				t.Errorf("expected %.3f km, found %.3f km", test.length, it.Length)
+			}
+			leg := it.Legs[0]
+			lines := leg.Lines()
+			if len(lines) != 1 {
+				t.Fatalf("expected one continuous line, found %d", len(lines))
+			}
+			if km := lines[0].Start().Distance(test.start); km > 0.001 {
+				t.Errorf("starts %.3f km from the start of the route", km)
+			}
+			if km := lines[0].End().Distance(test.end); km > 0.001 {
+				t.Errorf("ends %.3f km from the end of the route", km)
+			}
+			for _, part := range leg.Parts {
+				if part.Segment == branch {
+					t.Errorf("the dead end branch is part of the itinerary")
+				}
+			}
+			if len(leg.Violations) != test.violations {
+				t.Errorf("expected %d one-way violations, found %d", test.violations, len(leg.Violations))
+			}
+		})
+	}
+}
+
+// disconnect removes the short edges of a segment from its network, splitting the network. BuildNetworks rejects routes
+// in pieces, so this is the only way to test that the search copes with nodes it can't reach.
+func disconnect(segment *Segment) {
+	network := segment.Route.Modes[globals.HIKE].Network
+	for node, edges := range network.ShortEdges {
+		var kept []*Edge
+		for _, edge := range edges {
+			if edge.Segment != segment {
+				kept = append(kept, edge)
+			}
+		}
+		network.ShortEdges[node] = kept
+	}
+}
+
+func TestItineraryDisconnectedOption(t *testing.T) {
+	line := geo.Line{offset(0, 0), offset(1, 0), offset(2, 0), offset(3, 0), offset(4, 0)}
+	tests := []struct {
+		name   string
+		lines  []geo.Line // the last line connects the pieces, and is removed once the network is built
+		length float64    // km, or 0 if the option can't be spliced
+	}{
+		{
+			// the pieces join the route at 1 km and 3 km, but not each other
+			name: "pieces join at each end",
+			lines: []geo.Line{
+				{offset(1, 0), offset(1, 1), offset(1.5, 1)},
+				{offset(2.5, 1), offset(3, 1), offset(3, 0)},
+				{offset(1.5, 1), offset(2.5, 1)},
+			},
+		},
+		{
+			// the spur leaves at 1 km, and the other piece doesn't join anything
+			name: "detached piece",
+			lines: []geo.Line{
+				{offset(1, 0), offset(1, 2)},
+				{offset(3, 1), offset(3, 2), offset(3, 3)},
+				{offset(1, 2), offset(3, 2)},
+			},
+			length: 3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			regular := testRoute(t, RouteKey{Required: globals.REGULAR}, line)
+			option := testRoute(t, RouteKey{Required: globals.OPTIONAL, Option: 1}, test.lines...)
+			d := testSectionData(t, regular, option)
+			segments := option.Modes[globals.HIKE].Segments
+			disconnect(segments[len(segments)-1])
+			option.Modes[globals.HIKE].Network.BuildJunction([]*Route{regular})
+			for _, direction := range []string{"S", "N"} {
+				it, err := d.Itinerary(&ItinerarySpec{
+					Name:      test.name,
+					Direction: direction,
+					Legs:      []ItineraryLegSpec{{Section: "01", Options: []string{"1"}}},
+				})
+				if test.length == 0 {
+					if err == nil {
+						t.Errorf("%s: expected an error", direction)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%s: %v", direction, err)
+				}
+				if math.Abs(it.Length-test.length) > 0.001 {
+					t.Errorf("%s: expected %.3f km, found %.3f km", direction, test.length, it.Length)
+				}
+			}
+		})
+	}
+}
+
+func TestFileName(t *testing.T) {
+	for _, test := range []struct{ name, expected string }{
+		{"Trip", "Trip"},
+		{"GPT 1-10", "GPT 1-10"},
+		{"../../etc/passwd", "-..-etc-passwd"},
+		{"a/b\\c", "a-b-c"},
+		{"..", "itinerary"},
+		{"", "itinerary"},
+	} {
+		if found := fileName(test.name); found != test.expected {
+			t.Errorf("%q: expected %q, found %q", test.name, test.expected, found)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package routedata
+
+import (
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/globals"
+)
+
+// Location is the nearest position on a route to a given position.
+type Location struct {
+	Mode     globals.ModeType
+	Route    *Route
+	Segment  *Segment
+	Pos      geo.Pos // nearest position on the segment
+	Distance float64 // distance off-track in km
+	Offset   float64 // distance along the segment line in km
+}
+
+// Chainage is the distance along the route in km.
+func (l *Location) Chainage() float64 {
+	return l.Segment.Modes[l.Mode].From + l.Offset
+}
+
+type locatorItem struct {
+	route   *Route
+	segment *Segment
+}
+
+// Locate finds the nearest route of the required type for a mode. Only routes closer than max km are considered. The
+// hiking alternatives routes are treated as optional.
+func (d *Data) Locate(pos geo.Pos, mode globals.ModeType, required globals.RequiredType, max float64) *Location {
+	if d.locators == nil {
+		d.buildLocators()
+	}
+	filter := func(value interface{}) bool {
+		return value.(*locatorItem).route.Key.Required == required
+	}
+	hit, found := d.locators[mode].Nearest(pos, max, filter)
+	if !found {
+		return nil
+	}
+	item := hit.Value.(*locatorItem)
+	return &Location{
+		Mode:     mode,
+		Route:    item.route,
+		Segment:  item.segment,
+		Pos:      hit.Pos,
+		Distance: hit.Distance,
+		Offset:   hit.Line.Along(hit.Projection),
+	}
+}
+
+func (d *Data) buildLocators() {
+	d.locators = map[globals.ModeType]*geo.Index{}
+	for _, mode := range globals.MODES {
+		d.locators[mode] = geo.NewIndex(1.0)
+	}
+	for _, sectionKey := range d.Keys {
+		section := d.Sections[sectionKey]
+		for _, routeKey := range section.RouteKeys {
+			route := section.Routes[routeKey]
+			for _, mode := range globals.MODES {
+				if route.Modes[mode] == nil {
+					continue
+				}
+				for _, segment := range route.Modes[mode].Segments {
+					d.locators[mode].AddLine(segment.Line, &locatorItem{route: route, segment: segment})
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package routedata
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/kml"
+)
+
+// MergeResult records the changes made by Merge.
+type MergeResult struct {
+	Replaced  []string
+	Added     []string
+	Removed   []string
+	Conflicts []string
+}
+
+// Report is a plain text list of the conflicts, followed by a summary of the changes.
+func (m *MergeResult) Report() string {
+	sb := &strings.Builder{}
+	if len(m.Conflicts) == 0 {
+		fmt.Fprintln(sb, "No conflicts.")
+	} else {
+		fmt.Fprintf(sb, "%d conflicts (the master file version has been kept):\n", len(m.Conflicts))
+		for _, c := range m.Conflicts {
+			fmt.Fprintf(sb, "  %s\n", c)
+		}
+	}
+	list := func(title string, names []string) {
+		fmt.Fprintf(sb, "\n%s: %d\n", title, len(names))
+		for _, name := range names {
+			fmt.Fprintf(sb, "  %s\n", name)
+		}
+	}
+	list("Replaced", m.Replaced)
+	list("Added", m.Added)
+	list("Removed", m.Removed)
+	return sb.String()
+}
+
+// placed is a placemark and the folders that contain it.
+type placed struct {
+	placemark *kml.Placemark
+	folder    *kml.Folder
+	path      string // folder names from the root, separated by "/"
+}
+
+// placemarkIndex finds placemarks by normalised name and legacy name.
+type placemarkIndex map[string][]*placed
+
+func newPlacemarkIndex(folders []*kml.Folder) placemarkIndex {
+	index := placemarkIndex{}
+	walkPlacemarks(folders, "", func(p *placed) {
+		index.add(p)
+	})
+	return index
+}
+
+func (index placemarkIndex) add(p *placed) {
+	for _, key := range placemarkKeys(p.placemark) {
+		index[key] = append(index[key], p)
+	}
+}
+
+// find returns the placemark matching by legacy name then by name. If there's more than one match, the one in the same
+// folder is preferred. An error is returned if the match is ambiguous.
+func (index placemarkIndex) find(p *placed) (*placed, error) {
+	for _, key := range placemarkKeys(p.placemark) {
+		candidates := index[key]
+		switch len(candidates) {
+		case 0:
+			continue
+		case 1:
+			return candidates[0], nil
+		}
+		for _, candidate := range candidates {
+			if candidate.path == p.path {
+				return candidate, nil
+			}
+		}
+		return nil, fmt.Errorf("%q matches %d placemarks", p.placemark.Name, len(candidates))
+	}
+	return nil, nil
+}
+
+// placemarkKeys are the normalised legacy name (if any) and normalised name of a placemark.
+func placemarkKeys(p *kml.Placemark) []string {
+	var keys []string
+	if p.Legacy != "" {
+		keys = append(keys, normaliseName(p.Legacy))
+	}
+	if name := normaliseName(p.Name); len(keys) == 0 || keys[0] != name {
+		keys = append(keys, name)
+	}
+	return keys
+}
+
+var chainageRegex = regexp.MustCompile(`\s*\[[^]]*]`)
+
+// normaliseName removes the parts of a placemark name that change when the master file is saved (the chainage of
+// segments and the trailing "-" of waypoints).
+func normaliseName(name string) string {
+	name = chainageRegex.ReplaceAllString(fixString(name), "")
+	name = strings.TrimSuffix(strings.TrimSpace(name), "-")
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// walkPlacemarks calls f for each placemark in the folders. The folders are in the folder with path parent.
+func walkPlacemarks(folders []*kml.Folder, parent string, f func(p *placed)) {
+	var walk func(folder *kml.Folder, path string)
+	walk = func(folder *kml.Folder, path string) {
+		path = strings.TrimPrefix(path+"/"+folder.Name, "/")
+		for _, placemark := range folder.Placemarks {
+			f(&placed{placemark: placemark, folder: folder, path: path})
+		}
+		for _, inner := range folder.Folders {
+			walk(inner, path)
+		}
+	}
+	for _, folder := range folders {
+		walk(folder, parent)
+	}
+}
+
+// rootFolders are the top level folders, inside the "GPT Master" folder if there is one.
+func rootFolders(root *kml.Root) []*kml.Folder {
+	folders := root.Document.Folders
+	if len(folders) == 1 && folders[0].Name == "GPT Master" {
+		return folders[0].Folders
+	}
+	return folders
+}
+
+// findFolder finds the folder with a "/" separated path, creating any folders that are missing if create is true.
+func findFolder(folders *[]*kml.Folder, path string, create bool) *kml.Folder {
+	var folder *kml.Folder
+	for _, name := range strings.Split(path, "/") {
+		folder = nil
+		for _, f := range *folders {
+			if f.Name == name {
+				folder = f
+				break
+			}
+		}
+		if folder == nil {
+			if !create {
+				return nil
+			}
+			folder = &kml.Folder{Name: name}
+			*folders = append(*folders, folder)
+		}
+		folders = &folder.Folders
+	}
+	return folder
+}
+
+// samePlacemark is true if the name, description, style and geometry of two placemarks are the same. Coordinates are
+// compared numerically because editors may write them with a different precision.
+func samePlacemark(a, b *kml.Placemark) bool {
+	if fixString(a.Name) != fixString(b.Name) || fixString(a.Description) != fixString(b.Description) || fixString(a.StyleUrl) != fixString(b.StyleUrl) {
+		return false
+	}
+	return sameLine(placemarkLine(a), placemarkLine(b))
+}
+
+func placemarkLine(p *kml.Placemark) geo.Line {
+	if p.Point != nil {
+		return geo.Line{p.Point.Pos()}
+	}
+	if ls := p.GetLineString(); ls != nil {
+		return ls.Line()
+	}
+	return nil
+}
+
+func sameLine(a, b geo.Line) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	const epsilon = 1e-6
+	for i := range a {
+		if math.Abs(a[i].Lat-b[i].Lat) > epsilon || math.Abs(a[i].Lon-b[i].Lon) > epsilon || math.Abs(a[i].Ele-b[i].Ele) > 0.5 {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge applies the placemarks in a patch file to the master file, before it is scanned. Placemarks are matched by
+// normalised name or by legacy name. Matched placemarks are replaced, and unmatched placemarks are added in the same
+// folder as in the patch. Each section folder in the patch replaces the whole section, so placemarks in those sections
+// of the master that aren't in the patch are removed.
+//
+// If base (the master file that the patch was edited from) is given, a placemark that has been changed in both the
+// master and the patch is a conflict, and the master version is kept. Without base, the patch always wins.
+func Merge(master *kml.Root, patch kml.Root, base *kml.Root) *MergeResult {
+	result := &MergeResult{}
+
+	masterFolders := &master.Document.Folders
+	if len(*masterFolders) == 1 && (*masterFolders)[0].Name == "GPT Master" {
+		masterFolders = &(*masterFolders)[0].Folders
+	}
+	masterIndex := newPlacemarkIndex(*masterFolders)
+	var baseIndex placemarkIndex
+	if base != nil {
+		baseIndex = newPlacemarkIndex(rootFolders(base))
+	}
+	findBase := func(p *placed) *placed {
+		if baseIndex == nil {
+			return nil
+		}
+		found, err := baseIndex.find(p)
+		if err != nil {
+			return nil
+		}
+		return found
+	}
+
+	// placemarks in the master that are matched by a placemark in the patch
+	matched := map[*kml.Placemark]bool{}
+
+	walkPlacemarks(rootFolders(&patch), "", func(p *placed) {
+		name := fixString(p.placemark.Name)
+		target, err := masterIndex.find(p)
+		if err != nil {
+			result.Conflicts = append(result.Conflicts, err.Error())
+			return
+		}
+		original := findBase(p)
+		if target == nil {
+			if original != nil {
+				// deleted from the master since the patch was edited
+				if !samePlacemark(original.placemark, p.placemark) {
+					result.Conflicts = append(result.Conflicts, fmt.Sprintf("%q was removed from the master but changed in the patch", name))
+				}
+				return
+			}
+			folder := findFolder(masterFolders, p.path, true)
+			folder.Placemarks = append(folder.Placemarks, p.placemark)
+			masterIndex.add(&placed{placemark: p.placemark, folder: folder, path: p.path})
+			matched[p.placemark] = true
+			result.Added = append(result.Added, name)
+			return
+		}
+		matched[target.placemark] = true
+		if samePlacemark(target.placemark, p.placemark) {
+			return
+		}
+		if original != nil && !samePlacemark(original.placemark, target.placemark) {
+			if !samePlacemark(original.placemark, p.placemark) {
+				result.Conflicts = append(result.Conflicts, fmt.Sprintf("%q was changed in both the master and the patch", name))
+			}
+			return
+		}
+		legacy := target.placemark.Legacy
+		*target.placemark = *p.placemark
+		target.placemark.Legacy = legacy
+		result.Replaced = append(result.Replaced, name)
+	})
+
+	// remove placemarks from the sections in the patch that weren't matched
+	var sections []string
+	var findSections func(folder *kml.Folder, path string)
+	findSections = func(folder *kml.Folder, path string) {
+		path = strings.TrimPrefix(path+"/"+folder.Name, "/")
+		if sectionFolderRegex.MatchString(fixString(folder.Name)) {
+			sections = append(sections, path)
+			return
+		}
+		for _, inner := range folder.Folders {
+			findSections(inner, path)
+		}
+	}
+	for _, folder := range rootFolders(&patch) {
+		findSections(folder, "")
+	}
+	var removed []*placed
+	for _, section := range sections {
+		folder := findFolder(masterFolders, section, false)
+		if folder == nil {
+			continue
+		}
+		parent := strings.TrimSuffix(strings.TrimSuffix(section, folder.Name), "/")
+		walkPlacemarks([]*kml.Folder{folder}, parent, func(p *placed) {
+			if matched[p.placemark] {
+				return
+			}
+			name := fixString(p.placemark.Name)
+			if baseIndex != nil {
+				original := findBase(p)
+				if original == nil {
+					// added to the master since the patch was edited
+					return
+				}
+				if !samePlacemark(original.placemark, p.placemark) {
+					result.Conflicts = append(result.Conflicts, fmt.Sprintf("%q was changed in the master but removed in the patch", name))
+					return
+				}
+			}
+			removed = append(removed, p)
+			result.Removed = append(result.Removed, name)
+		})
+	}
+	for _, p := range removed {
+		for i, placemark := range p.folder.Placemarks {
+			if placemark == p.placemark {
+				p.folder.Placemarks = append(p.folder.Placemarks[:i], p.folder.Placemarks[i+1:]...)
+				break
+			}
+		}
+	}
+	return result
+}
@@ -1,6 +1,7 @@
 package routedata
 
 import (
+	"container/heap"
 	"fmt"
 	"sort"
 	"strings"
@@ -19,6 +20,8 @@ type Network struct {
 
 	Nodes     []*Node
 	Straights []*Straight
+	// Stretches of consecutive segments with the same water terrain (FJ, LK, RI or FY), leveled by LevelWater.
+	Water [][]*Segment
 
 	// Short edges go from point to point, and can be shorter than a segment where the segment has mid points. len(ShortEdges) >= len(Segments).
 	ShortEdges map[*Node][]*Edge
@@ -31,6 +34,35 @@ type Network struct {
 	// we choose the nearest unused point as the new entry point and repeat until all edges are used. Long edge paths DO
 	// NOT traverse segments in reverse.
 	LongEdgePaths map[*Point]*Path
+	// One-way segments that must be travelled the wrong way to reach all of the network from the entry point.
+	Violations []*Segment
+	// For optional routes, where the route leaves and rejoins the regular route.
+	Junction *Junction
+}
+
+// Junction is where an optional route leaves and rejoins the regular route of the same section and mode.
+type Junction struct {
+	Regular    *Route
+	Departs    bool    // a node of the option is on the regular route
+	Rejoins    bool    // another node of the option is on the regular route, further along it
+	DepartNode *Node   // the node of the option where it leaves the regular route
+	RejoinNode *Node   // the node of the option where it rejoins the regular route
+	Depart     float64 // chainage in km of the regular route where the option leaves
+	Rejoin     float64 // chainage in km of the regular route where the option rejoins
+	Length     float64 // length in km of the path between the nodes, or of the whole option if it doesn't rejoin
+	Bypassed   float64 // km of the regular route replaced by the option (zero unless it departs and rejoins)
+	Difference float64 // length of the option minus the bypassed km
+}
+
+func (j *Junction) Description() string {
+	switch {
+	case j.Departs && j.Rejoins:
+		return fmt.Sprintf("Leaves the regular route at %.1f km and rejoins at %.1f km, bypassing %.1f km (%+.1f km)", j.Depart, j.Rejoin, j.Bypassed, j.Difference)
+	case j.Departs:
+		return fmt.Sprintf("Leaves the regular route at %.1f km and doesn't rejoin", j.Depart)
+	default:
+		return "Doesn't join the regular route"
+	}
 }
 
 //func (n *Network) Signature() string {
@@ -82,6 +114,8 @@ func (n *Network) Normalise() error {
 
 	n.BuildEdges()
 
+	n.BuildWater()
+
 	n.BuildShortEdgePaths()
 
 	for _, segment := range n.Route.Modes[n.Mode].Segments {
@@ -97,6 +131,91 @@ func (n *Network) Normalise() error {
 	return nil
 }
 
+// BuildJunction finds where an optional route leaves and rejoins the regular route it's attached to most. The option
+// leaves and rejoins at the first and last of its nodes on the regular route (by chainage of the regular route), and
+// the length is the shortest path between them, so branches of the option aren't counted. The regular networks must
+// already be normalised so the chainage of each segment is known.
+func (n *Network) BuildJunction(regulars []*Route) {
+	var length float64
+	for _, segment := range n.RouteModeData.Segments {
+		length += segment.Length
+	}
+
+	// chainage finds the nearest position on a regular route to pos
+	chainage := func(route *Route, pos geo.Pos) (float64, float64) {
+		var best geo.Projection
+		var bestChainage float64
+		for i, segment := range route.Modes[n.Mode].Segments {
+			proj := segment.Line.Project(pos)
+			if i == 0 || proj.Distance < best.Distance {
+				best, bestChainage = proj, segment.Modes[n.Mode].From+segment.Line.Along(proj)
+			}
+		}
+		return bestChainage, best.Distance
+	}
+
+	var bestAttached int
+	for _, regular := range regulars {
+		if regular.Modes[n.Mode] == nil {
+			continue
+		}
+		if n.Route.Key.Direction != "" && regular.Key.Direction != "" && n.Route.Key.Direction != regular.Key.Direction {
+			continue
+		}
+		attached := n.attachments(func(pos geo.Pos) (float64, float64) { return chainage(regular, pos) })
+		if len(attached) > 2 {
+			attached = []attachment{attached[0], attached[len(attached)-1]}
+		}
+		if n.Junction != nil && len(attached) <= bestAttached {
+			continue
+		}
+		bestAttached = len(attached)
+		j := &Junction{Regular: regular, Length: length}
+		if len(attached) > 0 {
+			j.Departs, j.DepartNode, j.Depart = true, attached[0].node, attached[0].chainage
+		}
+		if len(attached) > 1 {
+			j.Rejoins, j.RejoinNode, j.Rejoin = true, attached[1].node, attached[1].chainage
+			// if the option is in pieces that don't connect, the length stays as the total of the segments
+			if path, _ := n.path(j.DepartNode, j.RejoinNode); path != nil {
+				j.Length = path.Length
+			}
+			j.Bypassed = j.Rejoin - j.Depart
+		}
+		j.Difference = j.Length - j.Bypassed
+		n.Junction = j
+	}
+}
+
+// attachment is a node of the network that's on another route.
+type attachment struct {
+	node     *Node
+	chainage float64 // km along the other route
+}
+
+// attachments finds the nodes of the network within globals.DELTA of another route, ordered by chainage along that
+// route. project finds the chainage and distance of the nearest position on the route.
+func (n *Network) attachments(project func(pos geo.Pos) (chainage, distance float64)) []attachment {
+	var attached []attachment
+	for _, node := range n.Nodes {
+		var nearest float64
+		var found bool
+		var a attachment
+		for _, point := range node.Points {
+			chainage, distance := project(point.Pos)
+			if distance <= globals.DELTA && (!found || distance < nearest) {
+				nearest, found = distance, true
+				a = attachment{node: node, chainage: chainage}
+			}
+		}
+		if found {
+			attached = append(attached, a)
+		}
+	}
+	sort.SliceStable(attached, func(i, j int) bool { return attached[i].chainage < attached[j].chainage })
+	return attached
+}
+
 func (n *Network) BuildStraights() {
 	newFlush := func(segment *Segment) *Flush {
 		return &Flush{
@@ -149,7 +268,9 @@ func (n *Network) BuildStraights() {
 	}
 }
 
-func (n *Network) LevelWater() {
+// BuildWater finds the stretches of water, and which way each river stretch is drawn. The direction of a river is
+// found from the whole stretch, so it's known before the one-way segments are used to find paths.
+func (n *Network) BuildWater() {
 	//FJ: Fjord Packrafting, LK: Lake Packrafting, RI: River Packrafting, FY: Ferry
 	water := map[string]bool{"FJ": true, "LK": true, "RI": true, "FY": true}
 	same := func(s1, s2 *Segment) bool {
@@ -167,27 +288,45 @@ func (n *Network) LevelWater() {
 		}
 		return water[s.Terrains[0]]
 	}
-	var stretches [][]*Segment
-	for _, straight := range n.Straights {
-		var stretch []*Segment
-		for i, segment := range straight.Segments {
-			if i > 0 {
-				if !same(straight.Segments[i-1], segment) {
-					if len(stretch) > 0 {
-						stretches = append(stretches, stretch)
-					}
-					stretch = nil
+	// stretches don't continue between straights (see BuildStraights)
+	n.Water = nil
+	var stretch []*Segment
+	for i, segment := range n.RouteModeData.Segments {
+		if i > 0 {
+			prev := n.RouteModeData.Segments[i-1]
+			if !prev.Modes[n.Mode].EndPoint.Node.Contains(segment.Modes[n.Mode].StartPoint) || !same(prev, segment) {
+				if len(stretch) > 0 {
+					n.Water = append(n.Water, stretch)
 				}
-			}
-			if match(segment) {
-				stretch = append(stretch, segment)
+				stretch = nil
 			}
 		}
-		if len(stretch) > 0 {
-			stretches = append(stretches, stretch)
+		if match(segment) {
+			stretch = append(stretch, segment)
 		}
 	}
-	for _, stretch := range stretches {
+	if len(stretch) > 0 {
+		n.Water = append(n.Water, stretch)
+	}
+	for _, stretch := range n.Water {
+		if stretch[0].Terrains[0] != "RI" {
+			continue
+		}
+		upstream, known := riverUphill(stretch)
+		if !known {
+			logf("GPT%s %s (%s): no elevation change along river %q, so it's not treated as one-way\n", n.Route.Section.Key.Code(), n.Route.Key.Debug(), modeName(n.Mode), stretch[0].Raw)
+		}
+		for _, segment := range stretch {
+			segment.Modes[n.Mode].Upstream = upstream
+			segment.Modes[n.Mode].FlowUnknown = !known
+		}
+	}
+}
+
+// LevelWater levels the elevations of each stretch of water: fjords are at sea level, lakes and ferries are at the
+// lowest elevation, and rivers only fall in the direction of flow.
+func (n *Network) LevelWater() {
+	for _, stretch := range n.Water {
 		switch stretch[0].Terrains[0] {
 		case "FJ":
 			// all elevations should be zero
@@ -225,24 +364,7 @@ func (n *Network) LevelWater() {
 				}
 			}
 		case "RI":
-			var lastPos geo.Pos
-			var foundPos bool
-			var uphillCount, downhillCount int
-			for _, segment := range stretch {
-				for _, pos := range segment.Line {
-					if foundPos {
-						if lastPos.Ele < pos.Ele {
-							uphillCount++
-						}
-						if lastPos.Ele > pos.Ele {
-							downhillCount++
-						}
-					}
-					lastPos = pos
-					foundPos = true
-				}
-			}
-			uphill := uphillCount > downhillCount
+			uphill := stretch[0].Modes[n.Mode].Upstream
 			var lastEle float64
 			var foundEle bool
 			for _, segment := range stretch {
@@ -259,7 +381,6 @@ func (n *Network) LevelWater() {
 							}
 						} else {
 							// elevations can only fall
-							// elevations can only rise
 							if segment.Line[i].Ele > lastEle {
 								segment.Line[i] = geo.Pos{
 									Lat: segment.Line[i].Lat,
@@ -277,6 +398,29 @@ func (n *Network) LevelWater() {
 	}
 }
 
+// riverUphill is true if a stretch of river segments is drawn upstream: more of the line rises than falls. Known is
+// false if as much rises as falls (e.g. there's no elevation data), so the direction of flow can't be found.
+func riverUphill(stretch []*Segment) (uphill, known bool) {
+	var lastPos geo.Pos
+	var foundPos bool
+	var uphillCount, downhillCount int
+	for _, segment := range stretch {
+		for _, pos := range segment.Line {
+			if foundPos {
+				if lastPos.Ele < pos.Ele {
+					uphillCount++
+				}
+				if lastPos.Ele > pos.Ele {
+					downhillCount++
+				}
+			}
+			lastPos = pos
+			foundPos = true
+		}
+	}
+	return uphillCount > downhillCount, uphillCount != downhillCount
+}
+
 func (n *Network) BuildEdges() {
 	// build edges
 	n.ShortEdges = map[*Node][]*Edge{}
@@ -302,6 +446,7 @@ func (n *Network) BuildEdges() {
 			edge := &Edge{
 				Segment: segment,
 				Nodes:   [2]*Node{prev.Node, point.Node},
+				Points:  [2]*Point{prev, point},
 				Length:  length,
 			}
 			n.ShortEdges[prev.Node] = append(n.ShortEdges[prev.Node], edge)
@@ -310,6 +455,7 @@ func (n *Network) BuildEdges() {
 		edge := &Edge{
 			Segment: segment,
 			Nodes:   [2]*Node{segment.Modes[n.Mode].StartPoint.Node, segment.Modes[n.Mode].EndPoint.Node},
+			Points:  [2]*Point{segment.Modes[n.Mode].StartPoint, segment.Modes[n.Mode].EndPoint},
 			Length:  segment.Line.Length(),
 		}
 		n.LongEdges[segment.Modes[n.Mode].StartPoint.Node] = append(n.LongEdges[segment.Modes[n.Mode].StartPoint.Node], edge)
@@ -321,24 +467,71 @@ func (n *Network) BuildShortEdgePaths() {
 	// for each segment, find the shortest path from the entry point to the start point and to the end point. If it's a
 	// shorter path to the end point, the segment should be reversed.
 	n.ShortEdgePaths = map[*Point]*Path{}
-	var explore func(*Path, *Node)
-	explore = func(path *Path, node *Node) {
+	n.Violations = nil
+	entry := n.Entry.Modes[n.Mode].StartPoint.Node
+	paths := n.shortestPaths(n.ShortEdges, entry, n.allowed)
 
-		for _, point := range node.Points {
-			if n.ShortEdgePaths[point] == nil || n.ShortEdgePaths[point].Length > path.Length {
-				n.ShortEdgePaths[point] = path.Copy()
-			}
+	// if one-way segments make part of the network unreachable, it's reached by travelling them the wrong way
+	var oneWay bool
+	for _, segment := range n.RouteModeData.Segments {
+		if ok, _ := segment.OneWay(n.Mode); ok {
+			oneWay = true
+			break
 		}
-
-		for _, edge := range n.ShortEdges[node] {
-			if path.Has(edge) {
+	}
+	if oneWay {
+		violations := map[*Segment]bool{}
+		for node, path := range n.shortestPaths(n.ShortEdges, entry, nil) {
+			if paths[node] != nil {
 				continue
 			}
-			// all points attached to this node
-			explore(path.CopyAndAdd(edge), edge.Opposite(node))
+			paths[node] = path
+			from := path.From
+			for _, edge := range path.Edges {
+				if !n.allowed(edge, from) && !violations[edge.Segment] {
+					violations[edge.Segment] = true
+					n.Violations = append(n.Violations, edge.Segment)
+				}
+				from = edge.Opposite(from)
+			}
 		}
 	}
-	explore(&Path{From: n.Entry.Modes[n.Mode].StartPoint.Node}, n.Entry.Modes[n.Mode].StartPoint.Node)
+
+	for node, path := range paths {
+		for _, point := range node.Points {
+			n.ShortEdgePaths[point] = path
+		}
+	}
+}
+
+// ViolationsDescription is a warning for each one-way segment that must be travelled the wrong way.
+func (n *Network) ViolationsDescription() string {
+	var sb strings.Builder
+	for _, segment := range n.Violations {
+		sb.WriteString(fmt.Sprintf("%s One-way segment travelled against its direction: %s\n", WARNING_SYMBOL, segment.PlacemarkName()))
+	}
+	return sb.String()
+}
+
+// path is the shortest path along short edges between two nodes. One-way segments are only travelled the wrong way if
+// there's no other path, and then ok is false.
+func (n *Network) path(from, to *Node) (path *Path, ok bool) {
+	if path := n.shortestPaths(n.ShortEdges, from, n.allowed)[to]; path != nil {
+		return path, true
+	}
+	return n.shortestPaths(n.ShortEdges, from, nil)[to], false
+}
+
+// allowed is false if the edge is part of a one-way segment and travelling from node would go the wrong way.
+func (n *Network) allowed(edge *Edge, from *Node) bool {
+	return n.allowedAlong(edge.Segment, edge.Along(from))
+}
+
+// allowedAlong is false if the segment is one-way, and travelling it along the line (or against it if along is false)
+// would go the wrong way.
+func (n *Network) allowedAlong(segment *Segment, along bool) bool {
+	oneWay, forward := segment.OneWay(n.Mode)
+	return !oneWay || along == forward
 }
 
 //func (n *Network) ReverseSegments() {
@@ -372,36 +565,75 @@ func (n *Network) BuildLongEdgePaths() {
 	for len(n.ShortEdgePaths) > len(n.LongEdgePaths) {
 		entry := findStartPoint()
 
-		var explore func(*Path, *Node)
-		explore = func(path *Path, node *Node) {
-
+		// only traverse edges from the start of their segment, and not against one-way segments
+		fromStart := func(edge *Edge, from *Node) bool {
+			return from.Contains(edge.Segment.Modes[n.Mode].StartPoint) && n.allowed(edge, from)
+		}
+		for node, path := range n.shortestPaths(n.LongEdges, entry.Node, fromStart) {
 			for _, point := range node.Points {
-				//if n.Route.Regular {
-				// TODO: ???
-				//	if n.LongEdgePaths[point] == nil || n.LongEdgePaths[point].Length < path.Length {
-				//		n.LongEdgePaths[point] = path.Copy()
-				//	}
-				//} else {
 				if n.LongEdgePaths[point] == nil || n.LongEdgePaths[point].Length > path.Length {
-					n.LongEdgePaths[point] = path.Copy()
+					n.LongEdgePaths[point] = path
 				}
-				//}
 			}
+		}
+	}
+}
 
-			for _, edge := range n.LongEdges[node] {
-				if path.Has(edge) {
-					continue
-				}
-				// only traverse this edge if we are at the start of it's segment
-				if !node.Contains(edge.Segment.Modes[n.Mode].StartPoint) {
-					continue
-				}
-				// all other points attached to this node
-				explore(path.CopyAndAdd(edge), edge.Opposite(node))
+// shortestPaths finds the shortest path from the entry node to each node that can be reached along the edges, using
+// Dijkstra's algorithm. If allowed is not nil, edges are only traversed from a node if allowed returns true.
+func (n *Network) shortestPaths(edges map[*Node][]*Edge, entry *Node, allowed func(edge *Edge, from *Node) bool) map[*Node]*Path {
+	paths := map[*Node]*Path{}
+	lengths := map[*Node]float64{entry: 0}
+	previous := map[*Node]*Edge{}
+	queue := &pathQueue{}
+	heap.Push(queue, &pathItem{node: entry})
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(*pathItem)
+		node := item.node
+		if paths[node] != nil {
+			continue
+		}
+		if edge := previous[node]; edge != nil {
+			paths[node] = paths[edge.Opposite(node)].CopyAndAdd(edge)
+		} else {
+			paths[node] = &Path{From: entry}
+		}
+		for _, edge := range edges[node] {
+			if allowed != nil && !allowed(edge, node) {
+				continue
 			}
+			next := edge.Opposite(node)
+			if paths[next] != nil {
+				continue
+			}
+			length := lengths[node] + edge.Length
+			if existing, found := lengths[next]; found && existing <= length {
+				continue
+			}
+			lengths[next] = length
+			previous[next] = edge
+			heap.Push(queue, &pathItem{node: next, length: length})
 		}
-		explore(&Path{From: entry.Node}, entry.Node)
 	}
+	return paths
+}
+
+type pathItem struct {
+	node   *Node
+	length float64
+}
+
+type pathQueue []*pathItem
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, j int) bool  { return q[i].length < q[j].length }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(*pathItem)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
 }
 
 var debugString string
@@ -628,12 +860,6 @@ func (p1 *Path) Is(p2 *Path) bool {
 	return true
 }
 
-func (p *Path) Copy() *Path {
-	edges := make([]*Edge, len(p.Edges))
-	copy(edges, p.Edges)
-	return &Path{From: p.From, Edges: edges, Length: p.Length}
-}
-
 func (p *Path) CopyAndAdd(e *Edge) *Path {
 	edges := make([]*Edge, len(p.Edges)+1)
 	copy(edges, p.Edges)
@@ -641,22 +867,22 @@ func (p *Path) CopyAndAdd(e *Edge) *Path {
 	return &Path{From: p.From, Edges: edges, Length: p.Length + e.Length}
 }
 
-func (p *Path) Has(edge *Edge) bool {
-	for _, e := range p.Edges {
-		if e == edge {
-			return true
-		}
-	}
-	return false
-}
-
 // Edge connects exactly two Nodes. Distinct from Segments because Segments can have several nodes along their length.
 type Edge struct {
 	Segment *Segment
 	Nodes   [2]*Node
+	Points  [2]*Point // the points on the segment at each node
 	Length  float64
 }
 
+// Along is true if travelling the edge from node n follows the direction of the segment line.
+func (e Edge) Along(n *Node) bool {
+	if e.Nodes[0] == n {
+		return e.Points[0].Index < e.Points[1].Index
+	}
+	return e.Points[1].Index < e.Points[0].Index
+}
+
 func (e Edge) Has(n *Node) bool {
 	return e.Nodes[0] == n || e.Nodes[1] == n
 }
@@ -0,0 +1,421 @@
+package routedata
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/globals"
+)
+
+// offset is the position km east and km south of a point in Patagonia.
+func offset(east, south float64) geo.Pos {
+	return geo.Pos{Lat: -45, Lon: -72}.Destination(90, east).Destination(180, south)
+}
+
+// testRoute builds a hiking route in section 1 with a trail segment for each line, and builds its network.
+func testRoute(t testing.TB, key RouteKey, lines ...geo.Line) *Route {
+	t.Helper()
+	return testModeRoute(t, globals.HIKE, key, lines...)
+}
+
+// testModeRoute builds a route for a mode in section 1 with a trail segment for each line, and builds its network.
+func testModeRoute(t testing.TB, mode globals.ModeType, key RouteKey, lines ...geo.Line) *Route {
+	t.Helper()
+	route := &Route{
+		Section: &Section{Key: globals.SectionKey{Number: 1}, Routes: map[RouteKey]*Route{}},
+		Key:     key,
+		Modes:   map[globals.ModeType]*RouteModeData{mode: {}},
+	}
+	rMode := route.Modes[mode]
+	for i, line := range lines {
+		segment := &Segment{
+			Route:        route,
+			Raw:          fmt.Sprintf("segment %d", i),
+			Code:         "OH",
+			Terrains:     []string{"TL"},
+			Verification: "V",
+			Directional:  "2",
+			Length:       line.Length(),
+			Line:         line,
+			Modes:        map[globals.ModeType]*SegmentModeData{mode: {}},
+		}
+		switch {
+		case key.Required == globals.REGULAR && mode == globals.RAFT:
+			segment.Code = "RP"
+		case key.Required == globals.REGULAR:
+			segment.Code = "RH"
+		case mode == globals.RAFT:
+			segment.Code = "OP"
+		}
+		rMode.Segments = append(rMode.Segments, segment)
+		route.All = append(route.All, segment)
+	}
+	rMode.Network = &Network{
+		Mode:          mode,
+		Route:         route,
+		RouteModeData: rMode,
+		Entry:         rMode.Segments[0],
+	}
+	if err := route.BuildNetworks(); err != nil {
+		t.Fatal(err)
+	}
+	return route
+}
+
+// ladder builds the hiking network of an optional route shaped like a ladder. Each rail is one segment drawn west to
+// east with a vertex every 500 m, and neighbouring rails are joined by a rung at every vertex, so the rungs end in the
+// middle of the rails. Each rung bends east by a different amount, so the braids have different lengths.
+func ladder(t testing.TB, rails, rungs int) *Network {
+	t.Helper()
+	pos := func(rail, rung int) geo.Pos {
+		return offset(float64(rung)*0.5, float64(rail)*0.5)
+	}
+	var lines []geo.Line
+	for rail := 0; rail < rails; rail++ {
+		var line geo.Line
+		for rung := 0; rung < rungs; rung++ {
+			line = append(line, pos(rail, rung))
+		}
+		lines = append(lines, line)
+	}
+	for rail := 0; rail < rails-1; rail++ {
+		for rung := 0; rung < rungs; rung++ {
+			bend := pos(rail, rung).Destination(180, 0.25).Destination(90, 0.05*float64((rail*7+rung*3)%5))
+			lines = append(lines, geo.Line{pos(rail, rung), bend, pos(rail+1, rung)})
+		}
+	}
+	network := testRoute(t, RouteKey{Required: globals.OPTIONAL, Option: 1}, lines...).Modes[globals.HIKE].Network
+	network.BuildEdges()
+	return network
+}
+
+// exhaustiveShortEdgePaths is the search that BuildShortEdgePaths used before Dijkstra: every path from the entry
+// point that doesn't repeat an edge is explored. It's only practical for small networks.
+func exhaustiveShortEdgePaths(n *Network) map[*Point]*Path {
+	paths := map[*Point]*Path{}
+	has := func(path *Path, edge *Edge) bool {
+		for _, e := range path.Edges {
+			if e == edge {
+				return true
+			}
+		}
+		return false
+	}
+	var explore func(*Path, *Node)
+	explore = func(path *Path, node *Node) {
+		for _, point := range node.Points {
+			if paths[point] == nil || paths[point].Length > path.Length {
+				paths[point] = path
+			}
+		}
+		for _, edge := range n.ShortEdges[node] {
+			if has(path, edge) {
+				continue
+			}
+			explore(path.CopyAndAdd(edge), edge.Opposite(node))
+		}
+	}
+	entry := n.Entry.Modes[n.Mode].StartPoint.Node
+	explore(&Path{From: entry}, entry)
+	return paths
+}
+
+func TestBuildShortEdgePaths(t *testing.T) {
+	for _, size := range [][2]int{{2, 2}, {2, 5}, {3, 3}, {3, 4}} {
+		t.Run(fmt.Sprintf("%dx%d", size[0], size[1]), func(t *testing.T) {
+			n := ladder(t, size[0], size[1])
+			if mid := len(n.RouteModeData.Segments[0].Modes[n.Mode].MidPoints); mid != size[1]-2 {
+				t.Fatalf("expected the rungs to join %d mid points of the rail, found %d", size[1]-2, mid)
+			}
+			expected := exhaustiveShortEdgePaths(n)
+			n.BuildShortEdgePaths()
+			if len(n.ShortEdgePaths) != len(expected) {
+				t.Fatalf("expected paths to %d points, found %d", len(expected), len(n.ShortEdgePaths))
+			}
+			for point, path := range expected {
+				if n.ShortEdgePaths[point] == nil {
+					t.Fatalf("no path to %s", point.Debug())
+				}
+				if math.Abs(n.ShortEdgePaths[point].Length-path.Length) > 1e-9 {
+					t.Errorf("%s: expected %v, found %v", point.Debug(), path.Length, n.ShortEdgePaths[point].Length)
+				}
+			}
+			// the segment From values are the short edge path lengths to the start of each segment
+			for _, segment := range n.RouteModeData.Segments {
+				segmentMode := segment.Modes[n.Mode]
+				segmentMode.From = n.ShortEdgePaths[segmentMode.StartPoint].Length
+				if math.Abs(segmentMode.From-expected[segmentMode.StartPoint].Length) > 1e-9 {
+					t.Errorf("%s: expected from %v, found %v", segment.Raw, expected[segmentMode.StartPoint].Length, segmentMode.From)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildLongEdgePaths(t *testing.T) {
+	n := ladder(t, 3, 4)
+	n.BuildShortEdgePaths()
+	n.BuildLongEdgePaths()
+	if len(n.LongEdgePaths) != len(n.ShortEdgePaths) {
+		t.Fatalf("expected long edge paths to %d points, found %d", len(n.ShortEdgePaths), len(n.LongEdgePaths))
+	}
+	for point, path := range n.LongEdgePaths {
+		// long edge paths only travel segments from start to end
+		from := path.From
+		for _, edge := range path.Edges {
+			if !from.Contains(edge.Segment.Modes[n.Mode].StartPoint) {
+				t.Errorf("path to %s travels %s in reverse", point.Debug(), edge.Segment.Raw)
+			}
+			from = edge.Opposite(from)
+		}
+	}
+}
+
+func BenchmarkBuildShortEdgePaths(b *testing.B) {
+	b.Run("exhaustive 3x4", func(b *testing.B) {
+		n := ladder(b, 3, 4)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			exhaustiveShortEdgePaths(n)
+		}
+	})
+	for _, size := range [][2]int{{3, 4}, {10, 10}, {30, 30}} {
+		b.Run(fmt.Sprintf("%dx%d", size[0], size[1]), func(b *testing.B) {
+			n := ladder(b, size[0], size[1])
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n.BuildShortEdgePaths()
+			}
+		})
+	}
+}
+
+func BenchmarkBuildLongEdgePaths(b *testing.B) {
+	for _, size := range [][2]int{{3, 4}, {10, 10}, {30, 30}} {
+		b.Run(fmt.Sprintf("%dx%d", size[0], size[1]), func(b *testing.B) {
+			n := ladder(b, size[0], size[1])
+			n.BuildShortEdgePaths()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n.BuildLongEdgePaths()
+			}
+		})
+	}
+}
+
+func TestBuildJunction(t *testing.T) {
+	// the regular route heads east for 4 km, with a vertex every 500 m
+	var line geo.Line
+	for east := 0.0; east <= 4; east += 0.5 {
+		line = append(line, offset(east, 0))
+	}
+	regular := testRoute(t, RouteKey{Required: globals.REGULAR}, line[:5], line[4:])
+	if err := regular.Modes[globals.HIKE].Network.Normalise(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                           string
+		lines                          []geo.Line
+		departs, rejoins               bool
+		depart, rejoin, length, bypass float64
+	}{
+		{
+			name: "loop",
+			lines: []geo.Line{
+				{offset(0.5, 0), offset(0.5, 0.5)},
+				{offset(0.5, 0.5), offset(2, 0.5)},
+				{offset(2, 0.5), offset(2, 0)},
+			},
+			departs: true, rejoins: true, depart: 0.5, rejoin: 2, length: 2.5, bypass: 1.5,
+		},
+		{
+			name: "drawn against the regular route",
+			lines: []geo.Line{
+				{offset(3, 0), offset(3, 0.5), offset(1, 0.5), offset(1, 0)},
+			},
+			departs: true, rejoins: true, depart: 1, rejoin: 3, length: 3, bypass: 2,
+		},
+		{
+			name: "branched",
+			lines: []geo.Line{
+				{offset(0.5, 0), offset(0.5, 0.5)},
+				{offset(0.5, 0.5), offset(1, 0.5), offset(2, 0.5)},
+				{offset(2, 0.5), offset(2, 0)},
+				// a dead end from the middle of the option, which isn't part of the path between the junctions
+				{offset(1, 0.5), offset(1, 3.5)},
+			},
+			departs: true, rejoins: true, depart: 0.5, rejoin: 2, length: 2.5, bypass: 1.5,
+		},
+		{
+			name: "joined in the middle",
+			lines: []geo.Line{
+				{offset(1, 2), offset(1, 1)},
+				{offset(1, 1), offset(2.5, 1), offset(2.5, 0)},
+				{offset(2.5, 1), offset(4, 1)},
+			},
+			departs: true, rejoins: false, depart: 2.5, length: 5,
+		},
+		{
+			name: "not joined",
+			lines: []geo.Line{
+				{offset(1, 1), offset(2, 1)},
+			},
+			length: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			network := testRoute(t, RouteKey{Required: globals.OPTIONAL, Option: 1}, test.lines...).Modes[globals.HIKE].Network
+			if err := network.Normalise(); err != nil {
+				t.Fatal(err)
+			}
+			network.BuildJunction([]*Route{regular})
+			j := network.Junction
+			if j.Departs != test.departs || j.Rejoins != test.rejoins {
+				t.Fatalf("expected departs %v and rejoins %v, found %v and %v", test.departs, test.rejoins, j.Departs, j.Rejoins)
+			}
+			for _, v := range []struct {
+				name             string
+				expected, actual float64
+			}{
+				{"depart", test.depart, j.Depart},
+				{"rejoin", test.rejoin, j.Rejoin},
+				{"length", test.length, j.Length},
+				{"bypassed", test.bypass, j.Bypassed},
+				{"difference", test.length - test.bypass, j.Difference},
+			} {
+				if (v.name == "depart" && !j.Departs) || (v.name == "rejoin" && !j.Rejoins) {
+					continue
+				}
+				if math.Abs(v.expected-v.actual) > 0.001 {
+					t.Errorf("%s: expected %.3f km, found %.3f km", v.name, v.expected, v.actual)
+				}
+			}
+		})
+	}
+}
+
+// river is a line heading east for km with a point every 500 m, rising (or falling if rise is negative) by rise metres
+// per point.
+func river(east, km, rise float64) geo.Line {
+	var line geo.Line
+	for i := 0; float64(i)*0.5 <= km; i++ {
+		pos := offset(east+float64(i)*0.5, 0)
+		pos.Ele = 100 + rise*float64(i)
+		line = append(line, pos)
+	}
+	return line
+}
+
+// makeRiver makes a segment a one-way river.
+func makeRiver(segment *Segment) {
+	segment.Terrains = []string{"RI"}
+	segment.Directional = "1"
+}
+
+func TestBuildWater(t *testing.T) {
+	tests := []struct {
+		name              string
+		rise              float64
+		upstream, unknown bool
+	}{
+		{"drawn downstream", -10, false, false},
+		{"drawn upstream", 10, true, false},
+		{"no elevation", 0, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route := testModeRoute(t, globals.RAFT, RouteKey{Required: globals.REGULAR}, river(0, 2, test.rise), river(2, 2, test.rise))
+			for _, segment := range route.Modes[globals.RAFT].Segments {
+				makeRiver(segment)
+			}
+			network := route.Modes[globals.RAFT].Network
+			if err := network.Normalise(); err != nil {
+				t.Fatal(err)
+			}
+			if len(network.Water) != 1 || len(network.Water[0]) != 2 {
+				t.Fatalf("expected one stretch of two segments, found %d stretches", len(network.Water))
+			}
+			for _, segment := range route.Modes[globals.RAFT].Segments {
+				data := segment.Modes[globals.RAFT]
+				if data.Upstream != test.upstream || data.FlowUnknown != test.unknown {
+					t.Errorf("%s: expected upstream %v and unknown %v, found %v and %v", segment.Raw, test.upstream, test.unknown, data.Upstream, data.FlowUnknown)
+				}
+				oneWay, forward := segment.OneWay(globals.RAFT)
+				if oneWay != !test.unknown || (oneWay && forward != !test.upstream) {
+					t.Errorf("%s: expected one-way %v forward %v, found %v and %v", segment.Raw, !test.unknown, !test.upstream, oneWay, forward)
+				}
+			}
+		})
+	}
+}
+
+func TestOneWay(t *testing.T) {
+	tests := []struct {
+		name            string
+		terrain         string
+		directional     string
+		mode            globals.ModeType
+		oneWay, forward bool
+	}{
+		{"two-way trail", "TL", "2", globals.HIKE, false, false},
+		{"one-way trail hiking", "TL", "1", globals.HIKE, true, true},
+		{"one-way trail packrafting", "TL", "1", globals.RAFT, true, true},
+		{"river hiking", "RI", "1", globals.HIKE, false, false},
+		{"lake hiking", "LK", "1", globals.HIKE, false, false},
+		{"river packrafting", "RI", "1", globals.RAFT, true, true},
+		{"lake packrafting", "LK", "1", globals.RAFT, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			segment := &Segment{
+				Terrains:    []string{test.terrain},
+				Directional: test.directional,
+				Modes:       map[globals.ModeType]*SegmentModeData{test.mode: {}},
+			}
+			if oneWay, forward := segment.OneWay(test.mode); oneWay != test.oneWay || forward != test.forward {
+				t.Errorf("expected %v, %v, found %v, %v", test.oneWay, test.forward, oneWay, forward)
+			}
+		})
+	}
+}
+
+func TestViolations(t *testing.T) {
+	// a regular packrafting route walks 2 km then goes up a river for 2 km, so the river must be travelled the wrong way
+	tests := []struct {
+		name       string
+		rise       float64
+		violations int
+	}{
+		{"downstream", -10, 0},
+		{"upstream", 10, 1},
+		{"no elevation", 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			route := testModeRoute(t, globals.RAFT, RouteKey{Required: globals.REGULAR}, river(0, 2, 0), river(2, 2, test.rise))
+			water := route.Modes[globals.RAFT].Segments[1]
+			makeRiver(water)
+			network := route.Modes[globals.RAFT].Network
+			if err := network.Normalise(); err != nil {
+				t.Fatal(err)
+			}
+			if len(network.Violations) != test.violations {
+				t.Fatalf("expected %d violations, found %d", test.violations, len(network.Violations))
+			}
+			if test.violations > 0 && network.Violations[0] != water {
+				t.Errorf("expected the river to be the violation, found %s", network.Violations[0].Raw)
+			}
+			if test.violations > 0 && network.ViolationsDescription() == "" {
+				t.Error("expected a description of the violation")
+			}
+			// chainage is still measured through the river
+			if from := water.Modes[globals.RAFT].From; math.Abs(from-2) > 0.001 {
+				t.Errorf("expected the river to start at 2 km, found %.3f km", from)
+			}
+		})
+	}
+}
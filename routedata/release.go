@@ -0,0 +1,284 @@
+package routedata
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/gpt/globals"
+)
+
+// ReleaseNotes is a structured report of the changes in a release.
+type ReleaseNotes struct {
+	Stamp           string           `json:"stamp"`
+	SegmentRenames  []Rename         `json:"segment_renames"`
+	WaypointRenames []Rename         `json:"waypoint_renames"`
+	RenamesUpdated  bool             `json:"renames_updated"` // false if the legacy names weren't updated, so the renames aren't known
+	Geometry        *GeometrySummary `json:"geometry,omitempty"`
+	Before          *ReleaseStats    `json:"before,omitempty"`
+	After           *ReleaseStats    `json:"after"`
+}
+
+// Rename is a segment or waypoint name change from the legacy rename log.
+type Rename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// GeometrySummary counts the changes since the previous release.
+type GeometrySummary struct {
+	Sections         []string `json:"sections"` // sections with changes
+	SectionsAdded    int      `json:"sections_added"`
+	SectionsRemoved  int      `json:"sections_removed"`
+	RoutesAdded      int      `json:"routes_added"`
+	RoutesRemoved    int      `json:"routes_removed"`
+	SegmentsAdded    int      `json:"segments_added"`
+	SegmentsRemoved  int      `json:"segments_removed"`
+	SegmentsMoved    int      `json:"segments_moved"`
+	SegmentsRecoded  int      `json:"segments_recoded"`
+	WaypointsAdded   int      `json:"waypoints_added"`
+	WaypointsRemoved int      `json:"waypoints_removed"`
+	WaypointsMoved   int      `json:"waypoints_moved"`
+}
+
+// ReleaseStats is the distance in km of the routes in a release.
+type ReleaseStats struct {
+	Modes         []ModeDistance `json:"modes"`
+	Verified      float64        `json:"verified_km"`
+	Approximate   float64        `json:"approximate_km"`
+	Investigation float64        `json:"investigation_km"`
+	Unspecified   float64        `json:"unspecified_km"` // segments with no verification code e.g. water
+}
+
+// ModeDistance is the distance in km of the regular and optional routes in a mode.
+type ModeDistance struct {
+	Mode     string  `json:"mode"`
+	Regular  float64 `json:"regular_km"`
+	Optional float64 `json:"optional_km"`
+}
+
+// ReleaseNotes builds the release notes. The renames are taken from the legacy rename log, so SaveMaster must be run
+// first. If previous is given, geometry changes and the distances before the release are included.
+func (d *Data) ReleaseNotes(stamp string, previous *Data) *ReleaseNotes {
+	notes := &ReleaseNotes{
+		Stamp:           stamp,
+		SegmentRenames:  []Rename{},
+		WaypointRenames: []Rename{},
+		After:           d.releaseStats(),
+	}
+	if d.renames != nil && d.renames.update {
+		notes.RenamesUpdated = true
+		// placemarks with no legacy name are new, not renamed
+		for _, r := range d.renames.segments {
+			if r.from != "" {
+				notes.SegmentRenames = append(notes.SegmentRenames, Rename{From: r.from, To: r.to})
+			}
+		}
+		for _, r := range d.renames.waypoints {
+			if r.from != "" {
+				notes.WaypointRenames = append(notes.WaypointRenames, Rename{From: r.from, To: r.to})
+			}
+		}
+	}
+	if previous != nil {
+		notes.Before = previous.releaseStats()
+		notes.Geometry = summariseChanges(Diff(previous, d))
+	}
+	return notes
+}
+
+func (d *Data) releaseStats() *ReleaseStats {
+	stats := &ReleaseStats{}
+	all := map[*Segment]bool{}
+	var segments []*Segment // in order, so the totals are deterministic
+	for _, mode := range globals.MODES {
+		distance := ModeDistance{Mode: modeName(mode)}
+		done := map[*Segment]bool{}
+		for _, key := range d.Keys {
+			if globals.HAS_SINGLE && key != globals.SINGLE {
+				continue
+			}
+			section := d.Sections[key]
+			for _, routeKey := range section.RouteKeys {
+				route := section.Routes[routeKey]
+				if route.Modes[mode] == nil {
+					continue
+				}
+				for _, segment := range route.Modes[mode].Segments {
+					if !all[segment] {
+						all[segment] = true
+						segments = append(segments, segment)
+					}
+					if done[segment] {
+						continue
+					}
+					done[segment] = true
+					if route.Key.Required == globals.REGULAR {
+						distance.Regular += segment.Length
+					} else {
+						distance.Optional += segment.Length
+					}
+				}
+			}
+		}
+		stats.Modes = append(stats.Modes, distance)
+	}
+	for _, segment := range segments {
+		switch segment.Verification {
+		case "V":
+			stats.Verified += segment.Length
+		case "A":
+			stats.Approximate += segment.Length
+		case "I":
+			stats.Investigation += segment.Length
+		default:
+			stats.Unspecified += segment.Length
+		}
+	}
+	return stats
+}
+
+func summariseChanges(c *Changes) *GeometrySummary {
+	g := &GeometrySummary{Sections: []string{}}
+	countWaypoints := func(changes []*WaypointChange) {
+		for _, w := range changes {
+			switch {
+			case w.Old == nil:
+				g.WaypointsAdded++
+			case w.New == nil:
+				g.WaypointsRemoved++
+			case w.Moved > DIFF_MOVED:
+				g.WaypointsMoved++
+			}
+		}
+	}
+	for _, s := range c.Sections {
+		switch {
+		case s.Old == nil:
+			g.SectionsAdded++
+			g.Sections = append(g.Sections, s.New.FolderName())
+		case s.New == nil:
+			g.SectionsRemoved++
+			g.Sections = append(g.Sections, s.Old.FolderName())
+		default:
+			g.Sections = append(g.Sections, s.New.FolderName())
+		}
+		for _, r := range s.Routes {
+			switch {
+			case r.Old == nil:
+				g.RoutesAdded++
+			case r.New == nil:
+				g.RoutesRemoved++
+			}
+		}
+		for _, seg := range s.Segments {
+			switch {
+			case seg.Old == nil:
+				g.SegmentsAdded++
+			case seg.New == nil:
+				g.SegmentsRemoved++
+			default:
+				if seg.Hausdorff > DIFF_MOVED {
+					g.SegmentsMoved++
+				}
+				if len(seg.Codes) > 0 {
+					g.SegmentsRecoded++
+				}
+			}
+		}
+		countWaypoints(s.Waypoints)
+	}
+	countWaypoints(c.Waypoints)
+	return g
+}
+
+// Markdown is the release notes as a Markdown document.
+func (n *ReleaseNotes) Markdown() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "# Release notes (%s)\n", n.Stamp)
+
+	fmt.Fprintln(sb)
+	fmt.Fprintln(sb, "## Distances")
+	fmt.Fprintln(sb)
+	if n.Before != nil {
+		fmt.Fprintln(sb, "| | Before | After | Change |")
+		fmt.Fprintln(sb, "|---|---:|---:|---:|")
+		row := func(name string, before, after float64) {
+			fmt.Fprintf(sb, "| %s | %.1f km | %.1f km | %+.1f km |\n", name, before, after, after-before)
+		}
+		for i, mode := range n.After.Modes {
+			row(strings.Title(mode.Mode)+" regular", n.Before.Modes[i].Regular, mode.Regular)
+			row(strings.Title(mode.Mode)+" options", n.Before.Modes[i].Optional, mode.Optional)
+		}
+		row("Verified", n.Before.Verified, n.After.Verified)
+		row("Approximate", n.Before.Approximate, n.After.Approximate)
+		row("Investigation", n.Before.Investigation, n.After.Investigation)
+		row("Unspecified", n.Before.Unspecified, n.After.Unspecified)
+	} else {
+		fmt.Fprintln(sb, "| | Distance |")
+		fmt.Fprintln(sb, "|---|---:|")
+		row := func(name string, after float64) {
+			fmt.Fprintf(sb, "| %s | %.1f km |\n", name, after)
+		}
+		for _, mode := range n.After.Modes {
+			row(strings.Title(mode.Mode)+" regular", mode.Regular)
+			row(strings.Title(mode.Mode)+" options", mode.Optional)
+		}
+		row("Verified", n.After.Verified)
+		row("Approximate", n.After.Approximate)
+		row("Investigation", n.After.Investigation)
+		row("Unspecified", n.After.Unspecified)
+	}
+
+	if g := n.Geometry; g != nil {
+		fmt.Fprintln(sb)
+		fmt.Fprintln(sb, "## Changes")
+		fmt.Fprintln(sb)
+		fmt.Fprintf(sb, "- Sections: %d added, %d removed\n", g.SectionsAdded, g.SectionsRemoved)
+		fmt.Fprintf(sb, "- Routes: %d added, %d removed\n", g.RoutesAdded, g.RoutesRemoved)
+		fmt.Fprintf(sb, "- Segments: %d added, %d removed, %d moved, %d with changed codes\n", g.SegmentsAdded, g.SegmentsRemoved, g.SegmentsMoved, g.SegmentsRecoded)
+		fmt.Fprintf(sb, "- Waypoints: %d added, %d removed, %d moved\n", g.WaypointsAdded, g.WaypointsRemoved, g.WaypointsMoved)
+		if len(g.Sections) > 0 {
+			fmt.Fprintf(sb, "- Sections with changes: %s\n", strings.Join(g.Sections, ", "))
+		}
+	}
+
+	renames := func(title string, renames []Rename) {
+		fmt.Fprintln(sb)
+		fmt.Fprintf(sb, "## %s\n", title)
+		fmt.Fprintln(sb)
+		if !n.RenamesUpdated {
+			fmt.Fprintln(sb, "Renames are only listed when the legacy names are updated (-renames).")
+			return
+		}
+		if len(renames) == 0 {
+			fmt.Fprintln(sb, "None.")
+			return
+		}
+		for _, r := range renames {
+			fmt.Fprintf(sb, "- `%s` → `%s`\n", r.From, r.To)
+		}
+	}
+	renames("Renamed segments", n.SegmentRenames)
+	renames("Renamed waypoints", n.WaypointRenames)
+	return sb.String()
+}
+
+// SaveReleaseNotes writes the release notes as Markdown and JSON next to Nomenclature.txt.
+func (d *Data) SaveReleaseNotes(dpath, stamp string, previous *Data) error {
+	logln("saving release notes")
+	notes := d.ReleaseNotes(stamp, previous)
+	base := filepath.Join(dpath, "GPX Files (For Smartphones and Basecamp)", fmt.Sprintf("Release Notes (%s)", stamp))
+	if err := writeText(base+".md", notes.Markdown()); err != nil {
+		return fmt.Errorf("writing release notes: %w", err)
+	}
+	b, err := json.MarshalIndent(notes, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding release notes: %w", err)
+	}
+	if err := writeText(base+".json", string(b)); err != nil {
+		return fmt.Errorf("writing release notes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package routedata
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/globals"
+)
+
+// HOURS_PER_DAY is the estimated hours of travel in a day, used for the days between resupplies.
+const HOURS_PER_DAY = 8
+
+// RESUPPLY_DISTANCE is the furthest a resupply location can be from the route in km (towns are often some way off the
+// trail).
+const RESUPPLY_DISTANCE = 10
+
+// ResupplyPlan is the stretches between resupply locations along a thru-hike.
+type ResupplyPlan struct {
+	Thru      *ThruHike
+	MaxDays   float64 // stretches longer than this are flagged
+	Stops     []*ResupplyStop
+	Stretches []*ResupplyStretch
+	Unplaced  []Waypoint // resupply locations further than RESUPPLY_DISTANCE from the route
+}
+
+// ResupplyStop is a resupply location projected onto the thru-hike. The start and end of the thru-hike are also stops.
+type ResupplyStop struct {
+	Waypoint Waypoint
+	Leg      *ThruLeg
+	Chainage float64 // km from the start of the thru-hike
+	Distance float64 // distance off-route in km
+}
+
+// ResupplyStretch is the route between consecutive stops.
+type ResupplyStretch struct {
+	From, To        *ResupplyStop
+	Length          float64 // km
+	Ascent, Descent float64 // m
+	Hours, Days     float64
+	Long            bool // longer than MaxDays
+}
+
+// ResupplyPlan projects the resupply locations onto the thru-hike for a mode and direction, and estimates the km,
+// ascent and days between consecutive locations.
+func (d *Data) ResupplyPlan(mode globals.ModeType, direction string, maxDays float64) (*ResupplyPlan, error) {
+	t, err := d.ThruHike(mode, direction)
+	if err != nil {
+		return nil, fmt.Errorf("building thru-hike: %w", err)
+	}
+	p := &ResupplyPlan{Thru: t, MaxDays: maxDays}
+	if len(t.Legs) == 0 {
+		return p, nil
+	}
+
+	// chainage of each point of each leg, from the start of the leg in the thru-hike
+	chainages := map[*ThruLeg][]float64{}
+	for _, leg := range t.Legs {
+		c := make([]float64, len(leg.Line))
+		c[0] = leg.From
+		for i := 1; i < len(leg.Line); i++ {
+			c[i] = c[i-1] + leg.Line[i-1].Distance(leg.Line[i])
+		}
+		chainages[leg] = c
+	}
+	total := t.Length
+
+	first, last := t.Legs[0], t.Legs[len(t.Legs)-1]
+	p.Stops = append(p.Stops, &ResupplyStop{
+		Waypoint: Waypoint{Pos: first.Line.Start(), Name: "Start"},
+		Leg:      first,
+	})
+	for _, w := range d.Resupplies {
+		var stop *ResupplyStop
+		for _, leg := range t.Legs {
+			proj := leg.Line.Project(w.Pos)
+			if stop != nil && proj.Distance >= stop.Distance {
+				continue
+			}
+			c := chainages[leg]
+			chainage := c[proj.Index]
+			if proj.Index < len(c)-1 {
+				chainage += (c[proj.Index+1] - chainage) * proj.Fraction
+			}
+			stop = &ResupplyStop{Waypoint: w, Leg: leg, Chainage: chainage, Distance: proj.Distance}
+		}
+		if stop.Distance > RESUPPLY_DISTANCE {
+			p.Unplaced = append(p.Unplaced, w)
+			continue
+		}
+		p.Stops = append(p.Stops, stop)
+	}
+	p.Stops = append(p.Stops, &ResupplyStop{
+		Waypoint: Waypoint{Pos: last.Line.End(), Name: "End"},
+		Leg:      last,
+		Chainage: total,
+	})
+	sort.SliceStable(p.Stops, func(i, j int) bool { return p.Stops[i].Chainage < p.Stops[j].Chainage })
+	// the start and end aren't needed if there's a resupply location there
+	if len(p.Stops) > 2 && p.Stops[1].Chainage <= globals.DELTA {
+		p.Stops = p.Stops[1:]
+	}
+	if len(p.Stops) > 2 && total-p.Stops[len(p.Stops)-2].Chainage <= globals.DELTA {
+		p.Stops = p.Stops[:len(p.Stops)-1]
+	}
+
+	for i := 1; i < len(p.Stops); i++ {
+		s := &ResupplyStretch{From: p.Stops[i-1], To: p.Stops[i]}
+		s.Length = s.To.Chainage - s.From.Chainage
+		for _, leg := range t.Legs {
+			c := chainages[leg]
+			for j := 1; j < len(leg.Line); j++ {
+				if c[j] <= s.From.Chainage || c[j-1] >= s.To.Chainage || c[j] == c[j-1] {
+					continue
+				}
+				// the fraction of this part of the line that's in the stretch
+				overlap := math.Min(c[j], s.To.Chainage) - math.Max(c[j-1], s.From.Chainage)
+				fraction := overlap / (c[j] - c[j-1])
+				climb := leg.Line[j].Ele - leg.Line[j-1].Ele
+				if climb > 0 {
+					s.Ascent += climb * fraction
+				} else {
+					s.Descent -= climb * fraction
+				}
+				s.Hours += Hours(leg.segments[j], geo.Line{leg.Line[j-1], leg.Line[j]}) * fraction
+			}
+		}
+		s.Days = s.Hours / HOURS_PER_DAY
+		s.Long = maxDays > 0 && s.Days > maxDays
+		p.Stretches = append(p.Stretches, s)
+	}
+	return p, nil
+}
+
+// Report is a plain text list of the stops, with the stretch between each pair.
+func (p *ResupplyPlan) Report() string {
+	sb := &strings.Builder{}
+	var long int
+	for _, s := range p.Stretches {
+		if s.Long {
+			long++
+		}
+	}
+	dir := "southbound"
+	if p.Thru.Direction == "N" {
+		dir = "northbound"
+	}
+	fmt.Fprintf(sb, "GPT %s %s: %.1f km, %d stretches between resupply locations, %d longer than %.1f days\n\n", modeName(p.Thru.Mode), dir, p.Thru.Length, len(p.Stretches), long, p.MaxDays)
+	for i, stop := range p.Stops {
+		var off string
+		if stop.Distance > globals.DELTA {
+			off = fmt.Sprintf(" (%.1f km off-route)", stop.Distance)
+		}
+		fmt.Fprintf(sb, "%7.1f km  %s, GPT%s%s\n", stop.Chainage, stop.Waypoint.Name, stop.Leg.Route.Section.Key.Code(), off)
+		if i == len(p.Stretches) {
+			continue
+		}
+		s := p.Stretches[i]
+		var flag string
+		if s.Long {
+			flag = WARNING_SYMBOL + " "
+		}
+		fmt.Fprintf(sb, "            %s%.1f km, %.0f m ascent, %.0f m descent, %.1f hours, %.1f days\n", flag, s.Length, s.Ascent, s.Descent, s.Hours, s.Days)
+	}
+	if len(p.Unplaced) > 0 {
+		fmt.Fprintf(sb, "\nMore than %d km from the route:\n", RESUPPLY_DISTANCE)
+		for _, w := range p.Unplaced {
+			fmt.Fprintf(sb, "%s %s (%.5f, %.5f)\n", WARNING_SYMBOL, w.Name, w.Lat, w.Lon)
+		}
+	}
+	return sb.String()
+}
+
+// SaveResupplyPlans writes a report and a CSV table of the stretches between resupply locations for each mode and
+// direction.
+func (d *Data) SaveResupplyPlans(dpath string, maxDays float64) error {
+	logln("saving resupply plans")
+	if len(d.Resupplies) == 0 {
+		return nil
+	}
+	for _, mode := range globals.MODES {
+		for _, direction := range []string{"S", "N"} {
+			p, err := d.ResupplyPlan(mode, direction, maxDays)
+			if err != nil {
+				return err
+			}
+			if len(p.Thru.Legs) == 0 {
+				continue
+			}
+			for _, s := range p.Stretches {
+				if s.Long {
+					logf("resupply %s: %.1f days between %s and %s\n", modeName(mode), s.Days, s.From.Waypoint.Name, s.To.Waypoint.Name)
+				}
+			}
+			dir := "southbound"
+			if direction == "N" {
+				dir = "northbound"
+			}
+
+			buf := &bytes.Buffer{}
+			w := csv.NewWriter(buf)
+			rows := [][]string{{"From", "To", "From km", "To km", "Length km", "Ascent m", "Descent m", "Hours", "Days", "Long"}}
+			for _, s := range p.Stretches {
+				var long string
+				if s.Long {
+					long = "yes"
+				}
+				rows = append(rows, []string{
+					s.From.Waypoint.Name,
+					s.To.Waypoint.Name,
+					fmt.Sprintf("%.1f", s.From.Chainage),
+					fmt.Sprintf("%.1f", s.To.Chainage),
+					fmt.Sprintf("%.1f", s.Length),
+					fmt.Sprintf("%.0f", s.Ascent),
+					fmt.Sprintf("%.0f", s.Descent),
+					fmt.Sprintf("%.1f", s.Hours),
+					fmt.Sprintf("%.1f", s.Days),
+					long,
+				})
+			}
+			if err := w.WriteAll(rows); err != nil {
+				return fmt.Errorf("writing resupply csv: %w", err)
+			}
+
+			name := fmt.Sprintf("GPT %s %s", modeName(mode), dir)
+			if err := writeText(filepath.Join(dpath, "Resupply", name+".csv"), buf.String()); err != nil {
+				return fmt.Errorf("writing resupply csv: %w", err)
+			}
+			if err := writeText(filepath.Join(dpath, "Resupply", name+".txt"), p.Report()); err != nil {
+				return fmt.Errorf("writing resupply report: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -2,8 +2,10 @@ package routedata
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/dave/gpt/geo"
 	"github.com/dave/gpt/globals"
 )
 
@@ -20,6 +22,7 @@ type Route struct {
 	Key     RouteKey
 	Name    string // track name for optional tracks
 	Option  string // name from option folder
+	Scraped string // description of the option from the wiki
 	All     []*Segment
 	Modes   map[globals.ModeType]*RouteModeData
 }
@@ -210,7 +213,7 @@ func (r *Route) BuildNetworks() error {
 
 					// ensure segments all join in regular routes
 					if !prevMode.EndPoint.Pos.IsClose(segmentMode.StartPoint.Pos, globals.DELTA) {
-						return fmt.Errorf("%q and %q are %dm apart", prev.Raw, segment.Raw, prevMode.EndPoint.Pos.Distance(segmentMode.StartPoint.Pos)*1000)
+						return fmt.Errorf("%q and %q are %.0fm apart", prev.Raw, segment.Raw, prevMode.EndPoint.Pos.Distance(segmentMode.StartPoint.Pos)*1000)
 					}
 
 					node := &Node{
@@ -232,24 +235,53 @@ func (r *Route) BuildNetworks() error {
 			}
 
 		} else {
+			// Index the end points and every position along each segment, so each end point only needs to be
+			// compared with the points nearby.
+			endsIndex := geo.NewIndex(globals.DELTA)
+			for i, end := range ends {
+				endsIndex.AddPos(end.Pos, i)
+			}
+			type vertex struct {
+				segment *Segment
+				index   int
+			}
+			vertexIndex := geo.NewIndex(globals.DELTA)
+			for _, segment := range rMode.Segments {
+				for i, pos := range segment.Line {
+					vertexIndex.AddPos(pos, vertex{segment, i})
+				}
+			}
+
 			for _, end := range ends {
 				if !all[end] {
 					all[end] = true
 					allOrdered = append(allOrdered, end)
 				}
-				for _, neighbour := range ends {
+				var neighbours []int
+				for _, hit := range endsIndex.Within(end.Pos, globals.DELTA, nil) {
+					neighbours = append(neighbours, hit.Value.(int))
+				}
+				sort.Ints(neighbours)
+				for _, i := range neighbours {
+					neighbour := ends[i]
 					if end == neighbour {
 						continue
 					}
 					if end.Segment == neighbour.Segment {
 						continue
 					}
-					if !end.Pos.IsClose(neighbour.Pos, globals.DELTA) {
-						continue
-					}
 					nearby[end] = append(nearby[end], neighbour)
 					nearby[neighbour] = append(nearby[neighbour], end)
 				}
+
+				// closest position in each segment (the first if several are equally close)
+				closest := map[*Segment]geo.Hit{}
+				for _, hit := range vertexIndex.Within(end.Pos, globals.DELTA, nil) {
+					v := hit.Value.(vertex)
+					if c, found := closest[v.segment]; !found || hit.Distance < c.Distance || (hit.Distance == c.Distance && v.index < c.Value.(vertex).index) {
+						closest[v.segment] = hit
+					}
+				}
 			Outer:
 				for _, segment := range rMode.Segments {
 					segmentMode := segment.Modes[mode]
@@ -261,10 +293,11 @@ func (r *Route) BuildNetworks() error {
 							continue Outer
 						}
 					}
-					found, index := segment.Line.IsClose(end.Pos, globals.DELTA)
+					hit, found := closest[segment]
 					if !found {
 						continue
 					}
+					index := hit.Value.(vertex).index
 					mid := &Point{Segment: segment, Index: index, Pos: segment.Line[index]}
 					nearby[end] = append(nearby[end], mid)
 					nearby[mid] = append(nearby[mid], end)
@@ -16,7 +16,11 @@ type Section struct {
 	RouteKeys []RouteKey
 	Routes    map[RouteKey]*Route
 	Waypoints []Waypoint
+	Snaps     []*WaypointSnap // parallel to Waypoints, filled by SnapWaypoints
 	Scraped   map[globals.ModeType]string
+	Summary   *SectionSummary // from the wiki Summary Table, nil if not scraped
+
+	subsections []*scrapedSubsection // wiki subsections, attached to the options by AttachOptionDescriptions
 }
 
 func (s Section) FolderName() string {
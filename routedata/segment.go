@@ -26,10 +26,12 @@ type Segment struct {
 }
 
 type SegmentModeData struct {
-	From       float64
-	StartPoint *Point
-	EndPoint   *Point
-	MidPoints  []*Point
+	From        float64
+	StartPoint  *Point
+	EndPoint    *Point
+	MidPoints   []*Point
+	Upstream    bool // river segments are drawn upstream, found by Network.BuildWater
+	FlowUnknown bool // river segments with no elevation change, so Upstream can't be found
 }
 
 func (s Segment) PlacemarkName() string {
@@ -288,6 +290,34 @@ func (s Segment) Style() string {
 //	panic("can't find segment in track")
 //}
 
+// OneWay is true if the segment can only be travelled in one direction in the mode. Forward is true if that is the
+// direction of the line, and false for rivers that are drawn upstream. Water segments are only one-way when
+// packrafting, and rivers aren't one-way if the direction of flow is unknown.
+func (s *Segment) OneWay(mode globals.ModeType) (oneWay, forward bool) {
+	if s.Directional != "1" {
+		return false, false
+	}
+	var river, water bool
+	for _, terrain := range s.Terrains {
+		switch terrain {
+		case "RI":
+			river, water = true, true
+		case "FJ", "LK", "FY":
+			water = true
+		}
+	}
+	if water && mode != globals.RAFT {
+		return false, false
+	}
+	if river {
+		if s.Modes[mode].FlowUnknown {
+			return false, false
+		}
+		return true, !s.Modes[mode].Upstream
+	}
+	return true, true
+}
+
 func (s1 Segment) Similar(s2 *Segment) bool {
 	return compareTerrain(s1.Terrains, s2.Terrains) &&
 		s1.Verification == s2.Verification &&
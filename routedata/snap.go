@@ -0,0 +1,197 @@
+package routedata
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dave/gpt/globals"
+)
+
+// WaypointSnap is a section waypoint snapped to the nearest route of its own section in each mode.
+type WaypointSnap struct {
+	Waypoint  Waypoint
+	Section   *Section
+	Locations map[globals.ModeType]*Location // only modes with a route within the snap distance
+}
+
+// SnapWaypoints snaps each section waypoint to the nearest route of its section in each mode. Routes further than max
+// km are ignored, so waypoints with no locations are probable errors.
+func (d *Data) SnapWaypoints(max float64) {
+	logln("snapping waypoints")
+	if d.locators == nil {
+		d.buildLocators()
+	}
+	for _, key := range d.Keys {
+		section := d.Sections[key]
+		section.Snaps = make([]*WaypointSnap, len(section.Waypoints))
+		filter := func(value interface{}) bool {
+			return value.(*locatorItem).route.Section == section
+		}
+		for i, w := range section.Waypoints {
+			snap := &WaypointSnap{Waypoint: w, Section: section, Locations: map[globals.ModeType]*Location{}}
+			for _, mode := range globals.MODES {
+				hit, found := d.locators[mode].Nearest(w.Pos, max, filter)
+				if !found {
+					continue
+				}
+				item := hit.Value.(*locatorItem)
+				snap.Locations[mode] = &Location{
+					Mode:     mode,
+					Route:    item.route,
+					Segment:  item.segment,
+					Pos:      hit.Pos,
+					Distance: hit.Distance,
+					Offset:   hit.Line.Along(hit.Projection),
+				}
+			}
+			if len(snap.Locations) == 0 {
+				logf("GPT%s waypoint %q is more than %.0f m from any route in the section\n", key.Code(), w.Name, max*1000)
+			}
+			section.Snaps[i] = snap
+		}
+	}
+}
+
+// Description is the section code followed by the route, chainage and off-route distance in each of the modes e.g.
+// "GPT01, hiking: regular route at 12.3 km (40 m off-route)". With no modes, all modes are described. With a single
+// mode, the mode name is omitted.
+func (s *WaypointSnap) Description(modes ...globals.ModeType) string {
+	if len(modes) == 0 {
+		modes = globals.MODES
+	}
+	var parts []string
+	for _, mode := range modes {
+		l := s.Locations[mode]
+		if l == nil {
+			continue
+		}
+		var prefix string
+		if len(modes) > 1 {
+			prefix = modeName(mode) + ": "
+		}
+		parts = append(parts, prefix+l.Description())
+	}
+	if len(parts) == 0 {
+		return "GPT" + s.Section.Key.Code()
+	}
+	return "GPT" + s.Section.Key.Code() + ", " + strings.Join(parts, ", ")
+}
+
+// Description is the route, chainage and off-route distance e.g. "option 1 (Lago Verde) at 3.4 km (120 m off-route)".
+func (l *Location) Description() string {
+	route := l.Route.Key.Debug()
+	if l.Route.Key.Required == globals.REGULAR {
+		route += " route"
+	}
+	if l.Route.Name != "" {
+		route += fmt.Sprintf(" (%s)", l.Route.Name)
+	}
+	return fmt.Sprintf("%s at %.1f km (%.0f m off-route)", route, l.Chainage(), l.Distance*1000)
+}
+
+// waypointDescription is the description of the i'th waypoint of the section. Before the waypoints are snapped it's
+// just the section code.
+func (s *Section) waypointDescription(i int, modes ...globals.ModeType) string {
+	if i >= len(s.Snaps) {
+		return "GPT" + s.Key.Code()
+	}
+	return s.Snaps[i].Description(modes...)
+}
+
+// SaveWaypointTable writes a table of the section waypoints as CSV and as Markdown with a table per section. Waypoints
+// are ordered by route and km. Waypoints that are not near any route in their section are listed as probable errors.
+func (d *Data) SaveWaypointTable(dpath string, max float64) error {
+	logln("saving waypoint table")
+	titles := []string{"Section", "Waypoint", "Mode", "Route", "Km", "Off-route m"}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.Write(titles); err != nil {
+		return fmt.Errorf("writing waypoint table csv: %w", err)
+	}
+	md := &strings.Builder{}
+	fmt.Fprintln(md, "# Waypoints")
+	for _, key := range d.Keys {
+		if globals.HAS_SINGLE && key != globals.SINGLE {
+			continue
+		}
+		section := d.Sections[key]
+		if len(section.Snaps) == 0 {
+			continue
+		}
+		order := map[*Route]int{}
+		for i, routeKey := range section.RouteKeys {
+			order[section.Routes[routeKey]] = i
+		}
+		var locations []*Location
+		var names []string
+		var far []*WaypointSnap
+		for _, snap := range section.Snaps {
+			if len(snap.Locations) == 0 {
+				far = append(far, snap)
+				continue
+			}
+			for _, mode := range globals.MODES {
+				if l := snap.Locations[mode]; l != nil {
+					locations = append(locations, l)
+					names = append(names, snap.Waypoint.Name)
+				}
+			}
+		}
+		indexes := make([]int, len(locations))
+		for i := range indexes {
+			indexes[i] = i
+		}
+		sort.SliceStable(indexes, func(i, j int) bool {
+			a, b := locations[indexes[i]], locations[indexes[j]]
+			if a.Mode != b.Mode {
+				return a.Mode < b.Mode
+			}
+			if order[a.Route] != order[b.Route] {
+				return order[a.Route] < order[b.Route]
+			}
+			return a.Chainage() < b.Chainage()
+		})
+		var rows [][]string
+		for _, i := range indexes {
+			l := locations[i]
+			rows = append(rows, []string{
+				"GPT" + key.Code(),
+				names[i],
+				modeName(l.Mode),
+				l.Route.Key.Debug(),
+				fmt.Sprintf("%.1f", l.Chainage()),
+				fmt.Sprintf("%.0f", l.Distance*1000),
+			})
+		}
+		for _, snap := range far {
+			rows = append(rows, []string{"GPT" + key.Code(), snap.Waypoint.Name, "", "", "", ""})
+		}
+		if err := w.WriteAll(rows); err != nil {
+			return fmt.Errorf("writing waypoint table csv: %w", err)
+		}
+		fmt.Fprintf(md, "\n## %s\n\n", section.FolderName())
+		fmt.Fprintf(md, "| %s |\n", strings.Join(titles[1:], " | "))
+		fmt.Fprintf(md, "|%s\n", strings.Repeat("---|", len(titles)-1))
+		for _, row := range rows[:len(locations)] {
+			fmt.Fprintf(md, "| %s |\n", strings.Join(row[1:], " | "))
+		}
+		if len(far) > 0 {
+			fmt.Fprintf(md, "\nProbable errors (more than %.0f m from any route in the section):\n\n", max*1000)
+			for _, snap := range far {
+				fmt.Fprintf(md, "- %s %s (%.5f, %.5f)\n", WARNING_SYMBOL, snap.Waypoint.Name, snap.Waypoint.Lat, snap.Waypoint.Lon)
+			}
+		}
+	}
+
+	for ext, contents := range map[string]string{"csv": buf.String(), "md": md.String()} {
+		if err := writeText(filepath.Join(dpath, "Summary", "Waypoints."+ext), contents); err != nil {
+			return fmt.Errorf("writing waypoint table: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package routedata
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/globals"
+	"github.com/dave/gpt/gpx"
+)
+
+// ThruHike is the regular routes of consecutive sections chained into one continuous route.
+type ThruHike struct {
+	Mode        globals.ModeType
+	Direction   string // "S": southbound, "N": northbound
+	Legs        []*ThruLeg
+	Terminators []Terminator // shared start / end points of consecutive legs
+	Gaps        []*ThruGap
+	Length      float64 // km, including the gaps between legs
+}
+
+// ThruLeg is the regular route of one section in a thru-hike.
+type ThruLeg struct {
+	Route    *Route
+	From     float64  // chainage in km from the start of the thru-hike
+	Length   float64  // km along the line
+	Line     geo.Line // in the direction of travel
+	Reversed bool     // the route has no route for this direction, so it's travelled in reverse
+	// One-way segments that are travelled against their direction.
+	Violations []*Segment
+
+	segments []*Segment // segment of the line between each point and the previous point
+}
+
+// ThruGap is where the end of one leg doesn't meet the start of the next.
+type ThruGap struct {
+	From, To *ThruLeg
+	Distance float64 // km
+}
+
+// ThruHike chains the regular routes of all sections in a mode. Sections are in order of section number (reversed for
+// northbound). Where there are several sections with the same number (e.g. GPT36 and GPT36H), the one that starts
+// nearest the end of the previous leg is used. Routes for the direction are used if the section has them, otherwise
+// the regular route is used (reversed for northbound).
+func (d *Data) ThruHike(mode globals.ModeType, direction string) (*ThruHike, error) {
+	t := &ThruHike{Mode: mode, Direction: direction}
+
+	// group the sections by number
+	var numbers []int
+	byNumber := map[int][]*Section{}
+	for _, key := range d.Keys {
+		if globals.HAS_SINGLE && key != globals.SINGLE {
+			continue
+		}
+		section := d.Sections[key]
+		ok, err := shouldEmitSection(mode, section)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if byNumber[key.Number] == nil {
+			numbers = append(numbers, key.Number)
+		}
+		byNumber[key.Number] = append(byNumber[key.Number], section)
+	}
+	if direction == "N" {
+		for i, j := 0, len(numbers)-1; i < j; i, j = i+1, j-1 {
+			numbers[i], numbers[j] = numbers[j], numbers[i]
+		}
+	}
+
+	for _, number := range numbers {
+		var best *ThruLeg
+		var bestDistance float64
+		for _, section := range byNumber[number] {
+			leg := thruLeg(section, mode, direction)
+			if leg == nil {
+				continue
+			}
+			if len(t.Legs) == 0 {
+				// for the first leg, prefer the section with no suffix
+				if best == nil || leg.Route.Section.Key.Suffix == "" {
+					best = leg
+				}
+				continue
+			}
+			distance := t.Legs[len(t.Legs)-1].Line.End().Distance(leg.Line.Start())
+			if best == nil || distance < bestDistance {
+				best, bestDistance = leg, distance
+			}
+		}
+		if best == nil {
+			continue
+		}
+		if len(t.Legs) > 0 {
+			previous := t.Legs[len(t.Legs)-1]
+			if bestDistance > globals.DELTA {
+				// the gap is counted in the chainage, as it's part of the journey
+				t.Gaps = append(t.Gaps, &ThruGap{From: previous, To: best, Distance: bestDistance})
+				t.Length += bestDistance
+			} else {
+				t.Terminators = append(t.Terminators, Terminator{
+					Pos:      previous.Line.End(),
+					Raw:      fmt.Sprintf("GPT%s/GPT%s", previous.Route.Section.Key.Code(), best.Route.Section.Key.Code()),
+					Name:     fmt.Sprintf("GPT%s/GPT%s", previous.Route.Section.Key.Code(), best.Route.Section.Key.Code()),
+					Sections: []globals.SectionKey{previous.Route.Section.Key, best.Route.Section.Key},
+				})
+			}
+		}
+		best.From = t.Length
+		t.Length += best.Length
+		t.Legs = append(t.Legs, best)
+	}
+	return t, nil
+}
+
+// thruLeg finds the regular route of a section for a mode and direction.
+func thruLeg(section *Section, mode globals.ModeType, direction string) *ThruLeg {
+	var route *Route
+	for _, key := range section.RouteKeys {
+		if key.Required != globals.REGULAR || section.Routes[key].Modes[mode] == nil {
+			continue
+		}
+		if key.Direction == direction || route == nil {
+			route = section.Routes[key]
+		}
+	}
+	if route == nil {
+		return nil
+	}
+	leg := &ThruLeg{Route: route}
+	for _, segment := range route.Modes[mode].Segments {
+		line := segment.Line
+		if len(leg.Line) > 0 && leg.Line.End().IsClose(line.Start(), globals.DELTA) {
+			// skip the join between adjacent segments
+			line = line[1:]
+		}
+		leg.Line = append(leg.Line, line...)
+		for range line {
+			leg.segments = append(leg.segments, segment)
+		}
+	}
+	leg.Length = leg.Line.Length()
+	if direction == "N" && route.Key.Direction != "N" {
+		leg.Reversed = true
+		leg.Line.Reverse()
+		// the line between points i-1 and i was between points n-i and n-i+1 before reversing
+		segments := make([]*Segment, len(leg.segments))
+		for i := 1; i < len(segments); i++ {
+			segments[i] = leg.segments[len(segments)-i]
+		}
+		leg.segments = segments
+	}
+	network := route.Modes[mode].Network
+	for _, segment := range route.Modes[mode].Segments {
+		if !network.allowedAlong(segment, !leg.Reversed) {
+			leg.Violations = append(leg.Violations, segment)
+		}
+	}
+	return leg
+}
+
+// Report is a plain text list of the legs and gaps.
+func (t *ThruHike) Report() string {
+	sb := &strings.Builder{}
+	dir := "southbound"
+	if t.Direction == "N" {
+		dir = "northbound"
+	}
+	fmt.Fprintf(sb, "GPT %s %s: %.1f km, %d sections\n\n", modeName(t.Mode), dir, t.Length, len(t.Legs))
+	for _, leg := range t.Legs {
+		var reversed string
+		if leg.Reversed {
+			reversed = " (reversed)"
+		}
+		fmt.Fprintf(sb, "%7.1f km  %s%s  %.1f km\n", leg.From, leg.Route.Debug(), reversed, leg.Length)
+		for _, segment := range leg.Violations {
+			fmt.Fprintf(sb, "           %s One-way segment travelled against its direction: %s\n", WARNING_SYMBOL, segment.PlacemarkName())
+		}
+	}
+	fmt.Fprintln(sb)
+	if len(t.Gaps) == 0 {
+		fmt.Fprintln(sb, "No gaps.")
+	}
+	for _, gap := range t.Gaps {
+		fmt.Fprintf(sb, "Gap of %.2f km between the end of GPT%s and the start of GPT%s at %.1f km\n", gap.Distance, gap.From.Route.Section.Key.Code(), gap.To.Route.Section.Key.Code(), gap.From.From+gap.From.Length)
+	}
+	return sb.String()
+}
+
+// SaveThruHikes writes a GPX file and report of the whole trail for each mode and direction. Each section is a track,
+// and the shared start / end points are waypoints.
+func (d *Data) SaveThruHikes(dpath, stamp string, simplify geo.Simplification) error {
+	logln("saving thru-hikes")
+	for _, mode := range globals.MODES {
+		for _, direction := range []string{"S", "N"} {
+			t, err := d.ThruHike(mode, direction)
+			if err != nil {
+				return fmt.Errorf("building thru-hike: %w", err)
+			}
+			if len(t.Legs) == 0 {
+				continue
+			}
+			for _, gap := range t.Gaps {
+				logf("thru-hike %s: gap of %.2f km between GPT%s and GPT%s\n", modeName(mode), gap.Distance, gap.From.Route.Section.Key.Code(), gap.To.Route.Section.Key.Code())
+			}
+			dir := "southbound"
+			if direction == "N" {
+				dir = "northbound"
+			}
+			for _, leg := range t.Legs {
+				for _, segment := range leg.Violations {
+					logf("thru-hike %s %s: one-way segment %q is travelled against its direction\n", modeName(mode), dir, segment.Raw)
+				}
+			}
+			root := gpx.Root{Version: 1.1}
+			for _, terminator := range t.Terminators {
+				root.Waypoints = append(root.Waypoints, gpx.Waypoint{
+					Point: gpx.PosPoint(terminator.Pos),
+					Name:  terminator.Name,
+				})
+			}
+			for _, leg := range t.Legs {
+				root.Tracks = append(root.Tracks, gpx.Track{
+					Name:     fmt.Sprintf("%s %s", garminName(leg.Route), dir),
+					Desc:     fmt.Sprintf("%.1f km from the start, %.1f km long", leg.From, leg.Length),
+					Segments: []gpx.TrackSegment{{Points: gpx.LineTrackPoints(simplify.Apply(leg.Line))}},
+				})
+			}
+			name := fmt.Sprintf("GPT %s %s (%s)", modeName(mode), dir, stamp)
+			if err := root.Save(filepath.Join(dpath, "Thru-Hike", name+".gpx")); err != nil {
+				return fmt.Errorf("writing thru-hike gpx: %w", err)
+			}
+			if err := writeText(filepath.Join(dpath, "Thru-Hike", name+".txt"), t.Report()); err != nil {
+				return fmt.Errorf("writing thru-hike report: %w", err)
+			}
+		}
+	}
+	return nil
+}
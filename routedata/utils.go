@@ -2,6 +2,9 @@ package routedata
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	"github.com/dave/gpt/globals"
 )
@@ -35,3 +38,23 @@ func debugfln(format string, a ...interface{}) {
 		fmt.Printf(format+"\n", a...)
 	}
 }
+
+func modeName(mode globals.ModeType) string {
+	switch mode {
+	case globals.HIKE:
+		return "hiking"
+	case globals.RAFT:
+		return "packrafting"
+	}
+	return ""
+}
+
+// writeText writes a text file, creating the directory if needed.
+func writeText(fpath, contents string) error {
+	dpath, _ := filepath.Split(fpath)
+	_ = os.MkdirAll(dpath, 0777)
+	if err := ioutil.WriteFile(fpath, []byte(contents), 0666); err != nil {
+		return fmt.Errorf("writing %q: %w", fpath, err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package routedata
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/gpt/geo"
+	"github.com/dave/gpt/kml"
+)
+
+// VERIFY_COVERAGE is the fraction of a segment that a recording must follow to count towards verification.
+const VERIFY_COVERAGE = 0.9
+
+// DUPLICATE_DISTANCE is the distance in km within which the pieces of two recordings that follow a segment are
+// treated as the same recording (e.g. the same file submitted twice, or two exports of one track). Independent
+// recordings differ by more than this because of GPS noise.
+const DUPLICATE_DISTANCE = 0.003
+
+// CENTRELINE_INTERVAL is the distance in km between the points of an averaged centreline.
+const CENTRELINE_INTERVAL = 0.02
+
+// Suggestions aggregates many recordings per segment, and suggests changes to the master file.
+type Suggestions struct {
+	Recordings int // number of recordings needed to suggest a change
+	Tolerance  float64
+	Segments   []*SegmentSuggestion // segments followed by at least one recording, in master file order
+}
+
+// SegmentSuggestion is the recordings that closely followed a segment, and the changes they suggest.
+type SegmentSuggestion struct {
+	Segment    *Segment
+	Recordings []string     // names of the recordings that followed the segment
+	Duplicates []string     // names of recordings that were ignored because they duplicate another recording
+	Tracks     [][]geo.Line // pieces of each recording that followed the segment
+	Promote    bool         // suggest changing the verification status from I or A to V
+	Centreline geo.Line     // averaged centreline, or nil if there are not enough recordings
+	Shift      float64      // maximum distance the centreline moved in km
+}
+
+// Verify aggregates comparisons of recordings per segment. Segments that have been closely followed by at least
+// recordings independent recordings get an averaged centreline, and are promoted to V if they are I or A. Recordings
+// that follow a segment within DUPLICATE_DISTANCE of a recording already counted are not independent, so they are
+// not counted.
+func (d *Data) Verify(comparisons []*Comparison, recordings int) *Suggestions {
+	v := &Suggestions{Recordings: recordings}
+	found := map[*Segment]*SegmentSuggestion{}
+	for _, c := range comparisons {
+		v.Tolerance = c.Tolerance
+		for _, cov := range c.Coverage {
+			if cov.Fraction() < VERIFY_COVERAGE {
+				continue
+			}
+			sv, ok := found[cov.Segment]
+			if !ok {
+				sv = &SegmentSuggestion{Segment: cov.Segment}
+				found[cov.Segment] = sv
+			}
+			if duplicate := sv.duplicate(cov.Tracks); duplicate != "" {
+				sv.Duplicates = append(sv.Duplicates, fmt.Sprintf("%s (same as %s)", c.Name, duplicate))
+				continue
+			}
+			sv.Recordings = append(sv.Recordings, c.Name)
+			sv.Tracks = append(sv.Tracks, cov.Tracks)
+		}
+	}
+
+	// iterate the routes so the segments are in master file order
+	done := map[*Segment]bool{}
+	for _, key := range d.Keys {
+		section := d.Sections[key]
+		for _, routeKey := range section.RouteKeys {
+			for _, segment := range section.Routes[routeKey].All {
+				sv, ok := found[segment]
+				if !ok || done[segment] {
+					continue
+				}
+				done[segment] = true
+				if len(sv.Recordings) >= recordings {
+					sv.Promote = segment.Verification == "I" || segment.Verification == "A"
+					sv.Centreline, sv.Shift = averageLine(segment.Line, sv.Tracks, v.Tolerance, recordings)
+				}
+				v.Segments = append(v.Segments, sv)
+			}
+		}
+	}
+	return v
+}
+
+// duplicate finds a recording already counted for the segment that follows it within DUPLICATE_DISTANCE of tracks,
+// and returns its name.
+func (sv *SegmentSuggestion) duplicate(tracks []geo.Line) string {
+	for i, other := range sv.Tracks {
+		if tracksHausdorff(tracks, other) < DUPLICATE_DISTANCE {
+			return sv.Recordings[i]
+		}
+	}
+	return ""
+}
+
+// tracksHausdorff is the greatest distance in km from a point of either set of lines to the nearest line in the
+// other set.
+func tracksHausdorff(a, b []geo.Line) float64 {
+	var max float64
+	for _, pair := range [][2][]geo.Line{{a, b}, {b, a}} {
+		for _, line := range pair[0] {
+			for _, p := range line {
+				nearest := -1.0
+				for _, other := range pair[1] {
+					if d := other.Project(p).Distance; nearest < 0 || d < nearest {
+						nearest = d
+					}
+				}
+				if nearest > max {
+					max = nearest
+				}
+			}
+		}
+	}
+	return max
+}
+
+// averageLine moves each point of the line to the mean of the nearest points on the recordings. Points are only moved
+// when at least min recordings are within tolerance km. The ends of the line are not moved so the segment still joins
+// its neighbours.
+func averageLine(line geo.Line, recordings [][]geo.Line, tolerance float64, min int) (geo.Line, float64) {
+	resampled := line.Resample(CENTRELINE_INTERVAL)
+	averaged := make(geo.Line, len(resampled))
+	var shift float64
+	for i, pos := range resampled {
+		averaged[i] = pos
+		if i == 0 || i == len(resampled)-1 {
+			continue
+		}
+		var lat, lon float64
+		var count int
+		for _, tracks := range recordings {
+			var nearest *geo.Projection
+			for _, track := range tracks {
+				proj := track.Project(pos)
+				if proj.Distance < tolerance && (nearest == nil || proj.Distance < nearest.Distance) {
+					nearest = &proj
+				}
+			}
+			if nearest != nil {
+				lat += nearest.Pos.Lat
+				lon += nearest.Pos.Lon
+				count++
+			}
+		}
+		if count < min {
+			continue
+		}
+		averaged[i] = geo.Pos{Lat: lat / float64(count), Lon: lon / float64(count), Ele: pos.Ele}
+		if d := pos.Distance(averaged[i]); d > shift {
+			shift = d
+		}
+	}
+	return averaged.Simplify(CENTRELINE_INTERVAL / 10), shift
+}
+
+// Report is a plain text summary of the suggested changes.
+func (v *Suggestions) Report() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "Verification from recordings (%d needed, %.0f%% coverage, tolerance %.0f m)\n", v.Recordings, VERIFY_COVERAGE*100, v.Tolerance*1000)
+	fmt.Fprintln(sb)
+	for _, sv := range v.Segments {
+		fmt.Fprintf(sb, "%s (%s)\n", sv.Segment.PlacemarkName(), sv.Segment.Route.Section.FolderName())
+		fmt.Fprintf(sb, "  %d recordings: %s\n", len(sv.Recordings), strings.Join(sv.Recordings, ", "))
+		if len(sv.Duplicates) > 0 {
+			fmt.Fprintf(sb, "  ignored duplicates: %s\n", strings.Join(sv.Duplicates, ", "))
+		}
+		if sv.Promote {
+			fmt.Fprintf(sb, "  suggest promoting %s to V\n", sv.Segment.Verification)
+		}
+		if sv.Centreline != nil {
+			fmt.Fprintf(sb, "  averaged centreline moves up to %.0f m\n", sv.Shift*1000)
+		}
+	}
+	if len(v.Segments) == 0 {
+		fmt.Fprintln(sb, "No segments were closely followed by any recording.")
+	}
+	return sb.String()
+}
+
+// Save writes the review report and a patch KMZ with the proposed segments. The master file is not changed. Each
+// placemark in the patch has the current segment name as its legacy name.
+func (v *Suggestions) Save(dpath string) error {
+	if err := writeText(filepath.Join(dpath, "Verification report.txt"), v.Report()); err != nil {
+		return fmt.Errorf("writing verification report: %w", err)
+	}
+
+	folder := &kml.Folder{Name: "Proposed changes", Visibility: 1, Open: 1}
+	for _, sv := range v.Segments {
+		if sv.Centreline == nil {
+			continue
+		}
+		proposed := *sv.Segment
+		if sv.Promote {
+			proposed.Verification = "V"
+		}
+		var description []string
+		if sv.Promote {
+			description = append(description, fmt.Sprintf("Verification %s to V.", sv.Segment.Verification))
+		}
+		description = append(description, fmt.Sprintf("Averaged from %d recordings, moved up to %.0f m.", len(sv.Recordings), sv.Shift*1000))
+		folder.Placemarks = append(folder.Placemarks, &kml.Placemark{
+			Name:        proposed.PlacemarkName(),
+			Legacy:      sv.Segment.PlacemarkName(),
+			Description: strings.Join(description, " "),
+			Visibility:  1,
+			StyleUrl:    fmt.Sprintf("#%s", proposed.Style()),
+			LineString: &kml.LineString{
+				Tessellate:  true,
+				Coordinates: kml.LineCoordinates(sv.Centreline),
+			},
+		})
+	}
+
+	doc := kml.Document{
+		Name:    "Proposed changes.kmz",
+		Folders: []*kml.Folder{folder},
+	}
+	addSegmentStyles(&doc)
+	root := kml.Root{
+		Xmlns:    "http://www.opengis.net/kml/2.2",
+		Document: doc,
+	}
+	if err := root.Save(filepath.Join(dpath, "Proposed changes.kmz")); err != nil {
+		return fmt.Errorf("writing proposed changes kmz: %w", err)
+	}
+	return nil
+}